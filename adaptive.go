@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// SearchStats records how the placements of a puzzle fared in a search,
+// for the adaptive order to try first those that led furthest before. It
+// is indexed like the search's ConflictGraph and may be shared by the
+// Solvers of a search, which update it atomically.
+type SearchStats struct {
+	// Tries[p] is the number of times placement p of the graph was tried
+	// and Depth[p] the total over those of the most pieces placed below
+	// it.
+	Tries, Depth []uint64
+	// DeadEnds[i] is the number of times the i'th piece had no
+	// placements left.
+	DeadEnds []uint64
+}
+
+// NewSearchStats returns empty statistics for a search of g.
+func NewSearchStats(g *ConflictGraph) *SearchStats {
+	return &SearchStats{
+		Tries:    make([]uint64, len(g.Placements)),
+		Depth:    make([]uint64, len(g.Placements)),
+		DeadEnds: make([]uint64, len(g.Pieces)),
+	}
+}
+
+// tried records that placement p was tried and at most depth pieces were
+// placed below it.
+func (s *SearchStats) tried(p, depth int) {
+	atomic.AddUint64(&s.Tries[p], 1)
+	atomic.AddUint64(&s.Depth[p], uint64(depth))
+}
+
+// deadEnd records that the i'th piece had no placements left.
+func (s *SearchStats) deadEnd(i int) {
+	atomic.AddUint64(&s.DeadEnds[i], 1)
+}
+
+// survival returns how deep the search got on average below placement p,
+// or -1 if it hasn't been tried.
+func (s *SearchStats) survival(p int) float64 {
+	tries := atomic.LoadUint64(&s.Tries[p])
+	if tries == 0 {
+		return -1
+	}
+	return float64(atomic.LoadUint64(&s.Depth[p])) / float64(tries)
+}
+
+// adaptive is the state of -order adaptive: whether it is on, the
+// -profile to start from, if any, and the last search's graph and
+// statistics to save to it.
+var adaptive struct {
+	on      bool
+	profile *Profile
+	g       *ConflictGraph
+	stats   *SearchStats
+}
+
+// adaptiveStats returns the statistics the Solvers of a search of g
+// share, or nil if the order isn't adaptive.
+func adaptiveStats(g *ConflictGraph) *SearchStats {
+	if !adaptive.on {
+		return nil
+	}
+	stats := NewSearchStats(g)
+	if adaptive.profile != nil {
+		adaptive.profile.Apply(g, stats)
+	}
+	adaptive.g, adaptive.stats = g, stats
+	return stats
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Temperature schedule of the annealing backend. The temperature decays
+// geometrically from annealStartTemp to annealEndTemp over the steps.
+const (
+	annealStartTemp = 2.0
+	annealEndTemp   = 0.02
+)
+
+// violationsOf returns the number of cells by which the i'th piece in the
+// chain and the other pieces overlap or share an edge, counted in both
+// directions. Counting cells rather than pieces gives the annealing a
+// smoother landscape to descend.
+func violationsOf(c PieceChain, i int) int {
+	n := 0
+	m := c[i].Piece.Masks[c[i].MaskIndex]
+	s := c[i].Piece.Shadows[c[i].MaskIndex]
+	for j, q := range c {
+		if j != i {
+			n += int(m.AndWith(q.Piece.Shadows[q.MaskIndex]).BitsSet())
+			n += int(s.AndWith(q.Piece.Masks[q.MaskIndex]).BitsSet())
+		}
+	}
+	return n
+}
+
+// violations returns the total violation score of the chain.
+func violations(c PieceChain) int {
+	n := 0
+	for i := range c {
+		n += violationsOf(c, i)
+	}
+	return n / 2
+}
+
+// annealPlay runs a simulated annealing local search. It starts off with
+// every piece at a random placement, touching and overlapping allowed,
+// and keeps re-placing single pieces to bring the number of conflicting
+// cells down to zero. Like beamPlay it is incomplete and gives up
+// after the given number of steps.
+func annealPlay(pieces []*Piece, steps int, rng *rand.Rand, sink Sink, rep Reporter) Result {
+	start := time.Now()
+	chain := make(PieceChain, len(pieces))
+	for i, p := range pieces {
+		chain[i] = PieceMask{p, rng.Intn(len(p.Masks))}
+	}
+	score := violations(chain)
+	temp := annealStartTemp
+	cooling := math.Pow(annealEndTemp/annealStartTemp, 1/float64(steps))
+
+	for step := 0; step < steps && score > 0; step++ {
+		// Mostly move pieces that are in trouble, there is little point
+		// moving the happy ones.
+		i := rng.Intn(len(chain))
+		for tries := 0; tries < len(chain) && violationsOf(chain, i) == 0; tries++ {
+			i = rng.Intn(len(chain))
+		}
+
+		old := chain[i]
+		before := violationsOf(chain, i)
+		chain[i].MaskIndex = rng.Intn(len(old.Piece.Masks))
+		delta := violationsOf(chain, i) - before
+
+		if delta > 0 && rng.Float64() >= math.Exp(-float64(delta)/temp) {
+			chain[i] = old
+		} else {
+			score += delta
+		}
+		temp *= cooling
+	}
+
+	if score > 0 {
+		rep.Message(fmt.Sprintf(" annealing stuck with %d conflicts", chain.Conflicts()))
+		return heuristicResult(nil, sink, start)
+	}
+	return heuristicResult(announce(rep, chain, nil), sink, start)
+}
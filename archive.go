@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// An archive stores millions of solutions compactly while still allowing
+// any one of them to be read by its number. The layout is:
+//
+//	header:  "HREENARC", version byte, uvarint piece count, then per
+//	         piece a uvarint length prefixed symbol and its uvarint
+//	         number of masks
+//	blocks:  flate compressed runs of up to archiveBlockSize solutions,
+//	         each solution the mask index of every piece in header order
+//	         bit-packed into just enough bits for that piece's masks
+//	index:   uvarint block count, then per block its uvarint file offset
+//	         and uvarint number of solutions
+//	trailer: little endian uint64 index offset and uint64 solution count
+//	         followed by "HREENIDX"
+const (
+	archiveMagic        = "HREENARC"
+	archiveTrailerMagic = "HREENIDX"
+	archiveVersion      = 1
+	archiveBlockSize    = 4096
+	archiveTrailerSize  = 8 + 8 + len(archiveTrailerMagic)
+)
+
+// archiveBlock locates a block in an archive.
+type archiveBlock struct {
+	offset uint64
+	count  uint64
+}
+
+// maskIndexBits returns the number of bits needed to store any mask
+// index of a piece with n masks.
+func maskIndexBits(n int) uint {
+	if n <= 1 {
+		return 1
+	}
+	return uint(bits.Len(uint(n - 1)))
+}
+
+// bitWriter packs values of arbitrary bit widths into bytes, least
+// significant bit first.
+type bitWriter struct {
+	buf  []byte
+	used uint
+}
+
+func (w *bitWriter) write(v uint64, width uint) {
+	for i := uint(0); i < width; i++ {
+		if w.used%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte((v>>i)&1) << (w.used % 8)
+		w.used++
+	}
+}
+
+// readBits reads a value of the given width written by a bitWriter at
+// the given bit offset.
+func readBits(buf []byte, offset, width uint) uint64 {
+	v := uint64(0)
+	for i := uint(0); i < width; i++ {
+		b := offset + i
+		v |= uint64((buf[b/8]>>(b%8))&1) << i
+	}
+	return v
+}
+
+// ArchiveWriter writes solutions to a new archive. It is a SolutionStore.
+type ArchiveWriter struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	index   map[*Piece]int
+	widths  []uint
+	block   bitWriter
+	inBlock uint64
+	offset  uint64
+	blocks  []archiveBlock
+	total   uint64
+}
+
+// CreateArchive creates an archive at path for solutions of pieces.
+func CreateArchive(path string, pieces []*Piece) (*ArchiveWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &ArchiveWriter{
+		f:      f,
+		w:      bufio.NewWriter(f),
+		index:  map[*Piece]int{},
+		widths: make([]uint, len(pieces)),
+	}
+
+	var hdr bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) {
+		hdr.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+	}
+	hdr.WriteString(archiveMagic)
+	hdr.WriteByte(archiveVersion)
+	putUvarint(uint64(len(pieces)))
+	for i, p := range pieces {
+		a.index[p] = i
+		a.widths[i] = maskIndexBits(len(p.Masks))
+		putUvarint(uint64(len(p.Symbol)))
+		hdr.WriteString(p.Symbol)
+		putUvarint(uint64(len(p.Masks)))
+	}
+	if _, err := a.w.Write(hdr.Bytes()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	a.offset = uint64(hdr.Len())
+	return a, nil
+}
+
+// Append adds a solution to the archive. The solution must place every
+// piece the archive was created for.
+func (a *ArchiveWriter) Append(c PieceChain) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(c) != len(a.widths) {
+		return fmt.Errorf("archive expects %d pieces, solution has %d", len(a.widths), len(c))
+	}
+	masks := make([]int, len(a.widths))
+	for _, p := range c {
+		i, ok := a.index[p.Piece]
+		if !ok {
+			return fmt.Errorf("piece %s is not in the archive", p.Piece.Symbol)
+		}
+		masks[i] = p.MaskIndex
+	}
+	for i, mi := range masks {
+		a.block.write(uint64(mi), a.widths[i])
+	}
+	a.inBlock++
+	if a.inBlock == archiveBlockSize {
+		return a.flushBlock()
+	}
+	return nil
+}
+
+// flushBlock compresses and writes out the current block.
+func (a *ArchiveWriter) flushBlock() error {
+	if a.inBlock == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	fw.Write(a.block.buf)
+	fw.Close()
+	if _, err := a.w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	a.blocks = append(a.blocks, archiveBlock{a.offset, a.inBlock})
+	a.offset += uint64(buf.Len())
+	a.total += a.inBlock
+	a.block = bitWriter{}
+	a.inBlock = 0
+	return nil
+}
+
+// Close writes the last block and the index and closes the archive.
+func (a *ArchiveWriter) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.flushBlock(); err != nil {
+		a.f.Close()
+		return err
+	}
+	var idx bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	idx.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(a.blocks)))])
+	for _, b := range a.blocks {
+		idx.Write(tmp[:binary.PutUvarint(tmp[:], b.offset)])
+		idx.Write(tmp[:binary.PutUvarint(tmp[:], b.count)])
+	}
+	binary.Write(&idx, binary.LittleEndian, a.offset)
+	binary.Write(&idx, binary.LittleEndian, a.total)
+	idx.WriteString(archiveTrailerMagic)
+	if _, err := a.w.Write(idx.Bytes()); err != nil {
+		a.f.Close()
+		return err
+	}
+	if err := a.w.Flush(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
+
+// Archive reads solutions from an archive by their number.
+type Archive struct {
+	f          *os.File
+	Symbols    []string
+	MaskCounts []int
+	widths     []uint
+	blocks     []archiveBlock
+	// starts[i] is the number of the first solution in blocks[i].
+	starts      []uint64
+	indexOffset uint64
+	Count       uint64
+	// The most recently read block is kept decompressed so that reading
+	// solutions in order doesn't decompress each block many times.
+	cached      int
+	cachedBlock []byte
+}
+
+// errNotArchive is returned when opening a file that isn't an archive or
+// one that was never closed properly.
+var errNotArchive = errors.New("not a complete hreen archive")
+
+// OpenArchive opens the archive at path.
+func OpenArchive(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{f: f, cached: -1}
+	if err := a.readIndex(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return a, nil
+}
+
+// readIndex reads the header, the trailer and the index.
+func (a *Archive) readIndex() error {
+	r := bufio.NewReader(a.f)
+	magic := make([]byte, len(archiveMagic)+1)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic[:len(archiveMagic)]) != archiveMagic {
+		return errNotArchive
+	}
+	if magic[len(archiveMagic)] != archiveVersion {
+		return fmt.Errorf("unsupported archive version %d", magic[len(archiveMagic)])
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return errNotArchive
+	}
+	for i := uint64(0); i < n; i++ {
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			return errNotArchive
+		}
+		sym := make([]byte, l)
+		if _, err := io.ReadFull(r, sym); err != nil {
+			return errNotArchive
+		}
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return errNotArchive
+		}
+		a.Symbols = append(a.Symbols, string(sym))
+		a.MaskCounts = append(a.MaskCounts, int(count))
+		a.widths = append(a.widths, maskIndexBits(int(count)))
+	}
+
+	info, err := a.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < int64(archiveTrailerSize) {
+		return errNotArchive
+	}
+	trailer := make([]byte, archiveTrailerSize)
+	if _, err := a.f.ReadAt(trailer, info.Size()-int64(archiveTrailerSize)); err != nil {
+		return err
+	}
+	if string(trailer[16:]) != archiveTrailerMagic {
+		return errNotArchive
+	}
+	a.indexOffset = binary.LittleEndian.Uint64(trailer[0:8])
+	a.Count = binary.LittleEndian.Uint64(trailer[8:16])
+
+	idx := make([]byte, uint64(info.Size())-uint64(archiveTrailerSize)-a.indexOffset)
+	if _, err := a.f.ReadAt(idx, int64(a.indexOffset)); err != nil {
+		return err
+	}
+	ir := bytes.NewReader(idx)
+	nblocks, err := binary.ReadUvarint(ir)
+	if err != nil {
+		return errNotArchive
+	}
+	start := uint64(0)
+	for i := uint64(0); i < nblocks; i++ {
+		offset, err1 := binary.ReadUvarint(ir)
+		count, err2 := binary.ReadUvarint(ir)
+		if err1 != nil || err2 != nil {
+			return errNotArchive
+		}
+		a.blocks = append(a.blocks, archiveBlock{offset, count})
+		a.starts = append(a.starts, start)
+		start += count
+	}
+	return nil
+}
+
+// Get returns the mask index of each piece, in the archive's piece order,
+// of the n'th solution counting from 0.
+func (a *Archive) Get(n uint64) ([]int, error) {
+	if n >= a.Count {
+		return nil, fmt.Errorf("solution %d out of range, archive has %d", n, a.Count)
+	}
+	bi := sort.Search(len(a.starts), func(i int) bool { return a.starts[i] > n }) - 1
+	if bi != a.cached {
+		b := a.blocks[bi]
+		end := a.indexOffset
+		if bi+1 < len(a.blocks) {
+			end = a.blocks[bi+1].offset
+		}
+		compressed := make([]byte, end-b.offset)
+		if _, err := a.f.ReadAt(compressed, int64(b.offset)); err != nil {
+			return nil, err
+		}
+		block, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+		if err != nil {
+			return nil, err
+		}
+		a.cached, a.cachedBlock = bi, block
+	}
+	block := a.cachedBlock
+
+	solutionBits := uint(0)
+	for _, w := range a.widths {
+		solutionBits += w
+	}
+	offset := uint(n-a.starts[bi]) * solutionBits
+	masks := make([]int, len(a.widths))
+	for i, w := range a.widths {
+		masks[i] = int(readBits(block, offset, w))
+		offset += w
+	}
+	return masks, nil
+}
+
+// Chain returns the n'th solution as a chain of the given pieces, which
+// must match the pieces the archive was written for by symbol.
+func (a *Archive) Chain(n uint64, pieces []*Piece) (PieceChain, error) {
+	bySymbol := map[string]*Piece{}
+	for _, p := range pieces {
+		bySymbol[p.Symbol] = p
+	}
+	masks, err := a.Get(n)
+	if err != nil {
+		return nil, err
+	}
+	chain := make(PieceChain, len(masks))
+	for i, mi := range masks {
+		p, ok := bySymbol[a.Symbols[i]]
+		if !ok || len(p.Masks) != a.MaskCounts[i] {
+			return nil, fmt.Errorf("archive piece %s doesn't match the puzzle", a.Symbols[i])
+		}
+		chain[i] = PieceMask{p, mi}
+	}
+	return chain, nil
+}
+
+// Close closes the archive.
+func (a *Archive) Close() error {
+	return a.f.Close()
+}
+
+// archiveCommand implements `hreen archive ls|get|export FILE ...`.
+func archiveCommand(args []string) {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	source := puzzleFlag(fs)
+	output := outputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen archive ls FILE")
+		fmt.Fprintln(os.Stderr, "       hreen archive get FILE N...")
+		fmt.Fprintln(os.Stderr, "       hreen archive export FILE")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	checkOutput(*output)
+	a, err := OpenArchive(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	defer a.Close()
+	pieces := source.pieces()
+
+	switch fs.Arg(0) {
+	case "ls":
+		fmt.Printf("%d solutions in %d blocks\n", a.Count, len(a.blocks))
+		for i, sym := range a.Symbols {
+			fmt.Printf("  %-4s %d masks\n", sym, a.MaskCounts[i])
+		}
+	case "get":
+		w := solutionWriter(os.Stdout, *output)
+		defer flushSolutions(w)
+		for _, arg := range fs.Args()[2:] {
+			n, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "bad solution number %q\n", arg)
+				os.Exit(exitUsage)
+			}
+			chain, err := a.Chain(n, pieces)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(exitError)
+			}
+			if err := writeSolution(w, *output, &n, nil, chain); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(exitError)
+			}
+		}
+	case "export":
+		bw := bufio.NewWriter(os.Stdout)
+		defer bw.Flush()
+		w := solutionWriter(bw, *output)
+		defer flushSolutions(w)
+		for n := uint64(0); n < a.Count; n++ {
+			chain, err := a.Chain(n, pieces)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(exitError)
+			}
+			if *output != "text" {
+				n := n
+				err = writeSolution(w, *output, &n, nil, chain)
+			} else {
+				_, err = io.WriteString(w, logRecord(chain))
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(exitError)
+			}
+		}
+	default:
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+}
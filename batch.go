@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// OutcomeTimedOut means a puzzle of a batch ran out of time before it
+// was solved or proved unsolvable.
+const OutcomeTimedOut = "timed out"
+
+// BatchConfig is how the puzzles of a batch are solved.
+type BatchConfig struct {
+	// Order is the name of the piece order, one of pieceOrders.
+	Order string
+	// Format is how solutions are written, as by -output.
+	Format string
+	// Budget is the number of placements to try per puzzle before
+	// giving up, 0 for no limit.
+	Budget uint64
+	// Timeout is how long to search each puzzle, 0 for no limit.
+	Timeout time.Duration
+}
+
+// BatchResult is the outcome of solving one puzzle of a batch.
+type BatchResult struct {
+	// Puzzle is the puzzle file's name without its extension.
+	Puzzle  string        `json:"puzzle"`
+	Path    string        `json:"path"`
+	Outcome string        `json:"outcome,omitempty"`
+	Pieces  int           `json:"pieces"`
+	Nodes   uint64        `json:"nodes"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// Error is why the puzzle couldn't be solved at all, as when it
+	// doesn't load, in which case there is no Outcome.
+	Error string `json:"error,omitempty"`
+}
+
+// BatchReport sums up a batch.
+type BatchReport struct {
+	Puzzles []BatchResult `json:"puzzles"`
+	// Outcomes counts the puzzles by outcome, those with an Error as
+	// "error".
+	Outcomes map[string]int `json:"outcomes"`
+	Nodes    uint64         `json:"nodes"`
+	// Elapsed is the time the whole batch took, less than the sum of
+	// the puzzles' with more than one worker.
+	Elapsed time.Duration `json:"elapsed_ns"`
+}
+
+// NewBatchReport sums up the results of a batch that took elapsed.
+func NewBatchReport(results []BatchResult, elapsed time.Duration) *BatchReport {
+	r := &BatchReport{Puzzles: results, Outcomes: map[string]int{}, Elapsed: elapsed}
+	for _, res := range results {
+		if res.Error != "" {
+			r.Outcomes["error"]++
+		} else {
+			r.Outcomes[res.Outcome]++
+		}
+		r.Nodes += res.Nodes
+	}
+	return r
+}
+
+// Write writes the report to path, as CSV with a row per puzzle if the
+// path ends in .csv and as JSON otherwise.
+func (r *BatchReport) Write(path string) error {
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, append(data, '\n'), 0644)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"puzzle", "path", "outcome", "pieces", "nodes", "elapsed_ns", "error"})
+	for _, res := range r.Puzzles {
+		w.Write([]string{res.Puzzle, res.Path, res.Outcome, strconv.Itoa(res.Pieces),
+			strconv.FormatUint(res.Nodes, 10), strconv.FormatInt(int64(res.Elapsed), 10), res.Error})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// solveWithin searches for a solution of the puzzle made of pieces, in
+// the config's order and within its budget and timeout, returning it or
+// nil, the Solver and the outcome.
+func solveWithin(pieces []*Piece, config BatchConfig) (PieceChain, *Solver, string) {
+	pieceOrders[config.Order](pieces, rand.New(rand.NewSource(1)))
+	solver := NewSolver(NewConflictGraph(pieces), nil)
+	start := time.Now()
+	for !solver.Done() && (config.Budget == 0 || solver.Nodes < config.Budget) {
+		if config.Timeout > 0 && time.Since(start) >= config.Timeout {
+			return nil, solver, OutcomeTimedOut
+		}
+		if chain := solver.Step(benchChunk); chain != nil {
+			return chain, solver, OutcomeSolved
+		}
+	}
+	return nil, solver, RunStats{Exhausted: solver.Done()}.Outcome()
+}
+
+// solveBatchPuzzle solves the puzzle file at path with the config,
+// writing its solution or why there is none to the file result. A panic
+// while solving the puzzle is recorded as its error rather than ending
+// the batch.
+func solveBatchPuzzle(path, result string, config BatchConfig) (r BatchResult) {
+	r = BatchResult{Puzzle: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), Path: path}
+	defer func() {
+		if e := recover(); e != nil {
+			r.Outcome = ""
+			r.Error = fmt.Sprint("panic: ", e)
+		}
+	}()
+	fail := func(err error) BatchResult {
+		r.Error = err.Error()
+		return r
+	}
+	puzzle, err := LoadPuzzle(path)
+	if err != nil {
+		return fail(err)
+	}
+	pieces, err := puzzle.Build()
+	if err != nil {
+		return fail(err)
+	}
+	r.Pieces = len(pieces)
+	built := append([]*Piece(nil), pieces...)
+	start := time.Now()
+	chain, solver, outcome := solveWithin(pieces, config)
+	r.Elapsed = time.Since(start)
+	r.Nodes = solver.Nodes
+	r.Outcome = outcome
+
+	f, err := os.Create(result)
+	if err != nil {
+		return fail(err)
+	}
+	puzzleMu.Lock()
+	usePuzzle(puzzle, built)
+	switch {
+	case chain != nil:
+		err = writeSolution(f, config.Format, nil, nil, chain)
+	case outcome == OutcomeUnsolvable:
+		_, err = fmt.Fprintln(f, "no solution")
+	default:
+		_, err = fmt.Fprintf(f, "no solution found in %d placements and %v, the most pieces placed at once were %d of %d:\n%s",
+			r.Nodes, r.Elapsed.Round(time.Millisecond), solver.BestDepth(), len(pieces), solver.Best().boards(chainLabel))
+	}
+	puzzleMu.Unlock()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return r
+}
+
+// RunBatch solves the puzzle files at paths with the config, up to
+// workers at a time, writing each one's result to a file of the same
+// name in dir with the extension ext. The results are in the order of
+// paths.
+func RunBatch(paths []string, dir, ext string, workers int, config BatchConfig) []BatchResult {
+	results := make([]BatchResult, len(paths))
+	jobs := make(chan int, len(paths))
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				name := strings.TrimSuffix(filepath.Base(paths[i]), filepath.Ext(paths[i]))
+				results[i] = solveBatchPuzzle(paths[i], filepath.Join(dir, name+ext), config)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// solveCommand implements `hreen solve DIR`.
+func solveCommand(args []string) {
+	fs := flag.NewFlagSet("solve", flag.ExitOnError)
+	glob := fs.String("glob", "*.json", "pattern of the puzzle files in the directory to solve")
+	out := fs.String("out", "", "directory to write a result per puzzle to, DIR/results by default")
+	order := fs.String("order", "shadow", "order to place the pieces in: "+strings.Join(pieceOrderNames(), ", "))
+	budget := fs.Uint64("max-nodes", 100000000, "placements to try per puzzle before giving up, 0 for no limit")
+	timeout := fs.Duration("timeout", 0, "time to search each puzzle before giving up, 0 for no limit")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of puzzles to solve at a time")
+	report := fs.String("report", "", "write a report of the whole batch to this file, CSV if it ends in .csv and JSON otherwise")
+	output := outputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen solve [flags] DIR")
+		fmt.Fprintln(os.Stderr, "Solves every puzzle file in the directory, writing each one's solution, or")
+		fmt.Fprintln(os.Stderr, "why there is none, to a file of the same name in the -out directory and")
+		fmt.Fprintln(os.Stderr, "printing a summary table.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	checkOutput(*output)
+	if _, ok := pieceOrders[*order]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown order %q, try one of %s\n", *order, strings.Join(pieceOrderNames(), ", "))
+		os.Exit(exitUsage)
+	}
+	if *workers < 1 {
+		fmt.Fprintln(os.Stderr, "-workers must be at least 1")
+		os.Exit(exitUsage)
+	}
+	dir := fs.Arg(0)
+	paths, err := filepath.Glob(filepath.Join(dir, *glob))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "no files in %s match %s\n", dir, *glob)
+		os.Exit(exitError)
+	}
+	if *out == "" {
+		*out = filepath.Join(dir, "results")
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	ext := ".txt"
+	if *output == "json" {
+		ext = ".json"
+	}
+
+	start := time.Now()
+	results := RunBatch(paths, *out, ext, *workers, BatchConfig{Order: *order, Format: *output, Budget: *budget, Timeout: *timeout})
+	summary := NewBatchReport(results, time.Since(start))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "puzzle\toutcome\tpieces\tnodes\ttime\t")
+	for _, r := range results {
+		outcome := r.Outcome
+		if r.Error != "" {
+			outcome = "error: " + r.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%v\t\n", r.Puzzle, outcome, r.Pieces, r.Nodes, r.Elapsed.Round(time.Microsecond))
+	}
+	tw.Flush()
+	fmt.Printf("%d puzzles in %v: %d solved, %d unsolvable, %d gave up, %d timed out, %d errors\n", len(paths),
+		summary.Elapsed.Round(time.Millisecond), summary.Outcomes[OutcomeSolved], summary.Outcomes[OutcomeUnsolvable],
+		summary.Outcomes[OutcomeGaveUp], summary.Outcomes[OutcomeTimedOut], summary.Outcomes["error"])
+	if *report != "" {
+		if err := summary.Write(*report); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+	}
+}
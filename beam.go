@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// beamNode is a candidate extension of one of the chains kept at the
+// previous depth of a beam search.
+type beamNode struct {
+	parent    int
+	pieceMask PieceMask
+	shadow    Mask
+}
+
+// beamPlay runs a beam search that keeps only the width most compact
+// partial chains (smallest combined shadow) at each depth. Unlike play()
+// it is incomplete: it may come back empty handed even if the puzzle is
+// solvable, but it does so quickly.
+func beamPlay(pieces []*Piece, width int, sink Sink, rep Reporter) Result {
+	start := time.Now()
+	beam := []PieceChain{{}}
+	shadows := []Mask{{}}
+
+	for depth, piece := range pieces {
+		var nodes []beamNode
+		for ci, shadow := range shadows {
+			for mi, m := range piece.Masks {
+				if !shadow.AndWith(m).Zero() {
+					continue
+				}
+				nodes = append(nodes, beamNode{
+					parent:    ci,
+					pieceMask: PieceMask{piece, mi},
+					shadow:    shadow.OrWith(piece.Shadows[mi]),
+				})
+			}
+		}
+		if len(nodes) == 0 {
+			rep.Message(fmt.Sprintf(" beam ran dry at depth %d", depth))
+			return heuristicResult(nil, sink, start)
+		}
+
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].shadow.BitsSet() < nodes[j].shadow.BitsSet()
+		})
+		if len(nodes) > width {
+			nodes = nodes[:width]
+		}
+
+		nextBeam := make([]PieceChain, len(nodes))
+		nextShadows := make([]Mask, len(nodes))
+		for i, n := range nodes {
+			parent := beam[n.parent]
+			chain := make(PieceChain, len(parent)+1)
+			copy(chain, parent)
+			chain[len(parent)] = n.pieceMask
+			nextBeam[i] = chain
+			nextShadows[i] = n.shadow
+		}
+		beam, shadows = nextBeam, nextShadows
+	}
+
+	return heuristicResult(announce(rep, beam[0], nil), sink, start)
+}
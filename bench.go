@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/tabwriter"
+	"time"
+)
+
+// benchChunk is the number of placements a bench worker tries between
+// checks of the node budget.
+const benchChunk = 1 << 12
+
+// BenchConfig is a solver configuration to benchmark.
+type BenchConfig struct {
+	// Order is the name of the piece order, one of pieceOrders.
+	Order string `json:"order"`
+	// Workers is the number of concurrent searches. One runs a single
+	// Solver like the linear backend, more split the search by the
+	// placements of the first piece like the multi backend.
+	Workers int `json:"workers"`
+	// Budget is the number of placements to try before giving up.
+	Budget uint64 `json:"budget"`
+	// Seed seeds the random piece order.
+	Seed int64 `json:"seed"`
+}
+
+// BenchResult is the outcome of searching a puzzle with a BenchConfig.
+// The budget is checked every benchChunk placements, so each worker may
+// overshoot it by up to that many. With a single worker everything but
+// Elapsed is the same from run to run, with more the counts vary a
+// little.
+type BenchResult struct {
+	Puzzle    string        `json:"puzzle"`
+	Config    BenchConfig   `json:"config"`
+	Elapsed   time.Duration `json:"elapsed_ns"`
+	Nodes     uint64        `json:"nodes"`
+	Solutions int           `json:"solutions"`
+	// Complete is true if the search space was exhausted within the
+	// budget, so Solutions are all the solutions there are.
+	Complete bool `json:"complete"`
+}
+
+// RunBench searches for all the solutions of the puzzle made of pieces
+// with the configuration until the search is over or the budget is
+// spent. It doesn't change pieces.
+func RunBench(pieces []*Piece, config BenchConfig) (BenchResult, error) {
+	order, ok := pieceOrders[config.Order]
+	if !ok {
+		return BenchResult{}, fmt.Errorf("unknown order %q", config.Order)
+	}
+	pieces = append([]*Piece(nil), pieces...)
+	order(pieces, rand.New(rand.NewSource(config.Seed)))
+	g := NewConflictGraph(pieces)
+	var stats *SearchStats
+	if config.Order == "adaptive" {
+		stats = NewSearchStats(g)
+	}
+
+	workers := config.Workers
+	var prefixes []PieceChain
+	if workers <= 1 {
+		prefixes = []PieceChain{nil}
+		workers = 1
+	} else {
+		for mi := range pieces[0].Masks {
+			prefixes = append(prefixes, PieceChain{{pieces[0], mi}})
+		}
+	}
+
+	var nodes uint64
+	var solutions int64
+	var unfinished int32
+	jobs := make(chan PieceChain, len(prefixes))
+	for _, p := range prefixes {
+		jobs <- p
+	}
+	close(jobs)
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for prefix := range jobs {
+				solver := NewSolver(g, prefix)
+				solver.Stats = stats
+				for !solver.Done() {
+					if atomic.LoadUint64(&nodes) >= config.Budget {
+						atomic.StoreInt32(&unfinished, 1)
+						return
+					}
+					before := solver.Nodes
+					if solver.Step(benchChunk) != nil {
+						atomic.AddInt64(&solutions, 1)
+					}
+					atomic.AddUint64(&nodes, solver.Nodes-before)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return BenchResult{
+		Config:    config,
+		Elapsed:   time.Since(start),
+		Nodes:     nodes,
+		Solutions: int(solutions),
+		Complete:  unfinished == 0,
+	}, nil
+}
+
+// benchCommand implements `hreen bench [PUZZLE...]`.
+// MaskBench is the time a BigMask operation takes on masks of Cells
+// cells.
+type MaskBench struct {
+	Op      string  `json:"op"`
+	Cells   int     `json:"cells"`
+	NsPerOp float64 `json:"ns_per_op"`
+}
+
+// BenchMasks times the BigMask operations the search would do at every
+// node on masks of cells cells, half of them occupied at random.
+func BenchMasks(cells int) []MaskBench {
+	rng := rand.New(rand.NewSource(1))
+	random := func() BigMask {
+		m := NewBigMask(cells)
+		for i := 0; i < cells; i++ {
+			if rng.Intn(2) == 0 {
+				m.Set(i)
+			}
+		}
+		return m
+	}
+	a, b := random(), random()
+	var sink uint
+	ops := []struct {
+		name string
+		f    func()
+	}{
+		{"or", func() { a.Or(b) }},
+		{"and", func() { a.And(b) }},
+		{"andnot", func() { a.AndNot(b) }},
+		{"intersects", func() {
+			if a.Intersects(b) {
+				sink++
+			}
+		}},
+		{"popcount", func() { sink += a.BitsSet() }},
+		{"clone+or", func() {
+			c := a.Clone()
+			c.Or(b)
+		}},
+	}
+	var results []MaskBench
+	for _, op := range ops {
+		r := testing.Benchmark(func(tb *testing.B) {
+			for i := 0; i < tb.N; i++ {
+				op.f()
+			}
+		})
+		results = append(results, MaskBench{op.name, cells, float64(r.T.Nanoseconds()) / float64(r.N)})
+	}
+	_ = sink
+	return results
+}
+
+// benchMasks implements `hreen bench -masks CELLS,...`.
+func benchMasks(sizes string, jsonOut bool) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	if !jsonOut {
+		fmt.Fprintln(tw, "cells\top\tns/op\t")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, s := range strings.Split(sizes, ",") {
+		cells, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || cells < 1 {
+			fmt.Fprintf(os.Stderr, "bad number of cells %q\n", s)
+			os.Exit(exitUsage)
+		}
+		for _, r := range BenchMasks(cells) {
+			if jsonOut {
+				enc.Encode(r)
+				continue
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%.1f\t\n", r.Cells, r.Op, r.NsPerOp)
+		}
+	}
+	tw.Flush()
+}
+
+func benchCommand(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	orders := fs.String("orders", strings.Join(pieceOrderNames(), ","), "comma separated piece orders to compare")
+	workerList := fs.String("workers", "1,4", "comma separated worker counts to compare, 1 is the linear backend, more the multi backend")
+	budget := fs.Uint64("max-nodes", 1000000, "placements to try per configuration")
+	seed := fs.Int64("seed", 1, "random seed for the random order")
+	jsonOut := fs.Bool("json", false, "print a line of JSON per configuration instead of a table, for scripts tracking performance")
+	masks := fs.String("masks", "", "time the operations on masks of these comma separated numbers of cells, as for big boards, instead of searching")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen bench [flags] [PUZZLE...]")
+		fmt.Fprintln(os.Stderr, "Compares searching for all solutions of the puzzles, or the original")
+		fmt.Fprintln(os.Stderr, "puzzle, with each piece order and number of workers.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *masks != "" {
+		benchMasks(*masks, *jsonOut)
+		return
+	}
+
+	var workers []int
+	for _, s := range strings.Split(*workerList, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "bad worker count %q\n", s)
+			os.Exit(exitUsage)
+		}
+		workers = append(workers, n)
+	}
+	var orderNames []string
+	for _, o := range strings.Split(*orders, ",") {
+		o = strings.TrimSpace(o)
+		if _, ok := pieceOrders[o]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown order %q, try one of %s\n", o, strings.Join(pieceOrderNames(), ", "))
+			os.Exit(exitUsage)
+		}
+		orderNames = append(orderNames, o)
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	if !*jsonOut {
+		fmt.Fprintln(tw, "puzzle\torder\tworkers\ttime\tnodes\tnodes/s\tsolutions\t")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, path := range paths {
+		pieces := loadPieces(path)
+		name := "hreen"
+		if path != "" {
+			name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		for _, o := range orderNames {
+			for _, w := range workers {
+				r, err := RunBench(pieces, BenchConfig{Order: o, Workers: w, Budget: *budget, Seed: *seed})
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(exitError)
+				}
+				r.Puzzle = name
+				if *jsonOut {
+					if err := enc.Encode(r); err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(exitError)
+					}
+					continue
+				}
+				solutions := strconv.Itoa(r.Solutions)
+				if !r.Complete {
+					solutions = ">=" + solutions
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%v\t%d\t%.0f\t%s\t\n", name, o, w, r.Elapsed.Round(time.Microsecond), r.Nodes,
+					float64(r.Nodes)/r.Elapsed.Seconds(), solutions)
+			}
+		}
+	}
+	tw.Flush()
+}
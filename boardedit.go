@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// boardCells are the cells of a board being edited: true for the cells
+// pieces go on.
+type boardCells [BoardDim][BoardDim]bool
+
+// cellsOf returns the cells of the board, or all of them if there is no
+// board.
+func cellsOf(b *Board) boardCells {
+	var cells boardCells
+	width, height := uint(BoardDim), uint(BoardDim)
+	if b != nil {
+		width, height = b.Width, b.Height
+	}
+	for y := uint(0); y < height && y < BoardDim; y++ {
+		for x := uint(0); x < width && x < BoardDim; x++ {
+			cells[y][x] = true
+		}
+	}
+	if b != nil {
+		for _, c := range b.Blocked {
+			if c[0] < BoardDim && c[1] < BoardDim {
+				cells[c[1]][c[0]] = false
+			}
+		}
+	}
+	return cells
+}
+
+// board returns the board made of the cells: as wide and high as needed
+// to hold them, with the other cells within blocked.
+func (cells *boardCells) board() (*Board, error) {
+	b := &Board{}
+	for y := uint(0); y < BoardDim; y++ {
+		for x := uint(0); x < BoardDim; x++ {
+			if cells[y][x] {
+				if x+1 > b.Width {
+					b.Width = x + 1
+				}
+				if y+1 > b.Height {
+					b.Height = y + 1
+				}
+			}
+		}
+	}
+	if b.Width == 0 {
+		return nil, fmt.Errorf("the board has no cells")
+	}
+	for y := uint(0); y < b.Height; y++ {
+		for x := uint(0); x < b.Width; x++ {
+			if !cells[y][x] {
+				b.Blocked = append(b.Blocked, [2]uint{x, y})
+			}
+		}
+	}
+	return b, nil
+}
+
+const boardEditHelp = `arrows or hjkl move, space toggles a cell, f fills the board, c clears it,
+s saves, q quits`
+
+// editBoard lets the user draw the board of the puzzle, saving it to path.
+func editBoard(t *terminal, puzzle *Puzzle, path string) error {
+	cells := cellsOf(puzzle.Board)
+	x, y := 0, 0
+	status := ""
+	saved := true
+	for {
+		screen := fmt.Sprintf("board of %s\n\n", path) +
+			drawCells(BoardDim, BoardDim, x, y, func(x, y int) string {
+				if cells[y][x] {
+					return "#"
+				}
+				return "."
+			}) +
+			"\n" + boardEditHelp + "\n" + status
+		t.draw(screen)
+		status = ""
+		k, err := t.readKey()
+		if err != nil {
+			return err
+		}
+		if moveCursor(k, &x, &y, BoardDim, BoardDim) {
+			continue
+		}
+		switch k {
+		case ' ':
+			cells[y][x] = !cells[y][x]
+			saved = false
+		case 'f', 'c':
+			for cy := range cells {
+				for cx := range cells[cy] {
+					cells[cy][cx] = k == 'f'
+				}
+			}
+			saved = false
+		case 's':
+			b, err := cells.board()
+			if err != nil {
+				status = err.Error()
+				break
+			}
+			puzzle.Board = b
+			if err := puzzle.Save(path); err != nil {
+				status = err.Error()
+				break
+			}
+			saved = true
+			status = fmt.Sprintf("saved a %dx%d board", b.Width, b.Height)
+			if _, err := puzzle.Build(); err != nil {
+				status += fmt.Sprintf(", but the puzzle won't work: %v", err)
+			}
+		case 'q', 3:
+			if !saved && k == 'q' {
+				saved = true
+				status = "the board isn't saved, q again to quit anyway"
+				continue
+			}
+			return nil
+		}
+	}
+}
+
+// editBoardCommand implements `hreen edit-board FILE`.
+func editBoardCommand(args []string) {
+	fs := flag.NewFlagSet("edit-board", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen edit-board FILE")
+		fmt.Fprintln(os.Stderr, "Draws the board of a puzzle file in the terminal, creating the file if")
+		fmt.Fprintln(os.Stderr, "needed.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	path := fs.Arg(0)
+	puzzle, err := LoadPuzzle(path)
+	if os.IsNotExist(err) {
+		puzzle, err = &Puzzle{Pieces: []PieceDef{}}, nil
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	if len(puzzle.Boards) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: edit-board only edits puzzles with a single board\n", path)
+		os.Exit(exitError)
+	}
+
+	t, err := openTerminal()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	err = editBoard(t, puzzle, path)
+	t.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+}
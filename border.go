@@ -0,0 +1,47 @@
+package main
+
+import "sort"
+
+// borderFirst is set by -border-first for the Solvers of the linear and
+// multi backends to try the placements that expose the least of the
+// board first, see Solver.BorderFirst.
+var borderFirst bool
+
+// halo returns the cells placement mi of the i'th piece covers or
+// touches along an edge, whatever the rules, computing those of the
+// piece's placements the first time it is asked for one.
+func (s *Solver) halo(i, mi int) Mask {
+	if s.halos == nil {
+		s.halos = make([][]Mask, len(s.g.Pieces))
+	}
+	if s.halos[i] == nil {
+		piece := s.g.Pieces[i]
+		s.halos[i] = make([]Mask, len(piece.Masks))
+		for j, m := range piece.Masks {
+			s.halos[i][j] = m.Shadow()
+		}
+	}
+	return s.halos[i][mi]
+}
+
+// sortByExposure orders the placements of the i'th piece by how many
+// cells of the board they newly expose: cells next to the piece that are
+// neither off the board nor next to a piece already placed. Placements
+// hugging the edges of the board and the pieces placed come first, which
+// leaves the rest of the board in one piece, keeping the placements of
+// the pieces to come open.
+func (s *Solver) sortByExposure(i int, maskIndices []int) {
+	var shaded Mask
+	for k, pm := range s.chain {
+		shaded = shaded.OrWith(s.halo(s.order[k], pm.MaskIndex))
+	}
+	board := s.boardMask()
+	open := Mask{board[0] &^ shaded[0], board[1] &^ shaded[1]}
+	exposed := make(map[int]uint, len(maskIndices))
+	for _, mi := range maskIndices {
+		exposed[mi] = s.halo(i, mi).AndWith(open).BitsSet()
+	}
+	sort.SliceStable(maskIndices, func(i, j int) bool {
+		return exposed[maskIndices[i]] < exposed[maskIndices[j]]
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// brailleDots are the bits of the dots of a Unicode Braille pattern by
+// row and column of its 2 by 4 cell.
+var brailleDots = [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+
+// Braille draws the chain in Unicode Braille patterns, a dot per cell of
+// the board, so that the board takes 5 characters by 3 lines. A dot is
+// raised for each cell on the top or left edge of its piece, which
+// outlines the pieces; empty cells are never raised.
+func (c PieceChain) Braille() []string {
+	var owner [BoardDim][BoardDim]int
+	for i, pm := range c {
+		m := pm.Piece.Masks[pm.MaskIndex]
+		for y := uint(0); y < BoardDim; y++ {
+			for x := uint(0); x < BoardDim; x++ {
+				if m.At(x, y) == 1 {
+					owner[y][x] = i + 1
+				}
+			}
+		}
+	}
+	raised := func(x, y int) bool {
+		if x >= BoardDim || y >= BoardDim || owner[y][x] == 0 {
+			return false
+		}
+		return x == 0 || y == 0 || owner[y][x-1] != owner[y][x] || owner[y-1][x] != owner[y][x]
+	}
+	lines := make([]string, (BoardDim+3)/4)
+	for l := range lines {
+		row := make([]rune, (BoardDim+1)/2)
+		for i := range row {
+			r := rune(0x2800)
+			for dy := 0; dy < 4; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					if raised(2*i+dx, 4*l+dy) {
+						r |= brailleDots[dy][dx]
+					}
+				}
+			}
+			row[i] = r
+		}
+		lines[l] = string(row)
+	}
+	return lines
+}
+
+// BrailleSheet lays out solutions drawn by Braille side by side, as many
+// to a row as fit in a width of characters, and writes each row to w
+// once it is full. It is safe for concurrent use.
+type BrailleSheet struct {
+	w   io.Writer
+	per int
+	mu  sync.Mutex
+	row [][]string
+}
+
+// NewBrailleSheet returns a sheet writing to w rows at most width
+// characters wide.
+func NewBrailleSheet(w io.Writer, width int) *BrailleSheet {
+	per := (width + 1) / ((BoardDim+1)/2 + 1)
+	if per < 1 {
+		per = 1
+	}
+	return &BrailleSheet{w: w, per: per}
+}
+
+// Add adds the solution to the sheet.
+func (s *BrailleSheet) Add(c PieceChain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.row = append(s.row, c.Braille())
+	if len(s.row) < s.per {
+		return nil
+	}
+	return s.flush()
+}
+
+// Flush writes the last row, if it isn't full.
+func (s *BrailleSheet) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// flush writes the row. The sheet must be locked.
+func (s *BrailleSheet) flush() error {
+	if len(s.row) == 0 {
+		return nil
+	}
+	b := strings.Builder{}
+	for l := range s.row[0] {
+		for i, block := range s.row {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteString(block[l])
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	s.row = s.row[:0]
+	_, err := io.WriteString(s.w, b.String())
+	return err
+}
+
+// Write writes p to the sheet's writer, first flushing the row so that
+// text written between solutions comes after those before it.
+func (s *BrailleSheet) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+	return s.w.Write(p)
+}
+
+// terminalWidth returns the width of the terminal as given by $COLUMNS,
+// or 80.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// solutionWriter returns where to write solutions to w in the format:
+// w itself, or for braille a sheet on it that has to be flushed when
+// done.
+func solutionWriter(w io.Writer, format string) io.Writer {
+	if format == "braille" {
+		return NewBrailleSheet(w, terminalWidth())
+	}
+	return w
+}
+
+// flushSolutions flushes the writer returned by solutionWriter.
+func flushSolutions(w io.Writer) error {
+	if s, ok := w.(*BrailleSheet); ok {
+		return s.Flush()
+	}
+	return nil
+}
+
+// writeBraille writes the solution for -output braille: added to w if
+// it is a sheet, otherwise on lines of its own.
+func writeBraille(w io.Writer, c PieceChain) error {
+	if s, ok := w.(*BrailleSheet); ok {
+		return s.Add(c)
+	}
+	_, err := fmt.Fprintln(w, strings.Join(c.Braille(), "\n"))
+	return err
+}
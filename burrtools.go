@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"os"
+)
+
+// burrPuzzle is the part of a BurrTools puzzle file hreen understands.
+type burrPuzzle struct {
+	XMLName  xml.Name      `xml:"puzzle"`
+	Version  int           `xml:"version,attr"`
+	GridType burrGridType  `xml:"gridType"`
+	Colors   []burrColor   `xml:"colors>color"`
+	Shapes   []burrVoxel   `xml:"shapes>voxel"`
+	Problems []burrProblem `xml:"problems>problem"`
+}
+
+// burrColor is a colour cells of shapes can be painted in.
+type burrColor struct {
+	Red   uint8 `xml:"red,attr"`
+	Green uint8 `xml:"green,attr"`
+	Blue  uint8 `xml:"blue,attr"`
+}
+
+// burrGridType is the kind of grid a BurrTools puzzle is built on. hreen
+// only handles type 0, cubes.
+type burrGridType struct {
+	Type int `xml:"type,attr"`
+}
+
+// burrVoxel is a BurrTools shape. Content has a character per cell, x
+// varying fastest and then y and z: _ for empty, # for filled and + for
+// cells that may be left empty, each optionally followed by a colour.
+type burrVoxel struct {
+	X       int    `xml:"x,attr"`
+	Y       int    `xml:"y,attr"`
+	Z       int    `xml:"z,attr"`
+	Type    int    `xml:"type,attr"`
+	Name    string `xml:"name,attr,omitempty"`
+	Content string `xml:",chardata"`
+}
+
+// burrProblem is a BurrTools problem: which shapes, how many of each, to
+// assemble into the result shape.
+type burrProblem struct {
+	Name   string          `xml:"name,attr,omitempty"`
+	State  int             `xml:"state,attr"`
+	Shapes []burrShapeRef  `xml:"shapes>shape"`
+	Result burrShapeResult `xml:"result"`
+}
+
+// burrShapeRef is a shape used by a problem.
+type burrShapeRef struct {
+	ID    int `xml:"id,attr"`
+	Count int `xml:"count,attr,omitempty"`
+	Min   int `xml:"min,attr,omitempty"`
+}
+
+// burrShapeResult is the shape a problem is to assemble.
+type burrShapeResult struct {
+	ID int `xml:"id,attr"`
+}
+
+// readBurrTools reads a BurrTools puzzle file, which is XML and usually
+// gzipped.
+func readBurrTools(r io.Reader) (*burrPuzzle, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var bp burrPuzzle
+	if err := xml.Unmarshal(data, &bp); err != nil {
+		return nil, err
+	}
+	return &bp, nil
+}
+
+// cells returns the state character of each cell of a flat shape, rows
+// top to bottom.
+func (v burrVoxel) cells() ([][]byte, error) {
+	if v.Type != 0 {
+		return nil, fmt.Errorf("shape %q is not made of cubes", v.Name)
+	}
+	if v.Z != 1 {
+		return nil, fmt.Errorf("shape %q is not flat", v.Name)
+	}
+	if v.X < 1 || v.Y < 1 {
+		return nil, fmt.Errorf("shape %q is empty", v.Name)
+	}
+	var states []byte
+	for i := 0; i < len(v.Content); i++ {
+		switch c := v.Content[i]; {
+		case c == '_' || c == '#' || c == '+':
+			states = append(states, c)
+		case c >= '0' && c <= '9' && len(states) > 0:
+			// The colour of the previous cell.
+		case c == ' ' || c == '\n' || c == '\r' || c == '\t':
+		default:
+			return nil, fmt.Errorf("shape %q: unexpected %q", v.Name, c)
+		}
+	}
+	if len(states) != v.X*v.Y {
+		return nil, fmt.Errorf("shape %q has %d cells, not %dx%d", v.Name, len(states), v.X, v.Y)
+	}
+	rows := make([][]byte, v.Y)
+	for y := range rows {
+		rows[y] = states[y*v.X : (y+1)*v.X]
+	}
+	return rows, nil
+}
+
+// ImportBurrTools returns problem number n of a BurrTools puzzle as an
+// hreen puzzle. BurrTools puzzles fill their result shape with pieces
+// that may touch, so the result becomes the board, with cells outside it
+// blocked, and touching is allowed. Cells BurrTools may leave empty are
+// treated as part of the board. Only flat puzzles made of squares can be
+// imported and the board has to fit hreen's.
+func ImportBurrTools(r io.Reader, n int) (*Puzzle, error) {
+	bp, err := readBurrTools(r)
+	if err != nil {
+		return nil, err
+	}
+	if bp.GridType.Type != 0 {
+		return nil, fmt.Errorf("grid type %d is not supported, only cubes", bp.GridType.Type)
+	}
+	if n < 0 || n >= len(bp.Problems) {
+		return nil, fmt.Errorf("there is no problem %d, the puzzle has %d", n, len(bp.Problems))
+	}
+	problem := bp.Problems[n]
+	shape := func(id int) (burrVoxel, error) {
+		if id < 0 || id >= len(bp.Shapes) {
+			return burrVoxel{}, fmt.Errorf("problem refers to missing shape %d", id)
+		}
+		return bp.Shapes[id], nil
+	}
+
+	result, err := shape(problem.Result.ID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.cells()
+	if err != nil {
+		return nil, err
+	}
+	board := &Board{Width: uint(result.X), Height: uint(result.Y)}
+	if board.Width > BoardDim || board.Height > BoardDim {
+		return nil, fmt.Errorf("the %dx%d result doesn't fit on the %dx%d board", result.X, result.Y, BoardDim, BoardDim)
+	}
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '_' {
+				board.Blocked = append(board.Blocked, [2]uint{uint(x), uint(y)})
+			}
+		}
+	}
+
+	puzzle := &Puzzle{Board: board, Rules: &Rules{Touching: true}}
+	for _, ref := range problem.Shapes {
+		v, err := shape(ref.ID)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := v.cells()
+		if err != nil {
+			return nil, err
+		}
+		drawing := make([]string, len(rows))
+		for y, row := range rows {
+			line := make([]byte, len(row))
+			for x, c := range row {
+				line[x] = '#'
+				if c == '_' {
+					line[x] = '.'
+				}
+			}
+			drawing[y] = string(line)
+		}
+		symbol := v.Name
+		if symbol == "" {
+			symbol = pieceSymbol(ref.ID)
+		}
+		count := ref.Count
+		if count == 0 {
+			count = ref.Min
+		}
+		if count == 0 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			d := PieceDef{Symbol: symbol, Shape: drawing}
+			if count > 1 {
+				d.Symbol = fmt.Sprintf("%s%d", symbol, i+1)
+			}
+			puzzle.Pieces = append(puzzle.Pieces, d)
+		}
+	}
+	return puzzle, nil
+}
+
+// burrContent returns the cells of a shape as BurrTools voxel content,
+// state is the character of the cell at x, y.
+func burrContent(width, height uint, state func(x, y uint) string) string {
+	var b bytes.Buffer
+	for y := uint(0); y < height; y++ {
+		for x := uint(0); x < width; x++ {
+			b.WriteString(state(x, y))
+		}
+	}
+	return b.String()
+}
+
+// ExportBurrTools writes the puzzle, and solutions to it, as a gzipped
+// BurrTools puzzle file. Pieces are the built pieces of the puzzle the
+// solutions refer to. Each piece gets its own colour, from the puzzle or
+// -palette, and the board is the result shape; where pieces don't fill
+// the board its cells may be left empty. BurrTools has no way to keep
+// pieces from touching or to pre-place them, so those rules and hints
+// are left out. Solutions are added as extra shapes, in the colours of
+// the pieces, rather than as BurrTools assemblies.
+func ExportBurrTools(w io.Writer, p *Puzzle, pieces []*Piece, solutions []PieceChain) error {
+	bp := burrPuzzle{Version: 2}
+	problem := burrProblem{Name: "hreen"}
+	colors, err := p.Colors(palettes[paletteName])
+	if err != nil {
+		return err
+	}
+	color := map[*Piece]int{}
+	area := uint(0)
+	for i, d := range p.Pieces {
+		width, height, pmask, err := d.parse()
+		if err != nil {
+			return fmt.Errorf("piece %s: %v", d.Symbol, err)
+		}
+		bp.Colors = append(bp.Colors, burrColor{colors[i].R, colors[i].G, colors[i].B})
+		color[pieces[i]] = i + 1
+		bp.Shapes = append(bp.Shapes, burrVoxel{
+			X: int(width), Y: int(height), Z: 1, Name: d.Symbol,
+			Content: burrContent(width, height, func(x, y uint) string {
+				if pmask>>(y*width+x)&1 == 0 {
+					return "_"
+				}
+				return fmt.Sprintf("#%d", i+1)
+			}),
+		})
+		problem.Shapes = append(problem.Shapes, burrShapeRef{ID: i, Count: 1})
+		area += uint(bits.OnesCount64(pmask))
+	}
+
+	// The result shape is the part of the grid the boards are laid out
+	// on, the cells between boards left empty.
+	board := &Board{Width: BoardDim, Height: BoardDim}
+	free, err := board.free()
+	if p.Board != nil || len(p.Boards) > 0 {
+		spots, lerr := p.Layout()
+		if lerr != nil {
+			return lerr
+		}
+		board = &Board{}
+		for _, spot := range spots {
+			if spot.X+spot.Width > board.Width {
+				board.Width = spot.X + spot.Width
+			}
+			if spot.Y+spot.Height > board.Height {
+				board.Height = spot.Y + spot.Height
+			}
+		}
+		free, err = p.free()
+	}
+	if err != nil {
+		return err
+	}
+	filled := "#"
+	if area < free.BitsSet() {
+		filled = "+"
+	}
+	problem.Result.ID = len(bp.Shapes)
+	bp.Shapes = append(bp.Shapes, burrVoxel{
+		X: int(board.Width), Y: int(board.Height), Z: 1, Name: "board",
+		Content: burrContent(board.Width, board.Height, func(x, y uint) string {
+			if free.At(x, y) == 0 {
+				return "_"
+			}
+			return filled
+		}),
+	})
+	bp.Problems = append(bp.Problems, problem)
+
+	for n, c := range solutions {
+		bp.Shapes = append(bp.Shapes, burrVoxel{
+			X: int(board.Width), Y: int(board.Height), Z: 1, Name: fmt.Sprintf("solution %d", n+1),
+			Content: burrContent(board.Width, board.Height, func(x, y uint) string {
+				for _, pm := range c {
+					if pm.Piece.Masks[pm.MaskIndex].At(x, y) == 1 {
+						return fmt.Sprintf("#%d", color[pm.Piece])
+					}
+				}
+				return "_"
+			}),
+		})
+	}
+
+	data, err := xml.MarshalIndent(bp, "", "  ")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(append([]byte(xml.Header), data...)); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// burrToolsCommand implements `hreen burrtools import|export`.
+func burrToolsCommand(args []string) {
+	fs := flag.NewFlagSet("burrtools", flag.ExitOnError)
+	source := puzzleFlag(fs)
+	problem := fs.Int("problem", 0, "number of the problem to import, from 0")
+	logPath := fs.String("log", "", "solution log whose solutions to export along with the puzzle")
+	out := fs.String("o", "", "write the puzzle to this file instead of stdout")
+	paletteFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen burrtools [flags] import FILE.xmpuzzle")
+		fmt.Fprintln(os.Stderr, "       hreen burrtools [flags] export")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	checkPalette()
+	if fs.NArg() == 1 && fs.Arg(0) == "export" {
+		burrToolsExport(source, *logPath, *out)
+		return
+	}
+	if fs.NArg() != 2 || fs.Arg(0) != "import" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	f, err := os.Open(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	defer f.Close()
+	puzzle, err := ImportBurrTools(f, *problem)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(1), err)
+		os.Exit(exitError)
+	}
+	if _, err := puzzle.Build(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(1), err)
+		os.Exit(exitError)
+	}
+	if *out == "" {
+		err = puzzle.Write(os.Stdout)
+	} else {
+		err = puzzle.Save(*out)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+}
+
+// burrToolsExport implements `hreen burrtools export`.
+func burrToolsExport(source *puzzleFlags, logPath, out string) {
+	puzzle := source.puzzle()
+	pieces := source.pieces()
+	if puzzle.Rules == nil || !puzzle.Rules.Touching {
+		fmt.Fprintln(os.Stderr, "warning: BurrTools lets pieces touch, the puzzle doesn't")
+	}
+	if len(puzzle.Hints) > 0 {
+		fmt.Fprintln(os.Stderr, "warning: BurrTools has no hints, they are left out")
+	}
+	var solutions []PieceChain
+	if logPath != "" {
+		lines, _, err := readSolutionLog(logPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		for n, line := range lines {
+			c, err := parseLogRecord(line, pieces)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%d: %v\n", logPath, n+1, err)
+				os.Exit(exitError)
+			}
+			solutions = append(solutions, c)
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := ExportBurrTools(w, puzzle, pieces, solutions); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+}
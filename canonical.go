@@ -0,0 +1,63 @@
+package main
+
+// ordered is set by -smallest and -canonical for the Solvers of the
+// linear and multi backends to try placements in order, see
+// Solver.Ordered, with the pieces in the puzzle's order. Solutions then
+// come in canonical order, the same from run to run and machine to
+// machine.
+var ordered bool
+
+// SmallestSolution returns the lexicographically smallest solution of the
+// pieces, or nil if they have none. Chains are compared piece by piece
+// in the order given, and placements of a piece by orientation, then
+// row, then column, which is the order of its masks. Of solutions that
+// only swap pieces of the same shape, the one keeping them in mask order
+// counts, as the search lists no other. Whatever the order the search
+// would otherwise take, the answer is the same, which makes it the
+// solution to compare between versions and to show in documentation.
+func SmallestSolution(pieces []*Piece) PieceChain {
+	solver := NewSolver(NewConflictGraph(pieces), nil)
+	solver.Ordered = true
+	return solver.Next()
+}
+
+// solutionOrder holds back the solutions of numbered searches, each
+// finding its solutions in order, until the searches numbered before
+// them are done, so that they are passed on in the order of the
+// searches. The multi backend uses it to keep to canonical order.
+type solutionOrder struct {
+	// next is the first search not done yet, whose solutions are
+	// passed on at once.
+	next     int
+	held     map[int][]PieceChain
+	finished map[int]bool
+}
+
+// newSolutionOrder returns a solutionOrder starting with search 0.
+func newSolutionOrder() *solutionOrder {
+	return &solutionOrder{held: map[int][]PieceChain{}, finished: map[int]bool{}}
+}
+
+// add returns the solutions to pass on now that the search found c: c
+// if the searches before it are done, otherwise none.
+func (o *solutionOrder) add(search int, c PieceChain) []PieceChain {
+	if search == o.next {
+		return []PieceChain{c}
+	}
+	o.held[search] = append(o.held[search], c)
+	return nil
+}
+
+// finish records that the search is done and returns the solutions held
+// back that may be passed on now, in order.
+func (o *solutionOrder) finish(search int) []PieceChain {
+	o.finished[search] = true
+	var out []PieceChain
+	for o.finished[o.next] {
+		delete(o.finished, o.next)
+		o.next++
+		out = append(out, o.held[o.next]...)
+		delete(o.held, o.next)
+	}
+	return out
+}
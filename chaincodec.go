@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Chains are encoded as their placements in chain order, each the piece's
+// id, its index among the pieces of the puzzle, and the orientation and
+// offset of the piece as in the JSON of solutions. Unlike mask indices
+// these don't change with the order the pieces are searched in, so an
+// encoded chain can be decoded by anything holding the same puzzle.
+//
+// The binary encoding is a version byte, the uvarint number of
+// placements and per placement the uvarint piece id, the uvarint
+// orientation and the uvarint y*BoardDim+x, so a placement mostly takes
+// three bytes. The text encoding is a line of id:orientation:x:y fields:
+//
+//	0:3:4:2 1:0:0:0 2:5:7:1
+const chainEncodingVersion = 1
+
+// pieceIDs returns the ids of pieces by piece.
+func pieceIDs(pieces []*Piece) map[*Piece]int {
+	ids := make(map[*Piece]int, len(pieces))
+	for i, p := range pieces {
+		ids[p] = i
+	}
+	return ids
+}
+
+// chainPlacement returns the piece mask of the piece with the id placed
+// as given.
+func chainPlacement(pieces []*Piece, id int, pl Placement) (PieceMask, error) {
+	if id < 0 || id >= len(pieces) {
+		return PieceMask{}, fmt.Errorf("piece id %d out of range, the puzzle has %d pieces", id, len(pieces))
+	}
+	p := pieces[id]
+	for mi, known := range p.Placements {
+		if known == pl {
+			return PieceMask{p, mi}, nil
+		}
+	}
+	return PieceMask{}, fmt.Errorf("piece %s can't be placed in orientation %d at %d,%d", p.Symbol, pl.Orientation, pl.X, pl.Y)
+}
+
+// EncodeChain returns the binary encoding of the chain, its pieces taken
+// from pieces, the puzzle's pieces in the order they were built in.
+func EncodeChain(c PieceChain, pieces []*Piece) ([]byte, error) {
+	ids := pieceIDs(pieces)
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64+3*len(c))
+	var tmp [binary.MaxVarintLen64]byte
+	put := func(v uint64) {
+		buf = append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+	}
+	buf = append(buf, chainEncodingVersion)
+	put(uint64(len(c)))
+	for _, pm := range c {
+		id, ok := ids[pm.Piece]
+		if !ok {
+			return nil, fmt.Errorf("piece %s is not in the puzzle", pm.Piece.Symbol)
+		}
+		pl := pm.Piece.Placements[pm.MaskIndex]
+		put(uint64(id))
+		put(uint64(pl.Orientation))
+		put(uint64(pl.Y*BoardDim + pl.X))
+	}
+	return buf, nil
+}
+
+// DecodeChain returns the chain encoded by EncodeChain with the same
+// pieces.
+func DecodeChain(data []byte, pieces []*Piece) (PieceChain, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty chain encoding")
+	}
+	if data[0] != chainEncodingVersion {
+		return nil, fmt.Errorf("chain encoding version %d, want %d", data[0], chainEncodingVersion)
+	}
+	data = data[1:]
+	get := func() (uint64, error) {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, errors.New("truncated chain encoding")
+		}
+		data = data[n:]
+		return v, nil
+	}
+	n, err := get()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(data)) {
+		return nil, errors.New("truncated chain encoding")
+	}
+	chain := make(PieceChain, 0, n)
+	for i := uint64(0); i < n; i++ {
+		var v [3]uint64
+		for j := range v {
+			if v[j], err = get(); err != nil {
+				return nil, err
+			}
+		}
+		if v[0] >= uint64(len(pieces)) || v[2] >= BoardDim*BoardDim {
+			return nil, fmt.Errorf("placement %d out of range", i+1)
+		}
+		pm, err := chainPlacement(pieces, int(v[0]), Placement{int(v[1]), uint(v[2] % BoardDim), uint(v[2] / BoardDim)})
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, pm)
+	}
+	if len(data) > 0 {
+		return nil, errors.New("trailing data after chain encoding")
+	}
+	return chain, nil
+}
+
+// EncodeChainText returns the text encoding of the chain, its pieces
+// taken from pieces like for EncodeChain.
+func EncodeChainText(c PieceChain, pieces []*Piece) (string, error) {
+	ids := pieceIDs(pieces)
+	fields := make([]string, len(c))
+	for i, pm := range c {
+		id, ok := ids[pm.Piece]
+		if !ok {
+			return "", fmt.Errorf("piece %s is not in the puzzle", pm.Piece.Symbol)
+		}
+		pl := pm.Piece.Placements[pm.MaskIndex]
+		fields[i] = fmt.Sprintf("%d:%d:%d:%d", id, pl.Orientation, pl.X, pl.Y)
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// DecodeChainText returns the chain encoded by EncodeChainText with the
+// same pieces.
+func DecodeChainText(s string, pieces []*Piece) (PieceChain, error) {
+	var chain PieceChain
+	for _, field := range strings.Fields(s) {
+		parts := strings.Split(field, ":")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("bad placement %q", field)
+		}
+		var v [4]int
+		for i, part := range parts {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad placement %q", field)
+			}
+			v[i] = n
+		}
+		pm, err := chainPlacement(pieces, v[0], Placement{v[1], uint(v[2]), uint(v[3])})
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, pm)
+	}
+	return chain, nil
+}
+
+// showCommand implements `hreen show CODE...`.
+func showCommand(args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	pf := puzzleFlag(fs)
+	output := outputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen show [flags] CODE...")
+		fmt.Fprintln(os.Stderr, "Draws the solutions, or partial ones, given in the text encoding of")
+		fmt.Fprintln(os.Stderr, "chains printed by -output code, one quoted argument each.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	checkOutput(*output)
+	pieces := pf.pieces()
+	w := solutionWriter(os.Stdout, *output)
+	for _, code := range fs.Args() {
+		chain, err := DecodeChainText(code, pieces)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%q: %v\n", code, err)
+			os.Exit(exitError)
+		}
+		if err := writeSolution(w, *output, nil, nil, chain); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+	}
+	if err := flushSolutions(w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// occupancy records which piece, by symbol, covers each cell of a
+// solution, or "" for empty cells.
+type occupancy [BoardDim * BoardDim]string
+
+// occupancyOf returns the occupancy of a chain.
+func occupancyOf(c PieceChain) occupancy {
+	var o occupancy
+	for _, p := range c {
+		m := p.Piece.Masks[p.MaskIndex]
+		for y := uint(0); y < BoardDim; y++ {
+			for x := uint(0); x < BoardDim; x++ {
+				if m.At(x, y) == 1 {
+					o[y*BoardDim+x] = p.Piece.Symbol
+				}
+			}
+		}
+	}
+	return o
+}
+
+// distance returns the number of cells covered differently by the two
+// solutions.
+func (o *occupancy) distance(p *occupancy) int {
+	d := 0
+	for i := range o {
+		if o[i] != p[i] {
+			d++
+		}
+	}
+	return d
+}
+
+// Cluster is a group of similar solutions.
+type Cluster struct {
+	// Representative is the solution that started the cluster. All the
+	// members are within the clustering radius of it.
+	Representative PieceChain
+	Members        []PieceChain
+	occupancy      occupancy
+}
+
+// ClusterSolutions groups solutions so that every solution is within
+// radius cells of its cluster's representative. It is a single pass
+// leader clustering: a solution joins the nearest cluster within reach
+// or starts a new one. Clusters are returned largest first.
+func ClusterSolutions(solutions []PieceChain, radius int) []*Cluster {
+	var clusters []*Cluster
+	for _, s := range solutions {
+		o := occupancyOf(s)
+		var nearest *Cluster
+		best := radius + 1
+		for _, c := range clusters {
+			if d := c.occupancy.distance(&o); d < best {
+				nearest, best = c, d
+			}
+		}
+		if nearest == nil {
+			nearest = &Cluster{Representative: s, occupancy: o}
+			clusters = append(clusters, nearest)
+		}
+		nearest.Members = append(nearest.Members, s)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return len(clusters[i].Members) > len(clusters[j].Members) })
+	return clusters
+}
+
+// clusterCommand implements `hreen cluster [-radius R] [-top N] LOG`.
+func clusterCommand(args []string) {
+	fs := flag.NewFlagSet("cluster", flag.ExitOnError)
+	source := puzzleFlag(fs)
+	radius := fs.Int("radius", 20, "maximum number of differently covered cells between a solution and its cluster's representative")
+	top := fs.Int("top", 10, "number of largest clusters to show representatives of")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen cluster [flags] LOG")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	lines, _, err := readSolutionLog(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	pieces := source.pieces()
+	solutions := make([]PieceChain, 0, len(lines))
+	for i, line := range lines {
+		chain, err := parseLogRecord(line, pieces)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %v\n", fs.Arg(0), i+1, err)
+			os.Exit(exitError)
+		}
+		solutions = append(solutions, chain)
+	}
+
+	clusters := ClusterSolutions(solutions, *radius)
+	fmt.Printf("%d solutions in %d clusters of radius %d\n", len(solutions), len(clusters), *radius)
+	for i, c := range clusters {
+		if i == *top {
+			break
+		}
+		fmt.Printf("\ncluster %d: %d solutions, representative:\n%s", i+1, len(c.Members), c.Representative)
+	}
+}
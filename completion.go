@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// listingFlags is set by hreen completion to have parseFlags list the
+// flags of a command and exit rather than parse them.
+var listingFlags bool
+
+// The completion scripts have hreen complete the words typed after it
+// with `hreen completion -complete WORD...`, falling back to file names
+// if it has nothing to offer.
+const (
+	bashCompletion = `_hreen() {
+	local IFS=$'\n'
+	COMPREPLY=($(compgen -W "$("${COMP_WORDS[0]}" completion -complete "${COMP_WORDS[@]:1:COMP_CWORD}")" -- "${COMP_WORDS[COMP_CWORD]}"))
+}
+complete -o default -F _hreen hreen
+`
+	zshCompletion = `#compdef hreen
+_hreen() {
+	local -a candidates
+	candidates=(${(f)"$(${words[1]} completion -complete "${(@)words[2,CURRENT]}")"})
+	if (( ${#candidates} )); then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+compdef _hreen hreen
+`
+	fishCompletion = `function __hreen_complete
+	set -l words (commandline -opc)
+	set -l current (commandline -ct)
+	if test (count $current) -eq 0
+		set current ""
+	end
+	$words[1] completion -complete $words[2..-1] $current
+end
+complete -c hreen -a '(__hreen_complete)'
+`
+)
+
+// complete prints the completions of the last of the words typed after
+// hreen, one to a line: the names of the commands first, the flags of the
+// command when it starts with a dash, and the presets, piece sets and
+// orders after the flags taking them.
+func complete(words []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	if len(words) > 1 {
+		switch words[len(words)-2] {
+		case "-preset", "--preset":
+			for _, p := range presets {
+				fmt.Println(p.Name)
+			}
+			return
+		case "-pieceset", "--pieceset":
+			fmt.Println(strings.Join(pieceSetNames(), "\n"))
+			return
+		case "-order", "--order":
+			fmt.Println(strings.Join(pieceOrderNames(), "\n"))
+			return
+		}
+	}
+	if len(words) == 1 && !strings.HasPrefix(current, "-") {
+		var names []string
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println(strings.Join(names, "\n"))
+		return
+	}
+	if !strings.HasPrefix(current, "-") {
+		return
+	}
+	// The commands define their flags and list them from parseFlags,
+	// which exits. They are given the words before the current one,
+	// which some need to get that far.
+	listingFlags = true
+	if cmd, ok := commands[words[0]]; ok && len(words) > 1 {
+		cmd(words[1 : len(words)-1])
+		return
+	}
+	os.Args = os.Args[:1]
+	main()
+}
+
+// listFlags prints the flags of fs, one to a line.
+func listFlags(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		fmt.Println("-" + f.Name)
+	})
+}
+
+// completionCommand implements `hreen completion`.
+func completionCommand(args []string) {
+	if len(args) > 0 && args[0] == "-complete" {
+		complete(args[1:])
+		return
+	}
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen completion bash|zsh|fish")
+		fmt.Fprintln(os.Stderr, "Prints a script completing hreen's commands, flags, presets and piece")
+		fmt.Fprintln(os.Stderr, "sets in the shell. Source it from the shell's startup file, as with")
+		fmt.Fprintln(os.Stderr, "source <(hreen completion bash) in ~/.bashrc.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	switch fs.Arg(0) {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+}
+
+func init() {
+	// Registered here, as completing the commands refers to them all.
+	commands["completion"] = completionCommand
+}
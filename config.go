@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// flagEnv returns the environment variable that sets a flag of a command:
+// HREEN_ and the flag's name for hreen itself, and HREEN_, the command's
+// name and the flag's for the subcommands, upper case with dashes as
+// underscores. -memo-mem is set by HREEN_MEMO_MEM and -addr of hreen
+// serve by HREEN_SERVE_ADDR.
+func flagEnv(command, name string) string {
+	if command != "" {
+		name = command + "_" + name
+	}
+	return "HREEN_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// configLine is a flag set by a config file, with where it was set.
+type configLine struct {
+	line        int
+	name, value string
+}
+
+// readConfig reads a config file of flag values, a flag to a line as
+// name: value in YAML or name = value in TOML, hreen's own at the top
+// and those of a command under its name, as an indented block below a
+// line "serve:" or in a table after a line "[serve]". Values may be
+// quoted, and # starts a comment outside quotes. It returns the flags
+// set by command, "" for hreen itself.
+func readConfig(path string) (map[string][]configLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config := map[string][]configLine{}
+	// section is the command of the lines below, and table whether it
+	// was started by a TOML table rather than a YAML block.
+	section, table := "", false
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		raw := scanner.Text()
+		text := strings.TrimSpace(uncomment(raw))
+		if text == "" || text == "---" {
+			continue
+		}
+		indented := raw[0] == ' ' || raw[0] == '\t'
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section, table = strings.TrimSpace(text[1:len(text)-1]), true
+			continue
+		}
+		if !indented && !table {
+			section = ""
+		}
+		sep := strings.IndexAny(text, ":=")
+		if sep < 0 {
+			return nil, fmt.Errorf("%s:%d: want name: value or name = value", path, line)
+		}
+		name, value := strings.TrimSpace(text[:sep]), strings.TrimSpace(text[sep+1:])
+		if value == "" && text[sep] == ':' && !indented && !table {
+			section = name
+			continue
+		}
+		if indented && section == "" {
+			return nil, fmt.Errorf("%s:%d: indented, but not below a command", path, line)
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			if value[0] == '"' {
+				if value, err = strconv.Unquote(value); err != nil {
+					return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+				}
+			} else {
+				value = value[1 : len(value)-1]
+			}
+		}
+		config[section] = append(config[section], configLine{line, strings.TrimLeft(name, "-"), value})
+	}
+	return config, scanner.Err()
+}
+
+// uncomment cuts a comment starting with # outside quotes off a line.
+func uncomment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// configArg returns the value of the -config flag among the arguments of
+// a command, which precede its other arguments, or "" if not given.
+func configArg(fs *flag.FlagSet, args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			return ""
+		}
+		name := strings.TrimLeft(arg, "-")
+		value, hasValue := "", false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value, hasValue = name[:eq], name[eq+1:], true
+		}
+		if name == "config" {
+			if !hasValue && i+1 < len(args) {
+				value = args[i+1]
+			}
+			return value
+		}
+		if f := fs.Lookup(name); f != nil && !hasValue {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				i++
+			}
+		}
+	}
+	return ""
+}
+
+// parseFlags parses the arguments of a command into its flags, fs being
+// flag.CommandLine for hreen itself. Flags not given on the command line
+// take their values from the environment, see flagEnv, and failing that
+// from the file of the -config flag, which parseFlags adds to every
+// command, see readConfig: a flag given overrides its variable, which
+// overrides the file. A bad value in either is a usage error.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	command := fs.Name()
+	if fs == flag.CommandLine {
+		command = ""
+	}
+	configPath := fs.String("config", "", "file of flag values to use when not given as flags or in HREEN_ environment variables, in YAML or TOML")
+	if listingFlags {
+		listFlags(fs)
+		os.Exit(exitSolved)
+	}
+	path := configArg(fs, args)
+	if path == "" {
+		path = os.Getenv(flagEnv(command, "config"))
+	}
+	if path != "" {
+		config, err := readConfig(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitUsage)
+		}
+		for _, c := range config[command] {
+			if c.name == "config" || fs.Lookup(c.name) == nil {
+				fmt.Fprintf(os.Stderr, "%s:%d: no flag -%s\n", path, c.line, c.name)
+				os.Exit(exitUsage)
+			}
+			if err := fs.Set(c.name, c.value); err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%d: -%s: %v\n", path, c.line, c.name, err)
+				os.Exit(exitUsage)
+			}
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		env := flagEnv(command, f.Name)
+		value, ok := os.LookupEnv(env)
+		if !ok || f.Name == "config" {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			fmt.Fprintf(os.Stderr, "%s=%s: %v\n", env, value, err)
+			os.Exit(exitUsage)
+		}
+	})
+	fs.Parse(args)
+	*configPath = path
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math/bits"
+)
+
+// Bitset is a set of non-negative integers below a fixed capacity.
+type Bitset []uint64
+
+// NewBitset returns an empty Bitset that can hold 0 to n-1.
+func NewBitset(n int) Bitset {
+	return make(Bitset, (n+63)/64)
+}
+
+// Set adds i to the set.
+func (b Bitset) Set(i int) {
+	b[i/64] |= 1 << (uint(i) % 64)
+}
+
+// Has returns true if i is in the set.
+func (b Bitset) Has(i int) bool {
+	return (b[i/64]>>(uint(i)%64))&1 == 1
+}
+
+// AndNot returns a new set with all the members of b that are not in o.
+func (b Bitset) AndNot(o Bitset) Bitset {
+	n := make(Bitset, len(b))
+	for i := range b {
+		n[i] = b[i] &^ o[i]
+	}
+	return n
+}
+
+// Count returns the number of members in the set.
+func (b Bitset) Count() int {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Next returns the smallest member of the set that is >= i or -1 if there
+// is none.
+func (b Bitset) Next(i int) int {
+	w := i / 64
+	if w >= len(b) {
+		return -1
+	}
+	word := b[w] >> (uint(i) % 64)
+	if word != 0 {
+		return i + bits.TrailingZeros64(word)
+	}
+	for w++; w < len(b); w++ {
+		if b[w] != 0 {
+			return w*64 + bits.TrailingZeros64(b[w])
+		}
+	}
+	return -1
+}
+
+// ConflictGraph precomputes which placements of the pieces can not
+// coexist in a chain, either because they belong to the same piece or
+// because they overlap or share an edge.
+type ConflictGraph struct {
+	Pieces []*Piece
+	// Placements lists the placements of all the pieces. Placements of
+	// Pieces[i] are at Placements[Offsets[i]:Offsets[i+1]] in MaskIndex
+	// order.
+	Placements []PieceMask
+	Offsets    []int
+	// Conflicts[p][i] is the set of mask indices of Pieces[i] conflicting
+	// with placement p.
+	Conflicts [][]Bitset
+}
+
+// Index returns the index of the piece in Pieces, or -1.
+func (g *ConflictGraph) Index(p *Piece) int {
+	for i, q := range g.Pieces {
+		if q == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// NewConflictGraph returns the conflict graph of all the placements of
+// the given pieces.
+func NewConflictGraph(pieces []*Piece) *ConflictGraph {
+	g := &ConflictGraph{
+		Pieces:  pieces,
+		Offsets: make([]int, 0, len(pieces)+1),
+	}
+	for _, p := range pieces {
+		g.Offsets = append(g.Offsets, len(g.Placements))
+		for mi := range p.Masks {
+			g.Placements = append(g.Placements, PieceMask{p, mi})
+		}
+	}
+	g.Offsets = append(g.Offsets, len(g.Placements))
+
+	g.Conflicts = make([][]Bitset, len(g.Placements))
+	for p := range g.Conflicts {
+		g.Conflicts[p] = make([]Bitset, len(pieces))
+		for i, piece := range pieces {
+			g.Conflicts[p][i] = NewBitset(len(piece.Masks))
+		}
+	}
+	for pi := range pieces {
+		for p := g.Offsets[pi]; p < g.Offsets[pi+1]; p++ {
+			pm := g.Placements[p]
+			pmask := pm.Piece.Masks[pm.MaskIndex]
+			for qi := pi; qi < len(pieces); qi++ {
+				for q := g.Offsets[qi]; q < g.Offsets[qi+1]; q++ {
+					qm := g.Placements[q]
+					if pi == qi || !pmask.AndWith(qm.Piece.Shadows[qm.MaskIndex]).Zero() {
+						g.Conflicts[p][qi].Set(qm.MaskIndex)
+						g.Conflicts[q][pi].Set(pm.MaskIndex)
+					}
+				}
+			}
+		}
+	}
+	return g
+}
+
+// Candidates tracks, for every piece that is not yet placed, the set of
+// its placements that are still legal given the pieces placed so far.
+// Placing a piece narrows the sets in place and unplacing restores them.
+type Candidates struct {
+	g      *ConflictGraph
+	Sets   []Bitset
+	placed []bool
+	// Counters counts the members of each of Sets, kept up to date as
+	// they are narrowed and restored so that Count needn't count.
+	Counters *PlacementCounters
+	// trail holds the words of the narrowed sets overwritten by each
+	// Place() in the order the pieces were placed.
+	trail []uint64
+	order []int
+}
+
+// NewCandidates returns Candidates with all placements of all pieces
+// legal.
+func NewCandidates(g *ConflictGraph) *Candidates {
+	c := &Candidates{
+		g:      g,
+		Sets:   make([]Bitset, len(g.Pieces)),
+		placed: make([]bool, len(g.Pieces)),
+	}
+	counts := make([]int, len(g.Pieces))
+	for i, p := range g.Pieces {
+		c.Sets[i] = NewBitset(len(p.Masks))
+		for mi := range p.Masks {
+			c.Sets[i].Set(mi)
+		}
+		counts[i] = len(p.Masks)
+	}
+	c.Counters = NewPlacementCounters(counts)
+	return c
+}
+
+// Place marks the i'th piece as placed at the given mask index and
+// removes the placements conflicting with it from the other pieces.
+func (c *Candidates) Place(i, maskIndex int) {
+	conflicts := c.g.Conflicts[c.g.Offsets[i]+maskIndex]
+	c.placed[i] = true
+	c.order = append(c.order, i)
+	c.Counters.Mark()
+	for j, set := range c.Sets {
+		if c.placed[j] {
+			continue
+		}
+		c.trail = append(c.trail, set...)
+		removed := 0
+		for w := range set {
+			removed += bits.OnesCount64(set[w] & conflicts[j][w])
+			set[w] &^= conflicts[j][w]
+		}
+		c.Counters.Remove(j, removed)
+	}
+}
+
+// Unplace undoes the most recent Place().
+func (c *Candidates) Unplace() {
+	i := c.order[len(c.order)-1]
+	c.order = c.order[:len(c.order)-1]
+	for j := len(c.Sets) - 1; j >= 0; j-- {
+		if c.placed[j] {
+			continue
+		}
+		set := c.Sets[j]
+		c.trail = c.trail[:len(c.trail)-len(set)]
+		copy(set, c.trail[len(c.trail):len(c.trail)+len(set)])
+	}
+	c.Counters.Undo()
+	c.placed[i] = false
+}
+
+// Count returns the number of legal placements left for the i'th piece.
+func (c *Candidates) Count(i int) int {
+	return c.Counters.Count(i)
+}
+
+// Placed returns true if the i'th piece is placed.
+func (c *Candidates) Placed(i int) bool {
+	return c.placed[i]
+}
+
+// Fewest returns the piece not placed yet, of those eligible, with the
+// fewest legal placements left, the first of those with as few, or -1 if
+// there is none.
+func (c *Candidates) Fewest(eligible func(i int) bool) int {
+	return c.Counters.Fewest(func(i int) bool { return !c.placed[i] && eligible(i) })
+}
@@ -0,0 +1,94 @@
+package main
+
+import "sort"
+
+// cornerPieces is set by -corners for the Solvers of the linear and multi
+// backends, see Solver.Corners.
+var cornerPieces int
+
+// cornerSeeded is set once -corner-seed has dropped the placements of a
+// piece not covering a corner, so that a search finding no solution no
+// longer proves there is none.
+var cornerSeeded bool
+
+// boardCorners returns the corners of the board, the cells of it that
+// have no neighbour on the board on a side and on the side at right
+// angles to it. Besides the corners of a rectangle these are the corners
+// blocked cells and the edges of a board of another shape make.
+func boardCorners(board Mask) Mask {
+	var corners Mask
+	for y := uint(0); y < BoardDim; y++ {
+		for x := uint(0); x < BoardDim; x++ {
+			if board.At(x, y) == 0 {
+				continue
+			}
+			across := board.At(x-1, y) == 0 || board.At(x+1, y) == 0
+			down := board.At(x, y-1) == 0 || board.At(x, y+1) == 0
+			if across && down {
+				corners = corners.OrBitWith(x, y, 1)
+			}
+		}
+	}
+	return corners
+}
+
+// boardMask returns the cells of the board, those the pieces can reach,
+// working them out the first time.
+func (s *Solver) boardMask() Mask {
+	if s.board.Zero() {
+		s.board = reach(s.g.Pieces)
+	}
+	return s.board
+}
+
+// cornersFirst moves the placements of the i'th piece covering a corner
+// of the board first, keeping their order otherwise.
+func (s *Solver) cornersFirst(i int, maskIndices []int) {
+	if s.corners.Zero() {
+		s.corners = boardCorners(s.boardMask())
+	}
+	piece := s.g.Pieces[i]
+	sort.SliceStable(maskIndices, func(i, j int) bool {
+		return !piece.Masks[maskIndices[i]].AndWith(s.corners).Zero() && piece.Masks[maskIndices[j]].AndWith(s.corners).Zero()
+	})
+}
+
+// SeedCorner restricts the first of the pieces to the placements covering
+// a corner of the board, and of those the symmetries take to one another
+// to the first in mask order, so that the search starts from a corner,
+// which suits tight puzzles, and only from one of the corners alike. The
+// solutions placing the piece elsewhere are lost: it is a strategy for
+// finding a solution sooner rather than for finding them all. It returns
+// the number of placements the piece had, or 0 if it covers no corner
+// and was left as it was.
+func SeedCorner(pieces []*Piece, syms []Symmetry) int {
+	piece := pieces[0]
+	corners := boardCorners(reach(pieces))
+	placements := len(piece.Masks)
+	seeds := map[int]bool{}
+	for y := uint(0); y < BoardDim; y++ {
+		for x := uint(0); x < BoardDim; x++ {
+			if corners.At(x, y) == 1 {
+				for _, mi := range piece.Covering(x, y) {
+					seeds[mi] = true
+				}
+			}
+		}
+	}
+	if len(seeds) == 0 {
+		return 0
+	}
+	piece.restrict(func(mi int) bool {
+		if !seeds[mi] {
+			return false
+		}
+		m := piece.Masks[mi]
+		for _, s := range syms[1:] {
+			if s.MapMask(m).Less(m) {
+				return false
+			}
+		}
+		return true
+	})
+	return placements
+}
@@ -0,0 +1,109 @@
+package main
+
+// PlacementCounters counts the legal placements left of each piece of a
+// search as placements are ruled out and restored, for strategies that
+// pick what to try next by them, like -fail-first. Changes are made in
+// levels, one for each piece placed: Mark starts a level and Undo takes
+// back everything since the last Mark, restoring the counts exactly. Each
+// level lists the pieces whose counts it changed and by how much, so
+// that keeping the counts costs as much as the placements ruled out and
+// not a recount of every piece, and a strategy can look at the pieces a
+// placement affected with Dirty.
+//
+// Candidates keeps PlacementCounters of its sets up to date; other
+// searches narrowing placements their own way can keep their own.
+type PlacementCounters struct {
+	counts []int
+	// changes lists the changes of all the levels in order, and levels
+	// where each level starts in it.
+	changes []counterChange
+	levels  []int
+}
+
+// counterChange is a change of the count of a piece by Remove.
+type counterChange struct {
+	piece, removed int
+}
+
+// NewPlacementCounters returns counters starting from the counts given,
+// one per piece.
+func NewPlacementCounters(counts []int) *PlacementCounters {
+	return &PlacementCounters{counts: append([]int(nil), counts...)}
+}
+
+// Count returns the number of placements left of the i'th piece.
+func (c *PlacementCounters) Count(i int) int {
+	return c.counts[i]
+}
+
+// Mark starts a level of changes.
+func (c *PlacementCounters) Mark() {
+	c.levels = append(c.levels, len(c.changes))
+}
+
+// Remove records that n more placements of the i'th piece were ruled
+// out at the current level.
+func (c *PlacementCounters) Remove(i, n int) {
+	if n == 0 {
+		return
+	}
+	c.counts[i] -= n
+	c.changes = append(c.changes, counterChange{i, n})
+}
+
+// Dirty returns the pieces whose counts changed at the current level, in
+// the order they first did.
+func (c *PlacementCounters) Dirty() []int {
+	var dirty []int
+	start := 0
+	if len(c.levels) > 0 {
+		start = c.levels[len(c.levels)-1]
+	}
+	for _, ch := range c.changes[start:] {
+		seen := false
+		for _, i := range dirty {
+			seen = seen || i == ch.piece
+		}
+		if !seen {
+			dirty = append(dirty, ch.piece)
+		}
+	}
+	return dirty
+}
+
+// Undo takes back the changes of the current level and ends it.
+func (c *PlacementCounters) Undo() {
+	start := c.levels[len(c.levels)-1]
+	c.levels = c.levels[:len(c.levels)-1]
+	for _, ch := range c.changes[start:] {
+		c.counts[ch.piece] += ch.removed
+	}
+	c.changes = c.changes[:start]
+}
+
+// Fewest returns the piece, of those eligible, with the fewest placements
+// left, the first of those with as few, or -1 if none is eligible.
+func (c *PlacementCounters) Fewest(eligible func(i int) bool) int {
+	fewest := -1
+	for i, n := range c.counts {
+		if eligible(i) && (fewest < 0 || n < c.counts[fewest]) {
+			fewest = i
+		}
+	}
+	return fewest
+}
+
+// Emptied returns a piece whose count the current level brought down to
+// 0, or -1 if there is none, looking only at the changes of the level.
+func (c *PlacementCounters) Emptied() int {
+	start := 0
+	if len(c.levels) > 0 {
+		start = c.levels[len(c.levels)-1]
+	}
+	for _, ch := range c.changes[start:] {
+		if c.counts[ch.piece] == 0 {
+			return ch.piece
+		}
+	}
+	return -1
+}
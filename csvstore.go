@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// CSVStore writes solutions as CSV, one row per piece placement, for
+// spreadsheets and data frames. Solutions are numbered from 1 in the
+// order they are appended and orientation, x and y are as in the JSON
+// structure of solutions.
+type CSVStore struct {
+	mu     sync.Mutex
+	f      *os.File
+	w      *csv.Writer
+	solved int
+}
+
+// CreateCSVStore creates a CSV file at path and writes its header.
+func CreateCSVStore(path string) (*CSVStore, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &CSVStore{f: f, w: csv.NewWriter(f)}
+	if err := s.w.Write([]string{"solution", "piece", "orientation", "x", "y"}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append writes a row per piece of the solution.
+func (s *CSVStore) Append(c PieceChain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.solved++
+	id := strconv.Itoa(s.solved)
+	for _, p := range c {
+		pl := p.Piece.Placements[p.MaskIndex]
+		row := []string{id, p.Piece.Symbol, strconv.Itoa(pl.Orientation), strconv.FormatUint(uint64(pl.X), 10), strconv.FormatUint(uint64(pl.Y), 10)}
+		if err := s.w.Write(row); err != nil {
+			return err
+		}
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+// Close flushes and closes the file.
+func (s *CSVStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
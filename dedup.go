@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// canonicalKey returns a description of the solution that is the same
+// for all chains placing the same pieces the same way, whatever their
+// order in the chain: the log record of each piece, its symbol,
+// orientation and position, sorted. Mask indices are left out as they
+// change when a run restricts the placements of a piece.
+func canonicalKey(c PieceChain) string {
+	fields := strings.Fields(logRecord(c))
+	sort.Strings(fields)
+	return strings.Join(fields, " ")
+}
+
+// canonicalHash returns a stable hash of a canonical key.
+func canonicalHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// Hash returns a 64-bit FNV-1a hash of the chain's canonical key. It is
+// the same for all chains placing the same pieces the same way, whatever
+// their order in the chain, and from run to run and machine to machine,
+// so the results of separate runs of a puzzle can be merged by it.
+// Different solutions may rarely share a hash.
+func (c PieceChain) Hash() uint64 {
+	return canonicalHash(canonicalKey(c))
+}
+
+// DedupSet remembers the solutions it has seen by their Hash, computed
+// from their canonical key. The keys are kept too so that a hash
+// collision between different solutions is told apart from a
+// duplicate, unless Shrink gave them up.
+type DedupSet struct {
+	mu   sync.Mutex
+	seen map[uint64][]string
+	// hashesOnly is set once Shrink dropped the keys.
+	hashesOnly bool
+}
+
+// NewDedupSet returns an empty DedupSet.
+func NewDedupSet() *DedupSet {
+	return &DedupSet{seen: map[uint64][]string{}}
+}
+
+// Add adds the solution with the given canonical key to the set and
+// returns false if it was already in it.
+func (d *DedupSet) Add(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h := canonicalHash(key)
+	if d.hashesOnly {
+		if _, ok := d.seen[h]; ok {
+			return false
+		}
+		d.seen[h] = nil
+		return true
+	}
+	for _, k := range d.seen[h] {
+		if k == key {
+			return false
+		}
+	}
+	d.seen[h] = append(d.seen[h], key)
+	return true
+}
+
+// Shrink drops the keys of the solutions seen, keeping their hashes, so
+// from then on a solution sharing the hash of another counts as its
+// duplicate.
+func (d *DedupSet) Shrink() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.hashesOnly {
+		return ""
+	}
+	d.hashesOnly = true
+	seen := make(map[uint64][]string, len(d.seen))
+	for h := range d.seen {
+		seen[h] = nil
+	}
+	d.seen = seen
+	return fmt.Sprintf("-dedup only keeps the hashes of the %d solutions seen, so solutions sharing a hash count as duplicates", len(seen))
+}
+
+// DedupStore passes on solutions to another store unless they have been
+// passed on before.
+type DedupStore struct {
+	set   *DedupSet
+	store SolutionStore
+}
+
+// NewDedupStore returns a DedupStore in front of store.
+func NewDedupStore(store SolutionStore) *DedupStore {
+	return &DedupStore{NewDedupSet(), store}
+}
+
+// Append passes the solution on unless it is a duplicate.
+func (d *DedupStore) Append(c PieceChain) error {
+	if !d.set.Add(canonicalKey(c)) {
+		return nil
+	}
+	return d.store.Append(c)
+}
+
+// Shrink shrinks the set of solutions seen.
+func (d *DedupStore) Shrink() string {
+	return d.set.Shrink()
+}
+
+// Close closes the store behind.
+func (d *DedupStore) Close() error {
+	return d.store.Close()
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+// duelMove is a move of a duel: placing the piece-th piece of the
+// conflict graph at a mask index.
+type duelMove struct {
+	piece, maskIndex int
+}
+
+// duelNode is a position in the DuelAI's search tree, reached by making
+// move from its parent's position.
+type duelNode struct {
+	move     duelMove
+	parent   *duelNode
+	children []*duelNode
+	// untried are the legal moves with no child yet, listed once the
+	// node is first reached.
+	untried  []duelMove
+	expanded bool
+	visits   int
+	// wins counts the random games won by the player who made move.
+	wins int
+}
+
+// DuelAI picks moves in a duel, in which two players take turns placing
+// pieces of a puzzle under its rules and the last one able to move wins.
+// It uses Monte Carlo tree search: it plays random games from the
+// position for as long as it may think and picks the move it explored
+// the most, which is the one that won most often.
+type DuelAI struct {
+	g     *ConflictGraph
+	index map[*Piece]int
+	rng   *rand.Rand
+	// Think is how long Move may search.
+	Think time.Duration
+}
+
+// NewDuelAI returns a DuelAI for duels with the pieces.
+func NewDuelAI(pieces []*Piece, think time.Duration, rng *rand.Rand) *DuelAI {
+	ai := &DuelAI{g: NewConflictGraph(pieces), index: map[*Piece]int{}, rng: rng, Think: think}
+	for i, p := range pieces {
+		ai.index[p] = i
+	}
+	return ai
+}
+
+// moves returns the legal moves left by c.
+func (ai *DuelAI) moves(c *Candidates) []duelMove {
+	var moves []duelMove
+	for i, set := range c.Sets {
+		if c.placed[i] {
+			continue
+		}
+		for mi := set.Next(0); mi != -1; mi = set.Next(mi + 1) {
+			moves = append(moves, duelMove{i, mi})
+		}
+	}
+	return moves
+}
+
+// randomMove returns a random legal move left by c, or false if there is
+// none.
+func (ai *DuelAI) randomMove(c *Candidates) (duelMove, bool) {
+	total := 0
+	for i := range c.Sets {
+		if !c.placed[i] {
+			total += c.Count(i)
+		}
+	}
+	if total == 0 {
+		return duelMove{}, false
+	}
+	k := ai.rng.Intn(total)
+	for i, set := range c.Sets {
+		if c.placed[i] {
+			continue
+		}
+		for mi := set.Next(0); mi != -1; mi = set.Next(mi + 1) {
+			if k == 0 {
+				return duelMove{i, mi}, true
+			}
+			k--
+		}
+	}
+	panic("unreachable")
+}
+
+// Move returns the move the AI makes after the placements of chain, or
+// false if it has no move left and so lost.
+func (ai *DuelAI) Move(chain PieceChain) (PieceMask, bool) {
+	c := NewCandidates(ai.g)
+	for _, pm := range chain {
+		c.Place(ai.index[pm.Piece], pm.MaskIndex)
+	}
+	root := &duelNode{}
+	deadline := time.Now().Add(ai.Think)
+	for root.visits == 0 || time.Now().Before(deadline) {
+		node, depth := root, 0
+		// Go down the explored part of the tree, then add a node.
+		for {
+			if !node.expanded {
+				node.untried = ai.moves(c)
+				node.expanded = true
+			}
+			if len(node.untried) > 0 {
+				i := ai.rng.Intn(len(node.untried))
+				m := node.untried[i]
+				node.untried[i] = node.untried[len(node.untried)-1]
+				node.untried = node.untried[:len(node.untried)-1]
+				child := &duelNode{move: m, parent: node}
+				node.children = append(node.children, child)
+				c.Place(m.piece, m.maskIndex)
+				node, depth = child, depth+1
+				break
+			}
+			if len(node.children) == 0 {
+				break
+			}
+			node = node.best()
+			c.Place(node.move.piece, node.move.maskIndex)
+			depth++
+		}
+		if node == root {
+			// No legal move at all.
+			return PieceMask{}, false
+		}
+
+		// Play the rest of the game at random. Whoever made node's
+		// move wins if an even number of moves follow it.
+		played := 0
+		for {
+			m, ok := ai.randomMove(c)
+			if !ok {
+				break
+			}
+			c.Place(m.piece, m.maskIndex)
+			played++
+		}
+		won := played%2 == 0
+		for n := node; n != nil; n = n.parent {
+			n.visits++
+			if won {
+				n.wins++
+			}
+			won = !won
+		}
+		for i := 0; i < depth+played; i++ {
+			c.Unplace()
+		}
+	}
+
+	var pick *duelNode
+	for _, child := range root.children {
+		if pick == nil || child.visits > pick.visits {
+			pick = child
+		}
+	}
+	return PieceMask{ai.g.Pieces[pick.move.piece], pick.move.maskIndex}, true
+}
+
+// best returns the child to explore next, balancing how often its move
+// won against how little it has been tried.
+func (n *duelNode) best() *duelNode {
+	var best *duelNode
+	bestScore := math.Inf(-1)
+	for _, c := range n.children {
+		score := float64(c.wins)/float64(c.visits) + math.Sqrt(2*math.Log(float64(n.visits))/float64(c.visits))
+		if score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	return best
+}
+
+// canMove returns true if any piece left has a legal placement.
+func (g *Game) canMove() bool {
+	for _, p := range g.Remaining() {
+		for mi := range p.Masks {
+			legal := true
+			for _, pm := range g.Chain {
+				if !p.Masks[mi].AndWith(pm.Piece.Shadows[pm.MaskIndex]).Zero() ||
+					!pm.Piece.Masks[pm.MaskIndex].AndWith(p.Shadows[mi]).Zero() {
+					legal = false
+					break
+				}
+			}
+			if legal {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const duelHelp = `commands:
+  place SYMBOL ORIENTATION X Y   place a piece, X and Y being the top left of its box
+  pieces                         show the pieces left and their orientations
+  board                          show the board
+  help                           show this
+  quit                           give up
+`
+
+// duel runs a duel between a player reading commands from in and writing
+// to out, and the AI. It returns once the game is over, in ends or the
+// player quits.
+func duel(g *Game, ai *DuelAI, aiFirst bool, in io.Reader, out io.Writer) {
+	fmt.Fprint(out, g.Board())
+	fmt.Fprintln(out, "take turns placing pieces, whoever places the last one wins")
+	fmt.Fprintln(out, "type help for the commands")
+	scanner := bufio.NewScanner(in)
+	aiTurn := aiFirst
+	for {
+		if !g.canMove() {
+			if aiTurn {
+				fmt.Fprintln(out, "hreen can't move, you win!")
+			} else {
+				fmt.Fprintln(out, "you can't move, hreen wins")
+			}
+			return
+		}
+		if aiTurn {
+			pm, _ := ai.Move(g.Chain)
+			pl := pm.Piece.Placements[pm.MaskIndex]
+			g.Chain = append(g.Chain, pm)
+			fmt.Fprintf(out, "hreen places %s %d %d %d\n", pm.Piece.Symbol, pl.Orientation, pl.X, pl.Y)
+			fmt.Fprint(out, g.Board())
+			aiTurn = false
+			continue
+		}
+
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			return
+		}
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		var err error
+		switch args[0] {
+		case "place", "p":
+			if err = placeCommand(g, args); err == nil {
+				fmt.Fprint(out, g.Board())
+				aiTurn = true
+			}
+		case "pieces":
+			for _, p := range g.Remaining() {
+				fmt.Fprintf(out, "%s:\n%s\n", p.Symbol, drawOrientations(p))
+			}
+		case "board":
+			fmt.Fprint(out, g.Board())
+		case "help":
+			fmt.Fprint(out, duelHelp)
+		case "quit", "q":
+			return
+		default:
+			err = fmt.Errorf("unknown command %s, type help for the commands", args[0])
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+		}
+	}
+}
+
+// duelCommand implements `hreen duel`.
+func duelCommand(args []string) {
+	fs := flag.NewFlagSet("duel", flag.ExitOnError)
+	pf := puzzleFlag(fs)
+	think := fs.Duration("think", 2*time.Second, "how long hreen thinks about each move")
+	aiFirst := fs.Bool("second", false, "let hreen make the first move")
+	seed := fs.Int64("seed", 0, "random seed, 0 picks one from the clock")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen duel [flags]")
+		fmt.Fprintln(os.Stderr, "Play against hreen: take turns placing the pieces of the puzzle,")
+		fmt.Fprintln(os.Stderr, "whoever places the last piece that fits wins.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	pieces := pf.pieces()
+	ai := NewDuelAI(pieces, *think, rand.New(rand.NewSource(*seed)))
+	duel(NewGame(pieces), ai, *aiFirst, os.Stdin, os.Stdout)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// SolverDump is the whole state of a Solver at a point of its search, as
+// Dump returns it, for working out from a file what the search did, say
+// when a new way of pruning it goes wrong.
+type SolverDump struct {
+	Time time.Time `json:"time"`
+	// Reason says what the dump was taken for.
+	Reason string `json:"reason"`
+	Nodes  uint64 `json:"nodes"`
+	Done   bool   `json:"done"`
+	// Chain holds the pieces placed, in the order they were, and Shadow
+	// the cells they rule out for the others.
+	Chain  []DumpPlacement `json:"chain"`
+	Shadow Mask            `json:"shadow"`
+	// Pieces holds every piece of the graph in its order.
+	Pieces []DumpPiece `json:"pieces"`
+	// Stack holds the frames of the search from the top level down.
+	Stack []DumpFrame `json:"stack"`
+	// Best is the longest chain the search has reached.
+	Best []DumpPlacement `json:"best"`
+}
+
+// DumpPlacement is a placement of a chain in a SolverDump.
+type DumpPlacement struct {
+	Piece     string `json:"piece"`
+	MaskIndex int    `json:"mask_index"`
+	Mask      Mask   `json:"mask"`
+}
+
+// DumpPiece is the state of a piece in a SolverDump: whether it is
+// placed and, if not, the legal placements left to it, by mask index,
+// and their count as kept by the Candidates.
+type DumpPiece struct {
+	Symbol     string `json:"symbol"`
+	Placed     bool   `json:"placed"`
+	Count      int    `json:"count"`
+	Candidates []int  `json:"candidates,omitempty"`
+}
+
+// DumpFrame is a frame of the search stack in a SolverDump: the piece
+// placed at its level, or "" if there was none to, the placements of it
+// to try in order and how many have been.
+type DumpFrame struct {
+	Piece      string `json:"piece,omitempty"`
+	Placements []int  `json:"placements"`
+	Next       int    `json:"next"`
+	Solved     bool   `json:"solved,omitempty"`
+	Dead       bool   `json:"dead,omitempty"`
+	Split      bool   `json:"split,omitempty"`
+}
+
+// Dump returns the state of the search now, between steps, saying it was
+// taken for reason.
+func (s *Solver) Dump(reason string) *SolverDump {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dump(reason)
+}
+
+// dump returns the state of the search, with s.mu locked or in the
+// middle of a step.
+func (s *Solver) dump(reason string) *SolverDump {
+	placements := func(chain PieceChain) []DumpPlacement {
+		dps := make([]DumpPlacement, len(chain))
+		for i, pm := range chain {
+			dps[i] = DumpPlacement{pm.Piece.Symbol, pm.MaskIndex, pm.Piece.Masks[pm.MaskIndex]}
+		}
+		return dps
+	}
+	d := &SolverDump{
+		Time:   time.Now(),
+		Reason: reason,
+		Nodes:  s.Nodes,
+		Done:   s.done,
+		Chain:  placements(s.chain),
+		Shadow: s.chain.Shadow(),
+		Best:   placements(s.best),
+	}
+	for i, p := range s.g.Pieces {
+		dp := DumpPiece{Symbol: p.Symbol, Placed: s.cands.Placed(i), Count: s.cands.Count(i)}
+		if !dp.Placed {
+			set := s.cands.Sets[i]
+			dp.Candidates = []int{}
+			for mi := set.Next(0); mi != -1; mi = set.Next(mi + 1) {
+				dp.Candidates = append(dp.Candidates, mi)
+			}
+		}
+		d.Pieces = append(d.Pieces, dp)
+	}
+	for _, fr := range s.stack {
+		df := DumpFrame{Placements: fr.maskIndices, Next: fr.next, Solved: fr.solved, Dead: fr.dead, Split: fr.split}
+		if df.Placements == nil {
+			df.Placements = []int{}
+		}
+		if fr.piece >= 0 {
+			df.Piece = s.g.Pieces[fr.piece].Symbol
+		}
+		d.Stack = append(d.Stack, df)
+	}
+	return d
+}
+
+// dumpOnPanic passes a dump of the state the search was in to OnPanic if
+// a step panics, before letting the panic carry on.
+func (s *Solver) dumpOnPanic() {
+	if v := recover(); v != nil {
+		s.OnPanic(s.dump(fmt.Sprintf("panic: %v", v)))
+		panic(v)
+	}
+}
+
+// stateDumps is where -dump writes the states of the Solvers of the
+// linear and multi backends, or nil without it.
+var stateDumps *dumpFile
+
+// dumpFile writes SolverDumps to a file as lines of JSON, of every Solver
+// added on a dumpSignal and of a Solver whose step panics.
+type dumpFile struct {
+	mu      sync.Mutex
+	file    *os.File
+	solvers []*Solver
+}
+
+// openDumps opens the file to write dumps to, appending to it, and
+// starts dumping the Solvers added on every dumpSignal.
+func openDumps(path string) (*dumpFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	d := &dumpFile{file: f}
+	if len(dumpSignals) > 0 {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, dumpSignals...)
+		go func() {
+			for sig := range signals {
+				d.dumpAll(fmt.Sprintf("signal %v", sig))
+			}
+		}()
+	}
+	return d, nil
+}
+
+// Add dumps the Solver from then on, forgetting those done.
+func (d *dumpFile) Add(s *Solver) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	running := d.solvers[:0]
+	for _, r := range d.solvers {
+		if !r.Done() {
+			running = append(running, r)
+		}
+	}
+	d.solvers = append(running, s)
+	s.OnPanic = d.write
+}
+
+// dumpAll dumps the Solvers added that are not done yet.
+func (d *dumpFile) dumpAll(reason string) {
+	d.mu.Lock()
+	solvers := append([]*Solver(nil), d.solvers...)
+	d.mu.Unlock()
+	for _, s := range solvers {
+		if dump := s.Dump(reason); !dump.Done {
+			d.write(dump)
+		}
+	}
+}
+
+// write writes a dump as a line of JSON, reporting a failure to stderr
+// rather than getting in the way of the search.
+func (d *dumpFile) write(dump *SolverDump) {
+	data, err := json.Marshal(dump)
+	if err == nil {
+		d.mu.Lock()
+		_, err = d.file.Write(append(data, '\n'))
+		d.mu.Unlock()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-dump: %v\n", err)
+	}
+}
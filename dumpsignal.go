@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// dumpSignals are the signals on which -dump dumps the Solvers' states.
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
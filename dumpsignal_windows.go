@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// dumpSignals are the signals on which -dump dumps the Solvers' states.
+// Windows has no spare signal, so only panics are dumped there.
+var dumpSignals []os.Signal
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// explainDepth is how deep the dead ends reported with -explain may be,
+// in pieces placed. -1, the default, reports none.
+var explainDepth = -1
+
+// explainDeadEnds makes the Solver report its dead ends no deeper than
+// explainDepth to rep.
+func explainDeadEnds(s *Solver, rep Reporter) {
+	if explainDepth < 0 {
+		return
+	}
+	s.ExplainDepth = explainDepth
+	s.Explain = func(chain PieceChain, reason string) {
+		symbols := make([]string, len(chain))
+		for i, pm := range chain {
+			symbols[i] = pm.Piece.Symbol
+		}
+		if len(chain) == 0 {
+			rep.Message(fmt.Sprintf(" dead end with nothing placed: %s", reason))
+			return
+		}
+		rep.Message(fmt.Sprintf(" dead end after %s: %s", strings.Join(symbols, " "), reason))
+	}
+}
+
+// deadEndReason says why the piece has no placements left after the
+// chain, remaining being all the pieces not in it.
+func deadEndReason(chain PieceChain, piece *Piece, legal int, remaining []*Piece) string {
+	if legal > 0 {
+		return fmt.Sprintf("the %d placements left for piece %s would only repeat those of a piece of the same shape", legal, piece.Symbol)
+	}
+	reason := fmt.Sprintf("no placements left for piece %s", piece.Symbol)
+
+	// The cells still free are those any remaining piece could have
+	// covered, less the chain's shadow. If no region of them is big
+	// enough for the piece that's why it doesn't fit.
+	var free Mask
+	for _, p := range remaining {
+		for _, m := range p.Masks {
+			free = free.OrWith(m)
+		}
+	}
+	shadow := chain.Shadow()
+	free = Mask{free[0] &^ shadow[0], free[1] &^ shadow[1]}
+	size := piece.Orientations[0].Mask.BitsSet()
+	if largest := largestRegion(free); largest < size {
+		reason += fmt.Sprintf(", the largest free region has %d cells and it needs %d", largest, size)
+	}
+	return reason
+}
+
+// largestRegion returns the number of cells in the largest region of
+// edge-connected cells of m.
+func largestRegion(m Mask) uint {
+	largest := uint(0)
+	for y := uint(0); y < BoardDim; y++ {
+		for x := uint(0); x < BoardDim; x++ {
+			if m.At(x, y) == 0 {
+				continue
+			}
+			n := uint(0)
+			stack := [][2]uint{{x, y}}
+			m = m.AndBitWith(x, y, 0)
+			for len(stack) > 0 {
+				c := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				n++
+				for _, d := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+					nx, ny := int(c[0])+d[0], int(c[1])+d[1]
+					if nx < 0 || ny < 0 || nx >= BoardDim || ny >= BoardDim || m.At(uint(nx), uint(ny)) == 0 {
+						continue
+					}
+					m = m.AndBitWith(uint(nx), uint(ny), 0)
+					stack = append(stack, [2]uint{uint(nx), uint(ny)})
+				}
+			}
+			if n > largest {
+				largest = n
+			}
+		}
+	}
+	return largest
+}
@@ -0,0 +1,26 @@
+package main
+
+// failFirst is set by -fail-first for the Solvers of the linear and multi
+// backends, see Solver.FailFirst.
+var failFirst bool
+
+// nextPiece returns the index of the piece to place next. In the graph's
+// order that is the first not placed yet. With FailFirst it is the one
+// with the fewest placements left, as counted by the Candidates as they
+// go, so that the search branches as little as it can and a piece with
+// none left ends the branch at once rather than once its turn comes. Of
+// pieces of the same shape only the first not placed yet may be, as the
+// others have to be placed after it in mask order.
+func (s *Solver) nextPiece() int {
+	if !s.FailFirst || s.Ordered {
+		for i := range s.g.Pieces {
+			if !s.cands.Placed(i) {
+				return i
+			}
+		}
+	}
+	return s.cands.Fewest(func(i int) bool {
+		t := s.twins[i]
+		return t == -1 || s.cands.Placed(t)
+	})
+}
@@ -0,0 +1,16 @@
+package main
+
+// forwardCheck is set by -forward-check for the Solvers of the linear and
+// multi backends, see Solver.ForwardCheck.
+var forwardCheck bool
+
+// wipedOut returns a piece still to place that the last placement left
+// without a placement, or -1 if there is none. Only the pieces the
+// placement ruled placements out of can have run out, and the Candidates'
+// counters list those, so the check costs no more than the placement did.
+func (s *Solver) wipedOut() int {
+	if len(s.chain) == 0 {
+		return -1
+	}
+	return s.cands.Counters.Emptied()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Frontier searches the placements of the first depth pieces only and
+// passes every chain placing them all that the search survives to sink,
+// in search order, until the sink returns false or stop is closed. The
+// chains are the roots of the subtrees a full search would explore, so
+// how many there are and what they place shows the shape of the search
+// space, and searching each on its own, say on different machines,
+// finds all the solutions between them. It returns the number of
+// chains, the placements tried and whether the search was cut short.
+func Frontier(pieces []*Piece, depth int, sink Sink, stop <-chan struct{}) (chains int, nodes uint64, cut bool) {
+	g := NewConflictGraph(pieces)
+	solver := NewSolver(g, nil)
+	solver.MaxDepth = depth
+	finished := make(chan struct{})
+	defer close(finished)
+	cancelOn(stop, finished, solver)
+	for chain := solver.Next(); chain != nil; chain = solver.Next() {
+		chains++
+		if !sink(chain) {
+			return chains, solver.Nodes, true
+		}
+	}
+	return chains, solver.Nodes, !solver.Done()
+}
+
+// frontierPlay runs Frontier for -max-depth, writing the chains as lines
+// like those of the solution log to path, or to stdout if path is "",
+// and exits with exitSolved if any survive and exitUnsolvable if none
+// do, which proves the puzzle has no solution unless -corner-seed
+// dropped placements, when it exits with exitGaveUp.
+func frontierPlay(pieces []*Piece, depth int, path string, stop <-chan struct{}) {
+	if depth < 1 || depth > len(pieces) {
+		fmt.Fprintf(os.Stderr, "-max-depth must be between 1 and the %d pieces\n", len(pieces))
+		os.Exit(exitUsage)
+	}
+	var out io.Writer = os.Stdout
+	var f *os.File
+	if path != "" {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	var werr error
+	start := time.Now()
+	chains, nodes, cut := Frontier(pieces, depth, func(c PieceChain) bool {
+		_, werr = w.WriteString(logRecord(c))
+		return werr == nil
+	}, stop)
+	if werr == nil {
+		werr = w.Flush()
+	}
+	if f != nil {
+		if err := f.Close(); werr == nil {
+			werr = err
+		}
+	}
+	if werr != nil {
+		fmt.Fprintln(os.Stderr, werr)
+		os.Exit(exitError)
+	}
+	fmt.Fprintf(messages, " %d partial chains of %d pieces survive, after %d placements in %v\n", chains, depth, nodes, time.Since(start).Round(time.Millisecond))
+	switch {
+	case cut:
+		fmt.Fprintln(messages, " stopped before reaching them all")
+		os.Exit(exitGaveUp)
+	case chains == 0 && cornerSeeded:
+		os.Exit(exitGaveUp)
+	case chains == 0:
+		os.Exit(exitUnsolvable)
+	}
+	os.Exit(exitSolved)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// randomPolyomino grows a random polyomino of the given number of cells
+// from the middle of the board, one edge-adjacent cell at a time.
+func randomPolyomino(size int, rng *rand.Rand) Mask {
+	m := Mask{}.OrBitWith(BoardDim/2, BoardDim/2, 1)
+	for m.BitsSet() < uint(size) {
+		frontier := m.Shadow()
+		var cells [][2]uint
+		for y := uint(0); y < BoardDim; y++ {
+			for x := uint(0); x < BoardDim; x++ {
+				if frontier.At(x, y) == 1 && m.At(x, y) == 0 {
+					cells = append(cells, [2]uint{x, y})
+				}
+			}
+		}
+		c := cells[rng.Intn(len(cells))]
+		m = m.OrBitWith(c[0], c[1], 1)
+	}
+	return m
+}
+
+// pieceSymbol returns a symbol for the i'th generated piece.
+func pieceSymbol(i int) string {
+	if i < 26 {
+		return string(rune('A' + i))
+	}
+	return fmt.Sprintf("P%d", i)
+}
+
+// randomPuzzle returns a puzzle of n pieces, either random polyominoes of
+// the given size or, if pool isn't empty, pieces drawn from the pool.
+func randomPuzzle(n, size int, pool []PieceDef, rng *rand.Rand) *Puzzle {
+	p := &Puzzle{}
+	if len(pool) == 0 {
+		for i := 0; i < n; i++ {
+			p.Pieces = append(p.Pieces, pieceDefOf(pieceSymbol(i), randomPolyomino(size, rng)))
+		}
+		return p
+	}
+	// Draw without replacement while the pool lasts.
+	used := map[string]int{}
+	order := rng.Perm(len(pool))
+	for i := 0; i < n; i++ {
+		var d PieceDef
+		if i < len(pool) {
+			d = pool[order[i]]
+		} else {
+			d = pool[rng.Intn(len(pool))]
+		}
+		used[d.Symbol]++
+		if used[d.Symbol] > 1 {
+			d.Symbol = fmt.Sprintf("%s%d", d.Symbol, used[d.Symbol])
+		}
+		p.Pieces = append(p.Pieces, d)
+	}
+	return p
+}
+
+// Solvable searches the puzzle for up to budget placements and returns a
+// solution if one was found. The second result is false if the budget
+// ran out before the question was settled.
+func Solvable(pieces []*Piece, budget int) (PieceChain, bool) {
+	sortPieces(pieces)
+	solver := NewSolver(NewConflictGraph(pieces), nil)
+	solution := solver.Step(budget)
+	return solution, solution != nil || solver.Done()
+}
+
+// makeUnique adds hints to a solvable puzzle until it has exactly one
+// solution, each time pinning down a piece that is placed differently in
+// two of its solutions. It gives up, returning false, if counting the
+// solutions takes more than budget placements.
+func makeUnique(puzzle *Puzzle, budget int) bool {
+	for {
+		pieces, err := puzzle.Build()
+		if err != nil {
+			return false
+		}
+		sortPieces(pieces)
+		found, settled := CountSolutions(pieces, 2, budget)
+		if !settled || len(found) == 0 {
+			return false
+		}
+		if len(found) == 1 {
+			return true
+		}
+		second := map[string]PieceMask{}
+		for _, pm := range found[1] {
+			second[pm.Piece.Symbol] = pm
+		}
+		for _, pm := range found[0] {
+			other := second[pm.Piece.Symbol]
+			if pm.Piece.Masks[pm.MaskIndex] != other.Piece.Masks[other.MaskIndex] {
+				puzzle.Hints = append(puzzle.Hints, hintOf(pm))
+				break
+			}
+		}
+	}
+}
+
+// generateCommand implements `hreen generate`.
+func generateCommand(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	n := fs.Int("pieces", 8, "number of pieces")
+	size := fs.Int("size", 5, "number of cells of each random piece")
+	poolPath := fs.String("pool", "", "puzzle file whose pieces to draw from instead of random shapes")
+	attempts := fs.Int("attempts", 100, "number of puzzles to try before giving up")
+	budget := fs.Int("max-nodes", 1000000, "search budget for checking each puzzle is solvable")
+	seed := fs.Int64("seed", 0, "random seed, 0 picks one from the clock")
+	unique := fs.Bool("unique", false, "add pre-placed hints until the puzzle has exactly one solution")
+	out := fs.String("o", "", "write the puzzle to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen generate [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+
+	var pool []PieceDef
+	if *poolPath != "" {
+		p, err := LoadPuzzle(*poolPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		pool = p.Pieces
+	}
+
+	for attempt := 1; attempt <= *attempts; attempt++ {
+		puzzle := randomPuzzle(*n, *size, pool, rng)
+		pieces, err := puzzle.Build()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		solution, settled := Solvable(pieces, *budget)
+		if solution == nil {
+			if settled {
+				fmt.Fprintf(os.Stderr, "attempt %d: unsolvable\n", attempt)
+			} else {
+				fmt.Fprintf(os.Stderr, "attempt %d: undecided within budget\n", attempt)
+			}
+			continue
+		}
+		if *unique && !makeUnique(puzzle, *budget) {
+			fmt.Fprintf(os.Stderr, "attempt %d: solvable, but uniqueness undecided within budget\n", attempt)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "attempt %d: solvable with %d hints (seed %d)\n%s", attempt, len(puzzle.Hints), *seed, solution)
+		if *out == "" {
+			if err := puzzle.Write(os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(exitError)
+			}
+		} else if err := puzzle.Save(*out); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "no solvable puzzle found in %d attempts\n", *attempts)
+	os.Exit(exitError)
+}
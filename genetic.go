@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Tuning knobs of the genetic backend.
+const (
+	geneticTournament = 3
+	geneticElites     = 2
+	geneticMutation   = 0.3
+)
+
+// individual is a member of the genetic backend's population: a full
+// chain with one placement per piece, in piece order, and its violation
+// score.
+type individual struct {
+	chain PieceChain
+	score int
+}
+
+// breed returns a child of a and b that takes the placements of a prefix
+// of the pieces from a and the rest from b, then possibly re-places one
+// of its pieces at random.
+func breed(a, b PieceChain, rng *rand.Rand) PieceChain {
+	cut := rng.Intn(len(a) + 1)
+	child := make(PieceChain, len(a))
+	copy(child, a[:cut])
+	copy(child[cut:], b[cut:])
+	if rng.Float64() < geneticMutation {
+		i := rng.Intn(len(child))
+		child[i].MaskIndex = rng.Intn(len(child[i].Piece.Masks))
+	}
+	return child
+}
+
+// geneticPlay evolves a population of full chains with touching and
+// overlapping pieces towards one without any violations. Like annealPlay
+// it is incomplete and gives up after the given number of generations.
+func geneticPlay(pieces []*Piece, population, generations int, rng *rand.Rand, sink Sink, rep Reporter) Result {
+	start := time.Now()
+	pop := make([]individual, population)
+	for i := range pop {
+		chain := make(PieceChain, len(pieces))
+		for j, p := range pieces {
+			chain[j] = PieceMask{p, rng.Intn(len(p.Masks))}
+		}
+		pop[i] = individual{chain, violations(chain)}
+	}
+
+	// tournament picks the best of a few random individuals.
+	tournament := func() PieceChain {
+		best := pop[rng.Intn(len(pop))]
+		for i := 1; i < geneticTournament; i++ {
+			if c := pop[rng.Intn(len(pop))]; c.score < best.score {
+				best = c
+			}
+		}
+		return best.chain
+	}
+
+	for gen := 0; gen < generations; gen++ {
+		sort.Slice(pop, func(i, j int) bool { return pop[i].score < pop[j].score })
+		if pop[0].score == 0 {
+			return heuristicResult(announce(rep, pop[0].chain, nil), sink, start)
+		}
+
+		next := make([]individual, 0, population)
+		next = append(next, pop[:geneticElites]...)
+		for len(next) < population {
+			child := breed(tournament(), tournament(), rng)
+			next = append(next, individual{child, violations(child)})
+		}
+		pop = next
+	}
+
+	sort.Slice(pop, func(i, j int) bool { return pop[i].score < pop[j].score })
+	rep.Message(fmt.Sprintf(" evolution stuck with %d conflicts", pop[0].chain.Conflicts()))
+	return heuristicResult(nil, sink, start)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// heatShades are the characters used to draw a cell covered by 0% to
+// 100% of the solutions.
+const heatShades = " .:-=+*#%@"
+
+// Size of the cells and margins of a heatmap PNG in pixels.
+const (
+	heatCell   = 24
+	heatMargin = 12
+)
+
+// Heatmap counts how often each cell is covered across all the solutions
+// it is given, in total and per piece. It is a SolutionStore.
+type Heatmap struct {
+	mu        sync.Mutex
+	Solutions int
+	Cells     [BoardDim * BoardDim]int
+	// Pieces holds the per cell counts of each piece by symbol.
+	Pieces map[string]*[BoardDim * BoardDim]int
+}
+
+// NewHeatmap returns an empty Heatmap.
+func NewHeatmap() *Heatmap {
+	return &Heatmap{Pieces: map[string]*[BoardDim * BoardDim]int{}}
+}
+
+// Append adds the cells covered by the solution to the counts.
+func (h *Heatmap) Append(c PieceChain) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Solutions++
+	for _, p := range c {
+		counts := h.Pieces[p.Piece.Symbol]
+		if counts == nil {
+			counts = &[BoardDim * BoardDim]int{}
+			h.Pieces[p.Piece.Symbol] = counts
+		}
+		m := p.Piece.Masks[p.MaskIndex]
+		for y := uint(0); y < BoardDim; y++ {
+			for x := uint(0); x < BoardDim; x++ {
+				if m.At(x, y) == 1 {
+					h.Cells[y*BoardDim+x]++
+					counts[y*BoardDim+x]++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Close does nothing, the counts stay available.
+func (h *Heatmap) Close() error {
+	return nil
+}
+
+// symbols returns the symbols of the pieces seen in order.
+func (h *Heatmap) symbols() []string {
+	var symbols []string
+	for s := range h.Pieces {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// fraction returns count as a fraction of all solutions.
+func (h *Heatmap) fraction(count int) float64 {
+	if h.Solutions == 0 {
+		return 0
+	}
+	return float64(count) / float64(h.Solutions)
+}
+
+// busiest returns the highest count of any cell.
+func busiest(counts *[BoardDim * BoardDim]int) int {
+	max := 0
+	for _, c := range counts {
+		if c > max {
+			max = c
+		}
+	}
+	return max
+}
+
+// scaled returns count as a fraction of max.
+func scaled(count, max int) float64 {
+	if max == 0 {
+		return 0
+	}
+	return float64(count) / float64(max)
+}
+
+// WriteText writes the heatmap as text grids: the percentage of solutions
+// covering each cell and then, per piece, where it lands drawn in shades.
+func (h *Heatmap) WriteText(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "cell coverage over %d solutions (%%):\n", h.Solutions)
+	for y := 0; y < BoardDim; y++ {
+		for x := 0; x < BoardDim; x++ {
+			fmt.Fprintf(w, "%4.0f", 100*h.fraction(h.Cells[y*BoardDim+x]))
+		}
+		fmt.Fprintln(w)
+	}
+
+	// Piece maps are drawn side by side, a few to a row. Each is scaled
+	// to its busiest cell to show where the piece tends to land.
+	const perRow = 6
+	symbols := h.symbols()
+	for start := 0; start < len(symbols); start += perRow {
+		row := symbols[start:]
+		if len(row) > perRow {
+			row = row[:perRow]
+		}
+		fmt.Fprintln(w)
+		for _, s := range row {
+			fmt.Fprintf(w, "%-*s  ", BoardDim+2, s)
+		}
+		fmt.Fprintln(w)
+		for y := 0; y < BoardDim; y++ {
+			for _, s := range row {
+				counts := h.Pieces[s]
+				max := busiest(counts)
+				line := make([]byte, BoardDim)
+				for x := 0; x < BoardDim; x++ {
+					line[x] = heatShades[int(scaled(counts[y*BoardDim+x], max)*float64(len(heatShades)-1))]
+				}
+				fmt.Fprintf(w, "|%s|  ", line)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// heatColor maps a fraction from 0 to 1 onto a black, red, yellow, white
+// colour ramp.
+func heatColor(f float64) color.RGBA {
+	v := f * 3
+	c := color.RGBA{A: 255}
+	switch {
+	case v < 1:
+		c.R = uint8(255 * v)
+	case v < 2:
+		c.R, c.G = 255, uint8(255*(v-1))
+	default:
+		c.R, c.G, c.B = 255, 255, uint8(255*(v-2))
+	}
+	return c
+}
+
+// drawHeat draws a board of counts, scaled to the busiest cell, with its
+// top left corner at x0, y0.
+func drawHeat(img *image.RGBA, x0, y0, cell int, counts *[BoardDim * BoardDim]int) {
+	max := busiest(counts)
+	for y := 0; y < BoardDim; y++ {
+		for x := 0; x < BoardDim; x++ {
+			c := heatColor(scaled(counts[y*BoardDim+x], max))
+			for py := 1; py < cell; py++ {
+				for px := 1; px < cell; px++ {
+					img.SetRGBA(x0+x*cell+px, y0+y*cell+py, c)
+				}
+			}
+		}
+	}
+}
+
+// WritePNG writes the heatmap as a PNG image to path: the overall
+// coverage at the top and a smaller map per piece below it, each scaled
+// to its busiest cell.
+func (h *Heatmap) WritePNG(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	symbols := h.symbols()
+	const perRow = 4
+	small := heatCell / 2
+	board := BoardDim * heatCell
+	smallBoard := BoardDim * small
+	rows := (len(symbols) + perRow - 1) / perRow
+	width := 2*heatMargin + perRow*(smallBoard+heatMargin)
+	if board+2*heatMargin > width {
+		width = board + 2*heatMargin
+	}
+	height := 2*heatMargin + board + rows*(smallBoard+heatMargin)
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0x40, 0x40, 0x40, 0xff}), image.ZP, draw.Src)
+	drawHeat(img, heatMargin, heatMargin, heatCell, &h.Cells)
+	for i, s := range symbols {
+		x := heatMargin + (i%perRow)*(smallBoard+heatMargin)
+		y := 2*heatMargin + board + (i/perRow)*(smallBoard+heatMargin)
+		drawHeat(img, x, y, small, h.Pieces[s])
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Phase is a part of a Solver's step that -hotspots times.
+type Phase int
+
+// The phases of a step. What isn't in any of them, like keeping the
+// chain and counters, is reported as other.
+const (
+	// PhaseCandidates is listing the legal placements of the next piece.
+	PhaseCandidates Phase = iota
+	// PhaseShadow is working out the shadow of the chain.
+	PhaseShadow
+	// PhaseSort is putting the placements in the order to try them.
+	PhaseSort
+	// PhasePlace is narrowing the candidates of the pieces left when a
+	// piece is placed, which prunes the placements it rules out.
+	PhasePlace
+	// PhaseUnplace is restoring the candidates when backtracking.
+	PhaseUnplace
+	// PhaseMemo is looking up and adding the dead states of -memo.
+	PhaseMemo
+	phaseCount
+)
+
+// phaseNames are the names of the phases in reports.
+var phaseNames = [phaseCount]string{"candidates", "shadow", "sort", "place", "unplace", "memo"}
+
+// phaseSample is how many steps a Solver takes per step it times, as
+// reading the clock at every phase would slow it down noticeably.
+const phaseSample = 64
+
+// PhaseTimes adds up the time sampled steps of Solvers spend in each
+// phase. It may be shared by Solvers running concurrently.
+type PhaseTimes struct {
+	ns      [phaseCount]int64
+	stepNs  int64
+	sampled int64
+}
+
+// add adds d to the time spent in the phase.
+func (p *PhaseTimes) add(phase Phase, d time.Duration) {
+	atomic.AddInt64(&p.ns[phase], int64(d))
+}
+
+// step adds a sampled step that took d.
+func (p *PhaseTimes) step(d time.Duration) {
+	atomic.AddInt64(&p.stepNs, int64(d))
+	atomic.AddInt64(&p.sampled, 1)
+}
+
+// WriteText writes the estimated time spent in each phase, all steps
+// taken together, and its share of the time of the steps, hottest
+// first.
+func (p *PhaseTimes) WriteText(w io.Writer) {
+	sampled, stepNs := atomic.LoadInt64(&p.sampled), atomic.LoadInt64(&p.stepNs)
+	if sampled == 0 || stepNs == 0 {
+		fmt.Fprintln(w, "hotspots: too few steps to sample")
+		return
+	}
+	fmt.Fprintf(w, "hotspots, estimated from %d steps, 1 in %d:\n", sampled, phaseSample)
+	type row struct {
+		name string
+		ns   int64
+	}
+	rows := []row{}
+	other := stepNs
+	for i, name := range phaseNames {
+		ns := atomic.LoadInt64(&p.ns[i])
+		if ns == 0 {
+			// A phase the run didn't have, like memo without -memo.
+			continue
+		}
+		rows = append(rows, row{name, ns})
+		other -= ns
+	}
+	if other < 0 {
+		other = 0
+	}
+	rows = append(rows, row{"other", other})
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].ns > rows[j].ns })
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	for _, r := range rows {
+		fmt.Fprintf(tw, "  %s\t%v\t%.1f%%\t\n", r.name, time.Duration(r.ns*phaseSample).Round(time.Microsecond), 100*float64(r.ns)/float64(stepNs))
+	}
+	tw.Flush()
+}
+
+// hotspots are the phase times of -hotspots, shared by the Solvers of
+// the linear and multi backends, or nil.
+var hotspots *PhaseTimes
+
+// phaseStart returns the time a phase starts at if the Solver is timing
+// the step, for phaseEnd.
+func (s *Solver) phaseStart() time.Time {
+	if !s.sampling {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// phaseEnd adds the time since start to the phase if the Solver is
+// timing the step.
+func (s *Solver) phaseEnd(phase Phase, start time.Time) {
+	if s.sampling {
+		s.Phases.add(phase, time.Since(start))
+	}
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"image/color"
+	"io"
+	"io/ioutil"
 	"math/bits"
+	"math/rand"
+	"os"
+	"os/signal"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 // Width and height of the board
@@ -84,6 +93,77 @@ func (m Mask) At(x, y uint) uint {
 	return uint((m[l/64] >> (l % 64)) & 1)
 }
 
+// Bounds returns the top left corner and the size of the smallest
+// rectangle containing all the occupied cells.
+func (m Mask) Bounds() (x, y, width, height uint) {
+	minX, minY, maxX, maxY := uint(BoardDim), uint(BoardDim), uint(0), uint(0)
+	for cy := uint(0); cy < BoardDim; cy++ {
+		for cx := uint(0); cx < BoardDim; cx++ {
+			if m.At(cx, cy) == 0 {
+				continue
+			}
+			if cx < minX {
+				minX = cx
+			}
+			if cy < minY {
+				minY = cy
+			}
+			if cx > maxX {
+				maxX = cx
+			}
+			if cy > maxY {
+				maxY = cy
+			}
+		}
+	}
+	if m.Zero() {
+		return 0, 0, 0, 0
+	}
+	return minX, minY, maxX - minX + 1, maxY - minY + 1
+}
+
+// Translated returns a new mask with all the occupied cells moved right
+// by dx and down by dy. Cells moved off the board are lost.
+func (m Mask) Translated(dx, dy int) Mask {
+	t := Mask{}
+	for y := uint(0); y < BoardDim; y++ {
+		for x := uint(0); x < BoardDim; x++ {
+			tx, ty := int(x)+dx, int(y)+dy
+			if m.At(x, y) == 1 && tx >= 0 && ty >= 0 && tx < BoardDim && ty < BoardDim {
+				t = t.OrBitWith(uint(tx), uint(ty), 1)
+			}
+		}
+	}
+	return t
+}
+
+// Normalized returns a new mask with the occupied cells moved as far to
+// the top left as they go.
+func (m Mask) Normalized() Mask {
+	x, y, _, _ := m.Bounds()
+	return m.Translated(-int(x), -int(y))
+}
+
+// Transformed returns a new, normalized mask that is rotated t%4 times by
+// 90 degrees clockwise, after being flipped horizontally if t >= 4.
+func (m Mask) Transformed(t int) Mask {
+	if t >= 4 {
+		m = m.Flipped()
+	}
+	for i := 0; i < t%4; i++ {
+		m = m.Rotated90()
+	}
+	return m.Normalized()
+}
+
+// Less defines a total order on masks.
+func (m Mask) Less(o Mask) bool {
+	if m[1] != o[1] {
+		return m[1] < o[1]
+	}
+	return m[0] < o[0]
+}
+
 // OrWith combines the current mask with 'o' mask to return
 // a new mask whose each cell is the logical OR of the two
 // masks.
@@ -137,33 +217,135 @@ type PieceMask struct {
 // partial or a full solution.
 type PieceChain []PieceMask
 
+// chainLabels are the labels String gives the first pieces of a chain,
+// later ones are labelled by their index in brackets.
+const chainLabels = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// chainLabel returns the label of the i'th piece of a chain.
+func chainLabel(i int) string {
+	if i < len(chainLabels) {
+		return chainLabels[i : i+1]
+	}
+	return fmt.Sprintf("[%d]", i)
+}
+
 // String returns a string representation of a partial or a full
 // solution in a two dimensional grid with each piece represented
-// as a different letter.
+// by a label of its place in the chain: a letter or digit, or its
+// index in brackets past the 62nd piece.
 func (c PieceChain) String() string {
-	var b [BoardDim][BoardDim]byte
+	return c.grid(chainLabel)
+}
+
+// SymbolString returns a representation of a partial or a full solution
+// like String, but with each piece drawn in its own symbol, followed by
+// a legend drawing the shape of each piece as placed.
+func (c PieceChain) SymbolString() string {
+	return c.grid(c.symbol) + c.legend()
+}
+
+// symbol labels the i'th piece of the chain with its symbol.
+func (c PieceChain) symbol(i int) string {
+	return c[i].Piece.Symbol
+}
+
+// legend draws the shape of each piece of the chain as placed.
+func (c PieceChain) legend() string {
+	str := strings.Builder{}
+	for _, p := range c {
+		o := p.Piece.Orientations[p.Piece.Placements[p.MaskIndex].Orientation]
+		fmt.Fprintf(&str, "\n%s:\n", p.Piece.Symbol)
+		for y := uint(0); y < o.Height; y++ {
+			row := make([]byte, o.Width)
+			for x := range row {
+				row[x] = '.'
+				if o.Mask.At(uint(x), y) == 1 {
+					row[x] = '#'
+				}
+			}
+			fmt.Fprintf(&str, "  %s\n", row)
+		}
+	}
+	return str.String()
+}
+
+// grid draws the chain on the board with each piece in its label and
+// empty cells as dots. If all labels are a single character the cells
+// are drawn next to each other, otherwise they are padded to the longest
+// label and separated by spaces so that the columns line up.
+func (c PieceChain) grid(label func(i int) string) string {
+	return c.gridPart(label, nil, 0, 0, BoardDim, BoardDim)
+}
+
+// gridPart draws the width by height part of the grid with its top left
+// corner at x0, y0. If paint isn't nil each cell, padded, is passed
+// through it with the index of its piece, or -1 if it is empty.
+func (c PieceChain) gridPart(label func(i int) string, paint func(i int, cell string) string, x0, y0, width, height uint) string {
+	var b [BoardDim][BoardDim]string
+	var owner [BoardDim][BoardDim]int
 	for y := 0; y < BoardDim; y++ {
 		for x := 0; x < BoardDim; x++ {
-			b[y][x] = '.'
+			b[y][x] = "."
+			owner[y][x] = -1
 		}
 	}
+	cell := 1
 	for i, p := range c {
+		l := label(i)
+		if n := utf8.RuneCountInString(l); n > cell {
+			cell = n
+		}
 		for y := uint(0); y < BoardDim; y++ {
 			for x := uint(0); x < BoardDim; x++ {
 				if p.Piece.Masks[p.MaskIndex].At(x, y) == 1 {
-					b[y][x] = []byte(string('A' + i))[0]
+					b[y][x] = l
+					owner[y][x] = i
 				}
 			}
 		}
 	}
 	str := strings.Builder{}
-	for y := 0; y < BoardDim; y++ {
-		str.Write(b[y][:])
-		str.Write([]byte("\n"))
+	for y := y0; y < y0+height; y++ {
+		row := b[y][x0 : x0+width]
+		switch {
+		case paint != nil:
+			for x, l := range row {
+				str.WriteString(paint(owner[y][x0+uint(x)], l+strings.Repeat(" ", cell-utf8.RuneCountInString(l))))
+			}
+		case cell == 1:
+			str.WriteString(strings.Join(row, ""))
+		default:
+			padded := strings.Builder{}
+			for _, l := range row {
+				padded.WriteString(l)
+				padded.WriteString(strings.Repeat(" ", cell+1-utf8.RuneCountInString(l)))
+			}
+			str.WriteString(strings.TrimRight(padded.String(), " "))
+		}
+		str.WriteByte('\n')
 	}
 	return str.String()
 }
 
+// boards draws the chain like grid, or board by board if the puzzle has
+// several.
+func (c PieceChain) boards(label func(i int) string) string {
+	return c.paintedBoards(label, nil)
+}
+
+// paintedBoards draws the chain like boards, passing the cells through
+// paint as gridPart does.
+func (c PieceChain) paintedBoards(label func(i int) string, paint func(i int, cell string) string) string {
+	if len(boardSpots) == 0 {
+		return c.gridPart(label, paint, 0, 0, BoardDim, BoardDim)
+	}
+	s := ""
+	for i, spot := range boardSpots {
+		s += fmt.Sprintf("board %d\n%s", i+1, c.gridPart(label, paint, spot.X, spot.Y, spot.Width, spot.Height))
+	}
+	return s
+}
+
 // Shadow returns a mask that is the bitwise OR of all the shadow
 // masks in the chain.
 func (c PieceChain) Shadow() Mask {
@@ -174,156 +356,674 @@ func (c PieceChain) Shadow() Mask {
 	return s
 }
 
+// Conflicts returns the number of piece pairs in the chain that overlap
+// or share an edge.
+func (c PieceChain) Conflicts() int {
+	n := 0
+	for i, p := range c {
+		for _, q := range c[i+1:] {
+			if !p.Piece.Masks[p.MaskIndex].AndWith(q.Piece.Shadows[q.MaskIndex]).Zero() {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Valid returns true if no two pieces in the chain overlap or share an
+// edge and no piece appears more than once.
+func (c PieceChain) Valid() bool {
+	seen := map[*Piece]bool{}
+	for _, p := range c {
+		if seen[p.Piece] {
+			return false
+		}
+		seen[p.Piece] = true
+	}
+	return c.Conflicts() == 0
+}
+
+// Orientation is one of the distinct rotations and reflections of a
+// piece, moved to the top left corner of the board.
+type Orientation struct {
+	// Transform is the number of 90 degree clockwise rotations applied
+	// to the piece as defined, plus 4 if it was mirrored horizontally
+	// before rotating.
+	Transform     int
+	Width, Height uint
+	Mask          Mask
+}
+
+// String describes the orientation's rotation and reflection.
+func (o Orientation) String() string {
+	s := fmt.Sprintf("rotated %d°", 90*(o.Transform%4))
+	if o.Transform >= 4 {
+		s = "mirrored, " + s
+	}
+	return s
+}
+
+// Placement describes a mask of a piece as one of its orientations
+// moved right by X and down by Y cells.
+type Placement struct {
+	Orientation int  `json:"orientation"`
+	X           uint `json:"x"`
+	Y           uint `json:"y"`
+}
+
 // Piece represents a puzzle piece.
 type Piece struct {
-	Symbol  string
-	Masks   []Mask
-	Shadows []Mask
+	Symbol       string
+	Orientations []Orientation
+	Masks        []Mask
+	Shadows      []Mask
+	// Placements describes each of the Masks.
+	Placements []Placement
+	// Cells lists, for each cell on the board, the indices of the
+	// masks covering it. Cell x,y is at Cells[y*BoardDim+x].
+	Cells [BoardDim * BoardDim][]int
+}
+
+// ValidatePiece checks that a piece definition, as passed to NewPiece,
+// describes a single shape of edge-connected cells that fills its width
+// by height bounding box.
+func ValidatePiece(width, height uint, pmask uint64) error {
+	if width == 0 || height == 0 || width*height > 64 {
+		return fmt.Errorf("a %dx%d piece can't be described by a 64 bit mask", width, height)
+	}
+	if pmask == 0 {
+		return fmt.Errorf("empty mask")
+	}
+	if width*height < 64 && pmask>>(width*height) != 0 {
+		return fmt.Errorf("mask has cells outside the %dx%d bounding box", width, height)
+	}
+	at := func(x, y uint) bool { return pmask>>(y*width+x)&1 == 1 }
+	for y := uint(0); y < height; y++ {
+		row := false
+		for x := uint(0); x < width; x++ {
+			row = row || at(x, y)
+		}
+		if !row {
+			return fmt.Errorf("row %d of the %dx%d bounding box is empty", y, width, height)
+		}
+	}
+	for x := uint(0); x < width; x++ {
+		column := false
+		for y := uint(0); y < height; y++ {
+			column = column || at(x, y)
+		}
+		if !column {
+			return fmt.Errorf("column %d of the %dx%d bounding box is empty", x, width, height)
+		}
+	}
+
+	// Flood fill from the lowest cell; every cell must be reached.
+	first := uint(bits.TrailingZeros64(pmask))
+	reached := uint64(1) << first
+	todo := []uint{first}
+	for len(todo) > 0 {
+		c := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		x, y := c%width, c/width
+		var next []uint
+		if x > 0 {
+			next = append(next, c-1)
+		}
+		if x+1 < width {
+			next = append(next, c+1)
+		}
+		if y > 0 {
+			next = append(next, c-width)
+		}
+		if y+1 < height {
+			next = append(next, c+width)
+		}
+		for _, n := range next {
+			if pmask>>n&1 == 1 && reached>>n&1 == 0 {
+				reached |= 1 << n
+				todo = append(todo, n)
+			}
+		}
+	}
+	if reached != pmask {
+		return fmt.Errorf("cells are not all connected")
+	}
+	return nil
 }
 
 // NewPiece returns a new Piece with all its masks and shadows populated.
-func NewPiece(symbol string, width uint, height uint, pmask uint64) *Piece {
+// The masks are ordered by orientation, then top to bottom and left to
+// right. It returns an error if the definition doesn't pass
+// ValidatePiece.
+func NewPiece(symbol string, width uint, height uint, pmask uint64) (*Piece, error) {
+	if err := ValidatePiece(width, height, pmask); err != nil {
+		return nil, err
+	}
+	if width > BoardDim || height > BoardDim {
+		// The board is square, so no rotation makes it fit.
+		return nil, fmt.Errorf("%dx%d is larger than the %dx%d board", width, height, BoardDim, BoardDim)
+	}
 
 	piece := Piece{
 		Symbol: symbol,
 	}
 
-	// mask -> shadowMask map
-	maskMap := map[Mask]Mask{}
-	var masks []Mask
+	base := Mask{}
+	for iy := uint(0); iy < height; iy++ {
+		for ix := uint(0); ix < width; ix++ {
+			v := (pmask >> (iy*width + ix)) & 1
+			base = base.OrBitWith(ix, iy, uint(v))
+		}
+	}
 
-	for y := uint(0); y < BoardDim-height+1; y++ {
-		for x := uint(0); x < BoardDim-width+1; x++ {
-			m := Mask{}
-			for iy := uint(0); iy < height; iy++ {
-				for ix := uint(0); ix < width; ix++ {
-					v := (pmask >> (iy*width + ix)) & 1
-					m = m.OrBitWith(x+ix, y+iy, uint(v))
-				}
+	seen := map[Mask]bool{}
+	for t := 0; t < 8; t++ {
+		o := base.Transformed(t)
+		if !seen[o] {
+			seen[o] = true
+			_, _, w, h := o.Bounds()
+			piece.Orientations = append(piece.Orientations, Orientation{
+				Transform: t,
+				Width:     w,
+				Height:    h,
+				Mask:      o,
+			})
+		}
+	}
+
+	for oi, o := range piece.Orientations {
+		for y := uint(0); y+o.Height <= BoardDim; y++ {
+			for x := uint(0); x+o.Width <= BoardDim; x++ {
+				m := o.Mask.Translated(int(x), int(y))
+				piece.Masks = append(piece.Masks, m)
+				piece.Shadows = append(piece.Shadows, m.Shadow())
+				piece.Placements = append(piece.Placements, Placement{oi, x, y})
 			}
-			masks = append(masks, m)
 		}
 	}
+	piece.indexCells()
 
-	for _, m := range masks {
-		maskMap[m] = m.Shadow()
-		m = m.Rotated90()
-		maskMap[m] = m.Shadow()
-		m = m.Rotated90()
-		maskMap[m] = m.Shadow()
-		m = m.Rotated90()
-		maskMap[m] = m.Shadow()
+	return &piece, nil
+}
 
-		m = m.Rotated90()
-		m = m.Flipped()
-		maskMap[m] = m.Shadow()
-		m = m.Rotated90()
-		maskMap[m] = m.Shadow()
-		m = m.Rotated90()
-		maskMap[m] = m.Shadow()
-		m = m.Rotated90()
-		maskMap[m] = m.Shadow()
+// Canonical returns a form of the piece's shape that is the same for all
+// pieces of the same shape, regardless of the orientation they were
+// defined in: the smallest of its orientations.
+func (p *Piece) Canonical() Mask {
+	c := p.Orientations[0].Mask
+	for _, o := range p.Orientations[1:] {
+		if o.Mask.Less(c) {
+			c = o.Mask
+		}
 	}
+	return c
+}
 
-	piece.Masks = make([]Mask, 0, len(maskMap))
-	piece.Shadows = make([]Mask, 0, len(maskMap))
-
-	for m, s := range maskMap {
-		piece.Masks = append(piece.Masks, m)
-		piece.Shadows = append(piece.Shadows, s)
+// Interchangeable returns true if the pieces have the same shape and the
+// same placements, so that swapping them in a solution gives the same
+// solution again. Pieces of the same shape may not be if hints, colours
+// or -break-symmetry restrict them differently.
+func (p *Piece) Interchangeable(o *Piece) bool {
+	if p.Canonical() != o.Canonical() || len(p.Masks) != len(o.Masks) {
+		return false
 	}
+	masks := make(map[Mask]bool, len(o.Masks))
+	for _, m := range o.Masks {
+		masks[m] = true
+	}
+	for _, m := range p.Masks {
+		if !masks[m] {
+			return false
+		}
+	}
+	return true
+}
 
-	return &piece
+// DuplicateShapes returns the symbols of the pieces that have the same
+// shape as another piece, grouped by shape in the order of the pieces.
+func DuplicateShapes(pieces []*Piece) [][]string {
+	groups := map[Mask][]string{}
+	var shapes []Mask
+	for _, p := range pieces {
+		c := p.Canonical()
+		if groups[c] == nil {
+			shapes = append(shapes, c)
+		}
+		groups[c] = append(groups[c], p.Symbol)
+	}
+	var dups [][]string
+	for _, c := range shapes {
+		if len(groups[c]) > 1 {
+			dups = append(dups, groups[c])
+		}
+	}
+	return dups
 }
 
-// play runs a depth first search of the search space and upon
-// a solution, prints it out.
-func play(pieces []*Piece, chain PieceChain) PieceChain {
-	if len(pieces) == 0 {
-		fmt.Println(" woohoo - we did it!!!!")
-		fmt.Println(chain)
-		return chain
+// indexCells populates Cells from Masks.
+func (p *Piece) indexCells() {
+	for c := range p.Cells {
+		p.Cells[c] = nil
 	}
-	piece := pieces[0]
-	chainShadow := chain.Shadow()
+	for mi, m := range p.Masks {
+		for y := uint(0); y < BoardDim; y++ {
+			for x := uint(0); x < BoardDim; x++ {
+				if m.At(x, y) == 1 {
+					c := y*BoardDim + x
+					p.Cells[c] = append(p.Cells[c], mi)
+				}
+			}
+		}
+	}
+}
 
-	var pieceMasks []PieceMask
-	for mi, m := range piece.Masks {
-		if !chainShadow.AndWith(m).Zero() {
-			continue
+// restrict drops the masks for whose index keep returns false.
+func (p *Piece) restrict(keep func(mi int) bool) {
+	var masks, shadows []Mask
+	var placements []Placement
+	for mi := range p.Masks {
+		if keep(mi) {
+			masks = append(masks, p.Masks[mi])
+			shadows = append(shadows, p.Shadows[mi])
+			placements = append(placements, p.Placements[mi])
 		}
-		pieceMasks = append(pieceMasks, PieceMask{piece, mi})
 	}
-	sort.Slice(pieceMasks, func(i, j int) bool {
-		imask := pieceMasks[i].Piece.Masks[pieceMasks[i].MaskIndex]
-		jmask := pieceMasks[j].Piece.Masks[pieceMasks[j].MaskIndex]
-		ibits := chainShadow.OrWith(imask).BitsSet()
-		jbits := chainShadow.OrWith(jmask).BitsSet()
-		return ibits < jbits
-	})
+	p.Masks, p.Shadows, p.Placements = masks, shadows, placements
+	p.indexCells()
+}
+
+// Covering returns the indices of the masks covering cell x, y.
+func (p *Piece) Covering(x, y uint) []int {
+	return p.Cells[y*BoardDim+x]
+}
+
+// reports is where the reports asked for with flags, like -heatmap, go.
+// With -output json it is stderr, leaving stdout to the solutions.
+var reports io.Writer = os.Stdout
 
-	for _, pieceMask := range pieceMasks {
-		nextChain := make([]PieceMask, len(chain)+1)
-		copy(nextChain, chain)
-		nextChain[len(chain)] = pieceMask
-		if ret := play(pieces[1:], nextChain); ret != nil {
-			return ret
+// messages is where the search says how it is going. It is reports,
+// unless -q silences it.
+var messages io.Writer = os.Stdout
+
+// boardSpots are the boards of the multi-board puzzle being solved, if
+// it is one. Solutions are then printed board by board.
+var boardSpots []boardSpot
+
+// builtPieces are the pieces of the puzzle being solved in the order
+// they were built, before any -order, which -output code numbers them
+// by.
+var builtPieces []*Piece
+
+// pieceColors are the colours of the pieces being solved, from the
+// puzzle or -palette.
+var pieceColors map[*Piece]color.RGBA
+
+// puzzleMu is held by goroutines that set the globals above with
+// usePuzzle and write a solution while others may be doing the same.
+var puzzleMu sync.Mutex
+
+// usePuzzle sets the globals above for the solutions of the puzzle made
+// of pieces, as built.
+func usePuzzle(p *Puzzle, pieces []*Piece) {
+	boardSpots = nil
+	if len(p.Boards) > 0 {
+		boardSpots, _ = p.Layout()
+	}
+	builtPieces = append([]*Piece(nil), pieces...)
+	pieceColors = map[*Piece]color.RGBA{}
+	if colors, err := p.Colors(palettes[paletteName]); err == nil && len(colors) == len(pieces) {
+		for i, piece := range pieces {
+			pieceColors[piece] = colors[i]
 		}
 	}
-	return nil
 }
 
-// linearPlay runs a single instances of play() at a time.
-func linearPlay(pieces []*Piece) {
-	if winningChain := play(pieces, []PieceMask{}); winningChain == nil {
-		fmt.Println(" :( - we have a bug")
+// announce verifies a solution found by any of the backends and passes
+// it on to rep. It returns nil if the solution turns out to be invalid.
+func announce(rep Reporter, chain PieceChain, found *Discovery) PieceChain {
+	if !chain.Valid() {
+		rep.Message(" uh oh - backend produced an invalid solution\n" + chain.String())
+		return nil
+	}
+	rep.Solution(chain, found)
+	return chain
+}
+
+// RunStats are the statistics of a run of a backend.
+type RunStats struct {
+	Solutions int `json:"solutions"`
+	// Nodes is the number of placements tried, by the linear and multi
+	// backends only.
+	Nodes   uint64        `json:"nodes"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	// FirstSolution is the time it took to find the first solution, if
+	// there was one.
+	FirstSolution *time.Duration `json:"first_solution_ns,omitempty"`
+	// Exhausted is true if the whole search space was searched, so
+	// there are no more solutions than were found.
+	Exhausted bool `json:"exhausted"`
+	// Memo is how the dead states of -memo were used, if there were
+	// any.
+	Memo *MemoStats `json:"memo,omitempty"`
+	// Workers is the number of Solvers the multi backend ran at once
+	// and Efficiency the share of the time they could have spent
+	// searching in the run that they did.
+	Workers    int     `json:"workers,omitempty"`
+	Efficiency float64 `json:"parallel_efficiency,omitempty"`
+	// Splits is the number of searches split off others to keep the
+	// workers busy.
+	Splits int `json:"splits,omitempty"`
+}
+
+// Sink receives the solutions of a run of a backend as they are found,
+// returning false to end the run. Solutions given to a Sink are not
+// kept, so a Sink that counts or streams them lets a run enumerate any
+// number of solutions in bounded memory.
+type Sink func(chain PieceChain) bool
+
+// Result is what a run of a backend came to.
+type Result struct {
+	// Solutions are the solutions found, in the order they were found,
+	// unless they went to a Sink.
+	Solutions []PieceChain
+	// Best is the longest chain reached by the linear and multi backends
+	// when they found no solution.
+	Best  PieceChain
+	Stats RunStats
+	// Reason is why the run ended: OutcomeSolved, OutcomeUnsolvable,
+	// OutcomeGaveUp once an incomplete backend used up its steps, or
+	// OutcomeCancelled.
+	Reason string
+}
+
+// reason returns why a run of the linear or multi backend that ended
+// with the stats did, cancelled being true if one of its Solvers was
+// cancelled and sunk if its Sink ended it.
+func (s RunStats) reason(sunk, cancelled bool) string {
+	if cancelled && !sunk {
+		return OutcomeCancelled
 	}
+	return s.Outcome()
 }
 
-// multiPlay runs all the top level play()s concurrently.
-func multiPlay(pieces []*Piece) {
-	fmt.Printf("%d top levels!\n", len(pieces[0].Masks))
-	wg := sync.WaitGroup{}
-	for i := range pieces[0].Masks {
-		wg.Add(1)
-		chain := []PieceMask{PieceMask{pieces[0], i}}
-		go func(c PieceChain) {
-			play(pieces[1:], c)
-			wg.Done()
-			fmt.Println("One top level done")
-		}(chain)
+// heuristicResult returns the Result of a run of the beam, anneal or
+// genetic backend that started at start and found chain, or nil. The
+// chain goes to sink if there is one and is kept otherwise.
+func heuristicResult(chain PieceChain, sink Sink, start time.Time) Result {
+	r := Result{Reason: OutcomeGaveUp}
+	r.Stats.Elapsed = time.Since(start)
+	if chain != nil {
+		if sink != nil {
+			sink(chain)
+		} else {
+			r.Solutions = []PieceChain{chain}
+		}
+		r.Stats.Solutions = 1
+		r.Stats.FirstSolution = &r.Stats.Elapsed
+		r.Reason = OutcomeSolved
 	}
-	wg.Wait()
+	return r
 }
 
-func main() {
+// cancelOn cancels the Solver when stop is closed, unless finished is
+// closed first.
+func cancelOn(stop, finished <-chan struct{}, s *Solver) {
+	go func() {
+		select {
+		case <-stop:
+			s.Cancel()
+		case <-finished:
+		}
+	}()
+}
 
-	// Setup pieces
-	parseBinary := func(s string) uint64 {
-		v, err := strconv.ParseUint(s, 2, 32)
-		if err != nil {
-			panic(err)
+// linearPlay runs a single Solver at a time. Each solution is passed to
+// sink, and the search carries on until the sink returns false or the
+// search space is exhausted. Without a sink it stops at the first
+// solution, which the Result keeps. Closing stop cancels the search.
+// Solutions and progress are reported to rep.
+func linearPlay(pieces []*Piece, sink Sink, stop <-chan struct{}, rep Reporter) Result {
+	g := NewConflictGraph(pieces)
+	solver := NewSolver(g, nil)
+	solver.Stats = adaptiveStats(g)
+	solver.Dead = deadStates
+	solver.Phases = hotspots
+	solver.Ordered = ordered
+	solver.BorderFirst = borderFirst
+	solver.Corners = cornerPieces
+	solver.FailFirst = failFirst
+	solver.ForwardCheck = forwardCheck
+	solver.RegionCheck = regionCheck
+	if stateDumps != nil {
+		stateDumps.Add(solver)
+	}
+	if traces != nil {
+		traces.Add(solver)
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	cancelOn(stop, finished, solver)
+	watch := watchProgress(rep)
+	watch.Add(solver)
+	explainDeadEnds(solver, rep)
+	defer watch.Stop()
+	var r Result
+	sunk := false
+	start := time.Now()
+	for winningChain := solver.Next(); winningChain != nil; winningChain = solver.Next() {
+		r.Stats.Solutions++
+		found := &Discovery{r.Stats.Solutions, time.Since(start), solver.Nodes}
+		if r.Stats.FirstSolution == nil {
+			r.Stats.FirstSolution = &found.Elapsed
+		}
+		announce(rep, winningChain, found)
+		if sink == nil {
+			r.Solutions = append(r.Solutions, winningChain)
+			sunk = true
+			break
+		}
+		if !sink(winningChain) {
+			sunk = true
+			break
+		}
+	}
+	r.Stats.Nodes = solver.Nodes
+	r.Stats.Elapsed = time.Since(start)
+	r.Stats.Exhausted = exhausted(solver.Done())
+	r.Stats.Memo = memoStats()
+	r.Reason = r.Stats.reason(sunk, solver.Cancelled())
+	if r.Stats.Solutions == 0 {
+		r.Best = watch.Best()
+	} else if !sunk {
+		rep.Message(fmt.Sprintf("%d solutions", r.Stats.Solutions))
+	}
+	return r
+}
+
+// reportBest says how close a search that found no solution came, best
+// being the longest chain it reached.
+func reportBest(best PieceChain, pieces int) {
+	fmt.Fprintf(messages, " :( - no solution, the most pieces placed at once were %d of %d:\n", len(best), pieces)
+	fmt.Fprintln(messages, best.boards(chainLabel))
+}
+
+// multiPlay runs a Solver per placement of the first piece, as many at
+// once as -workers says or the machine has CPUs to spare, splitting off
+// parts of long searches for workers left idle, and passes solutions to
+// sink like linearPlay, one at a time. Once the sink returns false, or
+// without a sink after the first solution, or when stop is closed, all
+// the Solvers are cancelled. Solutions and progress are reported to rep.
+func multiPlay(pieces []*Piece, sink Sink, stop <-chan struct{}, rep Reporter) Result {
+	rep.Message(fmt.Sprintf("%d top levels!", len(pieces[0].Masks)))
+	g := NewConflictGraph(pieces)
+	learned := adaptiveStats(g)
+	watch := watchProgress(rep)
+	watch.Expect(len(pieces[0].Masks))
+	defer watch.Stop()
+	finished := make(chan struct{})
+	defer close(finished)
+	halt := make(chan struct{})
+	var haltOnce sync.Once
+	stopAll := func() { haltOnce.Do(func() { close(halt) }) }
+	go func() {
+		select {
+		case <-stop:
+			stopAll()
+		case <-finished:
+		}
+	}()
+	// mu serializes the solutions, so that they are counted and passed
+	// to the sink one at a time and none after it asked to stop.
+	var mu sync.Mutex
+	var r Result
+	sunk := false
+	var nodes uint64
+	var unfinished, cancelled int32
+	// With ordered Solvers the solutions are passed on in order: those
+	// of each top level once the ones before it are all done. searches
+	// numbers the top levels.
+	var inOrder *solutionOrder
+	searches := map[*Solver]int{}
+	if ordered {
+		inOrder = newSolutionOrder()
+	}
+	start := time.Now()
+	// deliver counts a solution and passes it on, with mu locked.
+	deliver := func(chain PieceChain, nodes uint64) {
+		if sunk {
+			return
+		}
+		r.Stats.Solutions++
+		d := &Discovery{r.Stats.Solutions, time.Since(start), nodes}
+		if d.Index == 1 {
+			r.Stats.FirstSolution = &d.Elapsed
+		}
+		announce(rep, chain, d)
+		if sink == nil {
+			r.Solutions = append(r.Solutions, chain)
+			sunk = true
+		} else if !sink(chain) {
+			sunk = true
+		}
+		if sunk {
+			stopAll()
+		}
+	}
+	pool := newWorkerPool(workerCount)
+	sp := newSplitter(pool, func(solver *Solver) {
+		select {
+		case <-halt:
+			// Stopped before this search got its turn.
+			atomic.StoreInt32(&unfinished, 1)
+			atomic.StoreInt32(&cancelled, 1)
+			return
+		default:
+		}
+		solver.Stats = learned
+		solver.Dead = deadStates
+		solver.Phases = hotspots
+		solver.Ordered = ordered
+		solver.BorderFirst = borderFirst
+		solver.Corners = cornerPieces
+		solver.FailFirst = failFirst
+		solver.ForwardCheck = forwardCheck
+		solver.RegionCheck = regionCheck
+		if stateDumps != nil {
+			stateDumps.Add(solver)
+		}
+		if traces != nil {
+			traces.Add(solver)
+		}
+		cancelOn(halt, finished, solver)
+		watch.Add(solver)
+		explainDeadEnds(solver, rep)
+		mu.Lock()
+		search := searches[solver]
+		mu.Unlock()
+		for winningChain := solver.Next(); winningChain != nil; winningChain = solver.Next() {
+			mu.Lock()
+			if sunk {
+				mu.Unlock()
+				break
+			}
+			if inOrder == nil {
+				deliver(winningChain, solver.Nodes)
+			} else {
+				for _, c := range inOrder.add(search, winningChain) {
+					deliver(c, solver.Nodes)
+				}
+			}
+			mu.Unlock()
 		}
-		return v
+		if inOrder != nil && solver.Done() {
+			mu.Lock()
+			for _, c := range inOrder.finish(search) {
+				deliver(c, solver.Nodes)
+			}
+			mu.Unlock()
+		}
+		atomic.AddUint64(&nodes, solver.Nodes)
+		if !solver.Done() {
+			atomic.StoreInt32(&unfinished, 1)
+		}
+		if solver.Cancelled() {
+			atomic.StoreInt32(&cancelled, 1)
+		}
+		if verbosity >= 2 {
+			rep.Message("One search done")
+		}
+	})
+	tops := make([]func(), len(pieces[0].Masks))
+	for i := range tops {
+		i := i
+		tops[i] = func() {
+			solver := NewSolver(g, PieceChain{PieceMask{pieces[0], i}})
+			solver.Share = 1 / float64(len(tops))
+			mu.Lock()
+			searches[solver] = i
+			mu.Unlock()
+			sp.run(solver)
+		}
+	}
+	if inOrder == nil {
+		// Split searches would finish out of order.
+		sp.watch()
 	}
+	pool.run(tops)
+	r.Stats.Splits = sp.Stop()
+	r.Stats.Nodes = nodes
+	r.Stats.Elapsed = time.Since(start)
+	r.Stats.Exhausted = exhausted(unfinished == 0)
+	r.Stats.Memo = memoStats()
+	r.Stats.Workers = pool.workers
+	r.Stats.Efficiency = pool.efficiency(r.Stats.Elapsed)
+	r.Reason = r.Stats.reason(sunk, cancelled == 1)
+	if r.Stats.Solutions == 0 {
+		r.Best = watch.Best()
+	}
+	return r
+}
 
-	pieces := []*Piece{
-		NewPiece("+", 3, 3, parseBinary("010111010")),
-		NewPiece("Z", 3, 3, parseBinary("110010011")),
-		NewPiece("-L", 3, 3, parseBinary("010110011")),
-		NewPiece("_L", 3, 3, parseBinary("010010111")),
-		NewPiece("|", 1, 5, parseBinary("11111")),
-		NewPiece("Li", 2, 3, parseBinary("101111")),
-		NewPiece("|.", 2, 4, parseBinary("10101110")),
-		NewPiece("L_", 3, 3, parseBinary("100100111")),
-		NewPiece("C", 2, 3, parseBinary("111011")),
-		NewPiece("M", 3, 3, parseBinary("110011001")),
-		NewPiece("_S", 4, 2, parseBinary("00111110")),
-		NewPiece("L", 2, 4, parseBinary("10101011")),
+// storeSolution appends a solution to the store, if there is one, and
+// exits if that fails: carrying on would defeat the purpose of the store.
+func storeSolution(store SolutionStore, c PieceChain) {
+	if store == nil {
+		return
+	}
+	if err := store.Append(c); err != nil {
+		fmt.Fprintf(os.Stderr, "storing solution: %v\n", err)
+		os.Exit(exitError)
 	}
+}
 
-	// Sort the pieces by largest average shadow descending
+// sortPieces sorts the pieces by largest average shadow descending, which
+// makes for a much faster search. Pieces with a single placement, like
+// hinted ones, go first.
+func sortPieces(pieces []*Piece) {
 	sort.Slice(pieces, func(i, j int) bool {
+		if ifixed, jfixed := len(pieces[i].Masks) == 1, len(pieces[j].Masks) == 1; ifixed != jfixed {
+			return ifixed
+		}
 		iBitsSum := float32(0)
 		for _, s := range pieces[i].Shadows {
 			iBitsSum += float32(s.BitsSet())
@@ -334,8 +1034,472 @@ func main() {
 		}
 		return jBitsSum/float32(len(pieces[j].Shadows)) < iBitsSum/float32(len(pieces[i].Shadows))
 	})
+}
+
+// pieceOrders are the ways of ordering pieces for the search by name, see
+// -order. Pieces with a single placement go first in all of them. Only
+// the random order uses rng.
+var pieceOrders = map[string]func(pieces []*Piece, rng *rand.Rand){
+	"shadow": func(pieces []*Piece, rng *rand.Rand) { sortPieces(pieces) },
+	// The adaptive order starts out like shadow, the rest is up to the
+	// Solvers, see SearchStats.
+	"adaptive": func(pieces []*Piece, rng *rand.Rand) { sortPieces(pieces) },
+	"fewest": func(pieces []*Piece, rng *rand.Rand) {
+		sortPiecesBy(pieces, func(p *Piece) float32 { return -float32(len(p.Masks)) })
+	},
+	"size": func(pieces []*Piece, rng *rand.Rand) {
+		sortPiecesBy(pieces, func(p *Piece) float32 { return float32(p.Masks[0].BitsSet()) })
+	},
+	"given": func(pieces []*Piece, rng *rand.Rand) {
+		sortPiecesBy(pieces, func(p *Piece) float32 { return 0 })
+	},
+	"random": func(pieces []*Piece, rng *rand.Rand) {
+		rng.Shuffle(len(pieces), func(i, j int) { pieces[i], pieces[j] = pieces[j], pieces[i] })
+		sortPiecesBy(pieces, func(p *Piece) float32 { return 0 })
+	},
+}
+
+// sortPiecesBy sorts the pieces with a single placement first and then
+// by score descending, keeping pieces with equal scores in order.
+func sortPiecesBy(pieces []*Piece, score func(p *Piece) float32) {
+	sort.SliceStable(pieces, func(i, j int) bool {
+		if ifixed, jfixed := len(pieces[i].Masks) == 1, len(pieces[j].Masks) == 1; ifixed != jfixed {
+			return ifixed
+		}
+		return score(pieces[j]) < score(pieces[i])
+	})
+}
+
+// pieceOrderNames returns the names of the piece orders in order.
+func pieceOrderNames() []string {
+	var names []string
+	for name := range pieceOrders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
-	linearPlay(pieces)
-	//multiPlay(pieces)
+// Exit codes of hreen. Subcommands use exitError and exitUsage too.
+const (
+	// exitSolved means at least one solution was found.
+	exitSolved = 0
+	// exitError means something went wrong, like a file that can't be
+	// read or written.
+	exitError = 1
+	// exitUsage means the command line was wrong, as for the flag
+	// package.
+	exitUsage = 2
+	// exitUnsolvable means the puzzle was proved to have no solution.
+	exitUnsolvable = 3
+	// exitGaveUp means the backend stopped without finding a solution
+	// or proving there is none.
+	exitGaveUp = 4
+)
+
+// outcomeExits maps the outcomes of runs to exit codes.
+var outcomeExits = map[string]int{
+	OutcomeSolved:     exitSolved,
+	OutcomeUnsolvable: exitUnsolvable,
+	OutcomeGaveUp:     exitGaveUp,
+}
+
+// commands are the subcommands run as `hreen NAME ARGS...`. Without a
+// subcommand hreen solves the puzzle.
+var commands = map[string]func(args []string){
+	"archive":     archiveCommand,
+	"bench":       benchCommand,
+	"burrtools":   burrToolsCommand,
+	"cluster":     clusterCommand,
+	"compact-log": compactLogCommand,
+	"duel":        duelCommand,
+	"edit-board":  editBoardCommand,
+	"edit-piece":  editPieceCommand,
+	"generate":    generateCommand,
+	"merge-logs":  mergeLogsCommand,
+	"play":        playCommand,
+	"polyform":    polyformCommand,
+	"polyominoes": polyominoesCommand,
+	"presets":     presetsCommand,
+	"rank":        rankCommand,
+	"replay":      replayCommand,
+	"serve":       serveCommand,
+	"show":        showCommand,
+	"solve":       solveCommand,
+	"tile":        tileCommand,
+	"trace":       traceCommand,
+	"unique":      uniqueCommand,
+	"unrank":      unrankCommand,
+}
+
+func main() {
+
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	source := puzzleFlag(flag.CommandLine)
+	backend := flag.String("backend", "linear", "search backend: linear, multi, beam, anneal or genetic")
+	order := flag.String("order", "shadow", "order to place the pieces in: "+strings.Join(pieceOrderNames(), ", "))
+	beamWidth := flag.Int("beam-width", 1000, "number of partial chains kept per depth by the beam backend")
+	annealSteps := flag.Int("anneal-steps", 20000000, "number of moves tried by the anneal backend")
+	population := flag.Int("population", 500, "population size of the genetic backend")
+	generations := flag.Int("generations", 20000, "number of generations bred by the genetic backend")
+	seed := flag.Int64("seed", 0, "random seed for the randomized backends, 0 picks one from the clock")
+	all := flag.Bool("all", false, "enumerate all solutions rather than stopping at the first (linear and multi backends)")
+	logPath := flag.String("log", "", "append every solution found to this crash-safe log file")
+	logSync := flag.Int("log-sync", 100, "sync the solution log to disk every this many solutions")
+	dedup := flag.Bool("dedup", false, "only store solutions not stored before in this run")
+	archivePath := flag.String("archive", "", "write every solution found to this compressed, indexed archive")
+	heatmapText := flag.Bool("heatmap", false, "print how often each cell is covered across all solutions found")
+	heatmapPNG := flag.String("heatmap-png", "", "draw how often each cell is covered across all solutions found to this PNG file")
+	symmetry := flag.Bool("symmetry", false, "also count the solutions found up to the rotations and reflections of the board the puzzle keeps, with -all")
+	breakSymmetry := flag.Bool("break-symmetry", false, "find only one or a few of the solutions the rotations and reflections the puzzle keeps take to one another")
+	placementStats := flag.Bool("placement-stats", false, "print per piece placement and orientation frequencies across all solutions found")
+	sqlPath := flag.String("sql", "", "write the run and its solutions to this SQL script for loading into SQLite")
+	csvPath := flag.String("csv", "", "write every piece placement of every solution found to this CSV file")
+	output := outputFlag(flag.CommandLine)
+	profilePath := flag.String("profile", "", "with -order adaptive, start from what earlier runs learned about the pieces, saved in this file, and save what this run learns to it")
+	summaryPath := flag.String("summary", "", "write a JSON summary of the run to this file, - for stdout")
+	quiet := flag.Bool("q", false, "print nothing but the solutions and the reports asked for")
+	verbose := flag.Bool("v", false, "report the progress of the linear and multi backends every second")
+	veryVerbose := flag.Bool("vv", false, "like -v, adding the placements tried per piece")
+	flag.IntVar(&explainDepth, "explain", -1, "say why the linear and multi backends backtrack, at dead ends with at most this many pieces placed")
+	timing := flag.Bool("timing", false, "print when each solution was found by the linear and multi backends: how manyth, after how long and how many placements")
+	memo := flag.String("memo", "", "remember the states of the linear and multi backends that lead nowhere: exact, forgetting the least recently used beyond -memo-mem, or bloom, in -memo-mem but possibly missing solutions, so not with -all")
+	flag.IntVar(&workerCount, "workers", 0, "number of Solvers the multi backend runs at once, 0 for one per CPU, fewer while other programs keep CPUs busy")
+	hotspotsFlag := flag.Bool("hotspots", false, "time a sample of the steps of the linear and multi backends and print where the time went at the end")
+	maxMem := flag.Int("max-mem", 0, "megabytes of heap to stay under by giving up -memo states and -dedup keys, with a warning each time, 0 for no limit")
+	smallest := flag.Bool("smallest", false, "find the lexicographically smallest solution, placing the pieces in the puzzle's order, each by orientation, then row, then column")
+	canonical := flag.Bool("canonical", false, "find the solutions in lexicographic order, as with -smallest, the same on every run and machine")
+	maxDepth := flag.Int("max-depth", 0, "only place the first this many pieces, in search order, and print the partial chains that survive instead of solutions")
+	frontierPath := flag.String("frontier", "", "write the partial chains of -max-depth to this file rather than stdout")
+	borderFirstFlag := flag.Bool("border-first", false, "have the linear and multi backends try first the placements hugging the edges of the board and the pieces placed, exposing the least new board")
+	corners := flag.Int("corners", 0, "have the linear and multi backends try first the placements of the first this many pieces placed that cover a corner of the board")
+	cornerSeed := flag.Bool("corner-seed", false, "only place the first piece placed in a corner of the board, one of those alike by symmetry, to find a solution sooner, losing those placing it elsewhere")
+	failFirstFlag := flag.Bool("fail-first", false, "have the linear and multi backends place next whichever piece has the fewest placements left, rather than in the order of -order")
+	forwardCheckFlag := flag.Bool("forward-check", false, "have the linear and multi backends end a branch as soon as a placement leaves a piece without a placement")
+	regionCheckFlag := flag.Bool("region-check", false, "have the linear and multi backends end a branch as soon as the pieces left can't share out the regions of the board left to them")
+	dumpPath := flag.String("dump", "", "append the state of the linear and multi backends' searches to this file as JSON on SIGUSR1 and if they panic")
+	tracePath := flag.String("trace", "", "record every placement and backtrack of the linear and multi backends to this file, for hreen trace view")
+	traceDepth := flag.Int("trace-depth", 0, "with -trace, only record the events with at most this many pieces placed, 0 for all")
+	memoMem := flag.Int("memo-mem", 64, "megabytes the states of -memo may take, 0 for no limit with -memo exact")
+	parseFlags(flag.CommandLine, os.Args[1:])
+	if *hotspotsFlag {
+		hotspots = &PhaseTimes{}
+	}
+	switch *memo {
+	case "":
+	case "exact":
+		deadStates = NewExactDeadStates(*memoMem << 20)
+	case "bloom":
+		if *all {
+			// Enumerating the solutions can't afford to miss any.
+			fmt.Fprintln(os.Stderr, "-memo bloom may miss solutions, use -memo exact with -all")
+			os.Exit(exitUsage)
+		}
+		deadStates = NewBloomDeadStates(*memoMem << 20)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -memo %q, use exact or bloom\n", *memo)
+		os.Exit(exitUsage)
+	}
+	checkOutput(*output)
+	if *output == "json" {
+		reports = os.Stderr
+	}
+	messages = reports
+	switch {
+	case *quiet:
+		verbosity = -1
+		messages = ioutil.Discard
+	case *veryVerbose:
+		verbosity = 2
+	case *verbose:
+		verbosity = 1
+	}
+	rep := &WriterReporter{Solutions: solutionWriter(os.Stdout, *output), Messages: messages, Format: *output, Timing: *timing}
+
+	pieces := source.pieces()
+	sortOrder, ok := pieceOrders[*order]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown order %q, try one of %s\n", *order, strings.Join(pieceOrderNames(), ", "))
+		os.Exit(exitUsage)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(*seed))
+	if *population < geneticElites {
+		// The fittest geneticElites individuals carry over to each
+		// generation, so there must be at least that many.
+		fmt.Fprintf(os.Stderr, "-population must be at least %d\n", geneticElites)
+		os.Exit(exitUsage)
+	}
+	if *maxDepth > 0 && *backend != "linear" {
+		fmt.Fprintln(os.Stderr, "-max-depth searches as the linear backend does, it can't be used with another -backend")
+		os.Exit(exitUsage)
+	}
+	if *maxDepth > 0 && (*logPath != "" || *sqlPath != "" || *csvPath != "" || *archivePath != "" || *heatmapText || *heatmapPNG != "" || *symmetry || *placementStats || *dedup || *tracePath != "") {
+		// The partial chains it finds aren't solutions to store, and
+		// it exits without closing the stores.
+		fmt.Fprintln(os.Stderr, "-max-depth writes partial chains to -frontier, it can't be used with -log, -sql, -csv, -archive, -heatmap, -heatmap-png, -symmetry, -placement-stats, -dedup or -trace")
+		os.Exit(exitUsage)
+	}
+	if *smallest && *all {
+		fmt.Fprintln(os.Stderr, "-smallest finds a single solution, try -canonical with -all")
+		os.Exit(exitUsage)
+	}
+	if *smallest || *canonical {
+		if *failFirstFlag {
+			fmt.Fprintln(os.Stderr, "-smallest and -canonical place the pieces in the puzzle's order, they can't be used with -fail-first")
+			os.Exit(exitUsage)
+		}
+		if *backend != "linear" && *backend != "multi" {
+			fmt.Fprintln(os.Stderr, "-smallest and -canonical need the linear or multi backend")
+			os.Exit(exitUsage)
+		}
+		// The order of the pieces is part of the canonical order.
+		sortOrder = pieceOrders["given"]
+		ordered = true
+	}
+	sortOrder(pieces, rng)
+	borderFirst = *borderFirstFlag
+	cornerPieces = *corners
+	failFirst = *failFirstFlag
+	forwardCheck = *forwardCheckFlag
+	regionCheck = *regionCheckFlag
+	if *tracePath != "" {
+		var err error
+		if traces, err = createTrace(*tracePath, *traceDepth); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+	}
+	if *dumpPath != "" {
+		var err error
+		if stateDumps, err = openDumps(*dumpPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+	}
+	adaptive.on = *order == "adaptive"
+	if *profilePath != "" {
+		if !adaptive.on {
+			fmt.Fprintln(os.Stderr, "-profile needs -order adaptive")
+			os.Exit(exitUsage)
+		}
+		profile, err := LoadProfile(*profilePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		profile.OrderPieces(pieces)
+		adaptive.profile = profile
+	}
+
+	var stores SolutionStores
+	if *logPath != "" {
+		log, err := OpenSolutionLog(*logPath, *logSync)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		stores = append(stores, log)
+	}
+	if *sqlPath != "" {
+		sql, err := CreateSQLStore(*sqlPath, *backend, *seed, pieces)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		stores = append(stores, sql)
+	}
+	if *csvPath != "" {
+		csv, err := CreateCSVStore(*csvPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		stores = append(stores, csv)
+	}
+	if *archivePath != "" {
+		archive, err := CreateArchive(*archivePath, pieces)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		stores = append(stores, archive)
+	}
+	var heatmap *Heatmap
+	if *heatmapText || *heatmapPNG != "" {
+		heatmap = NewHeatmap()
+		stores = append(stores, heatmap)
+	}
+	var symmetries *SymmetryCounter
+	if *symmetry {
+		symmetries = NewSymmetryCounter(pieces, *breakSymmetry || *cornerSeed)
+		stores = append(stores, symmetries)
+	}
+	if *breakSymmetry {
+		syms, _ := PuzzleSymmetries(pieces)
+		if piece, placements := BreakSymmetry(pieces, syms); piece != nil {
+			fmt.Fprintf(messages, " breaking the puzzle's symmetry by placing piece %s in %d of its %d placements\n", piece.Symbol, len(piece.Masks), placements)
+		} else {
+			fmt.Fprintln(messages, " no symmetry to break")
+		}
+	}
+	if *cornerSeed {
+		if *breakSymmetry {
+			fmt.Fprintln(os.Stderr, "-corner-seed breaks the puzzle's symmetry itself, it can't be used with -break-symmetry")
+			os.Exit(exitUsage)
+		}
+		syms, _ := PuzzleSymmetries(pieces)
+		if placements := SeedCorner(pieces, syms); placements > 0 {
+			cornerSeeded = true
+			fmt.Fprintf(messages, " seeding piece %s in %d of its %d placements, covering a corner of the board\n", pieces[0].Symbol, len(pieces[0].Masks), placements)
+		} else {
+			fmt.Fprintf(messages, " piece %s covers no corner of the board, not seeding it\n", pieces[0].Symbol)
+		}
+	}
+	var stats *PlacementStats
+	if *placementStats {
+		stats = NewPlacementStats()
+		stores = append(stores, stats)
+	}
+	var store SolutionStore
+	var shrinkers []Shrinker
+	if d, ok := deadStates.(*ExactDeadStates); ok {
+		shrinkers = append(shrinkers, d)
+	}
+	if len(stores) > 0 {
+		store = stores
+		if *dedup {
+			d := NewDedupStore(stores)
+			store = d
+			shrinkers = append(shrinkers, d)
+		}
+	}
+	if *maxMem > 0 {
+		// The guard runs until hreen exits.
+		guardMemory(uint64(*maxMem)<<20, func(text string) { fmt.Fprintln(os.Stderr, text) }, shrinkers...)
+	}
+
+	// Interrupting stops the linear and multi backends, which then
+	// report what they found. A second interrupt kills hreen.
+	stop := make(chan struct{})
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		fmt.Fprintln(messages, " interrupted, stopping")
+		close(stop)
+	}()
+
+	if *maxDepth > 0 {
+		frontierPlay(pieces, *maxDepth, *frontierPath, stop)
+	}
+
+	// The solutions go to the stores, if any, rather than being kept,
+	// and with -all the search goes on after the first.
+	sink := func(chain PieceChain) bool {
+		storeSolution(store, chain)
+		return *all
+	}
+	var result Result
+	switch *backend {
+	case "linear":
+		result = linearPlay(pieces, sink, stop, rep)
+	case "multi":
+		result = multiPlay(pieces, sink, stop, rep)
+	case "beam":
+		result = beamPlay(pieces, *beamWidth, sink, rep)
+		if result.Reason == OutcomeGaveUp {
+			fmt.Fprintln(messages, " :( - beam too narrow, try a larger -beam-width")
+		}
+	case "anneal":
+		result = annealPlay(pieces, *annealSteps, rng, sink, rep)
+		if result.Reason == OutcomeGaveUp {
+			fmt.Fprintf(messages, " :( - no luck with seed %d, try another -seed or more -anneal-steps\n", *seed)
+		}
+	case "genetic":
+		result = geneticPlay(pieces, *population, *generations, rng, sink, rep)
+		if result.Reason == OutcomeGaveUp {
+			fmt.Fprintf(messages, " :( - no luck with seed %d, try another -seed or more -generations\n", *seed)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown backend %q\n", *backend)
+		os.Exit(exitUsage)
+	}
+	if err := flushSolutions(rep.Solutions); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	if traces != nil {
+		if err := traces.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "-trace: %v\n", err)
+			os.Exit(exitError)
+		}
+	}
+	if err := rep.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	if result.Best != nil {
+		reportBest(result.Best, len(pieces))
+	}
+	run := result.Stats
+	if run.Workers > 0 {
+		fmt.Fprintf(messages, " %d workers, %.0f%% parallel efficiency, %d searches split off\n", run.Workers, 100*run.Efficiency, run.Splits)
+	}
+	if run.Memo != nil {
+		fmt.Fprintf(messages, " %v\n", *run.Memo)
+	}
+	if adaptive.profile != nil && adaptive.stats != nil {
+		adaptive.profile.Learn(adaptive.g, adaptive.stats)
+		if err := adaptive.profile.Save(*profilePath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+	}
+	if *summaryPath != "" {
+		summary := NewRunSummary(pieces, flag.CommandLine, run)
+		summary.Outcome = result.Reason
+		if err := WriteRunSummary(*summaryPath, summary); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+	}
+
+	if stats != nil {
+		stats.WriteText(reports)
+	}
+	if symmetries != nil {
+		symmetries.WriteText(reports)
+	}
+	if hotspots != nil {
+		hotspots.WriteText(reports)
+	}
+	if heatmap != nil {
+		if *heatmapText {
+			heatmap.WriteText(reports)
+		}
+		if *heatmapPNG != "" {
+			if err := heatmap.WritePNG(*heatmapPNG); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(exitError)
+			}
+		}
+	}
+	if store != nil {
+		if err := store.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+	}
 
+	os.Exit(outcomeExits[run.Outcome()])
 }
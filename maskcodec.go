@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maskHexDigits is the number of hex digits of a mask's cells, the 100
+// bits of the board.
+const maskHexDigits = (BoardDim*BoardDim + 3) / 4
+
+// Masks are written in two ways. The compact one, from MarshalText, is
+// the cells as a hexadecimal number, the top left cell its lowest bit:
+//
+//	0x0000000000000000000000c03
+//
+// The grid, from MarshalJSON, is a row string per row of the board, #
+// for occupied cells and . for empty ones:
+//
+//	["##........", "#.........", "..........", ...]
+//
+// Both are read back by either unmarshaler. Grids may have fewer or
+// shorter rows than the board and may use X, as String does, for
+// occupied cells; in text their rows are separated by / or new lines.
+
+// Rows returns the rows of the mask's grid.
+func (m Mask) Rows() []string {
+	rows := make([]string, BoardDim)
+	for y := uint(0); y < BoardDim; y++ {
+		row := make([]byte, BoardDim)
+		for x := uint(0); x < BoardDim; x++ {
+			row[x] = '.'
+			if m.At(x, y) == 1 {
+				row[x] = '#'
+			}
+		}
+		rows[y] = string(row)
+	}
+	return rows
+}
+
+// MaskFromRows returns the mask drawn by the rows of a grid.
+func MaskFromRows(rows []string) (Mask, error) {
+	var m Mask
+	if len(rows) > BoardDim {
+		return m, fmt.Errorf("mask has %d rows, the board %d", len(rows), BoardDim)
+	}
+	for y, row := range rows {
+		if len(row) > BoardDim {
+			return m, fmt.Errorf("mask row %d has %d cells, the board %d", y+1, len(row), BoardDim)
+		}
+		for x, c := range row {
+			switch c {
+			case '#', 'X':
+				m = m.OrBitWith(uint(x), uint(y), 1)
+			case '.':
+			default:
+				return m, fmt.Errorf("mask row %d: bad cell %q", y+1, c)
+			}
+		}
+	}
+	return m, nil
+}
+
+// MarshalText writes the mask in hexadecimal.
+func (m Mask) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("0x%0*x%016x", maskHexDigits-16, m[1], m[0])), nil
+}
+
+// UnmarshalText reads a mask in hexadecimal or a grid.
+func (m *Mask) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if !strings.HasPrefix(s, "0x") {
+		rows := strings.FieldsFunc(s, func(r rune) bool { return r == '/' || r == '\n' })
+		mask, err := MaskFromRows(rows)
+		if err != nil {
+			return err
+		}
+		*m = mask
+		return nil
+	}
+	hex := s[2:]
+	if len(hex) == 0 || len(hex) > maskHexDigits {
+		return fmt.Errorf("bad mask %q, want up to %d hex digits", s, maskHexDigits)
+	}
+	var mask Mask
+	if len(hex) > 16 {
+		hi, err := strconv.ParseUint(hex[:len(hex)-16], 16, 64)
+		if err != nil {
+			return fmt.Errorf("bad mask %q", s)
+		}
+		mask[1] = hi
+		hex = hex[len(hex)-16:]
+	}
+	lo, err := strconv.ParseUint(hex, 16, 64)
+	if err != nil {
+		return fmt.Errorf("bad mask %q", s)
+	}
+	mask[0] = lo
+	*m = mask
+	return nil
+}
+
+// MarshalJSON writes the mask as the rows of its grid.
+func (m Mask) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Rows())
+}
+
+// UnmarshalJSON reads a mask written as the rows of its grid or as a
+// string read by UnmarshalText.
+func (m *Mask) UnmarshalJSON(data []byte) error {
+	var rows []string
+	if err := json.Unmarshal(data, &rows); err == nil {
+		mask, err := MaskFromRows(rows)
+		if err != nil {
+			return err
+		}
+		*m = mask
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("a mask is a list of rows or a string, not %s", data)
+	}
+	return m.UnmarshalText([]byte(s))
+}
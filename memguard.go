@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// memCheckEvery is how often -max-mem looks at the heap.
+const memCheckEvery = 250 * time.Millisecond
+
+// Shrinker is something that holds memory it can do without, at a price,
+// when memory runs short.
+type Shrinker interface {
+	// Shrink gives up some memory and says what it gave up, or returns
+	// "" if it has nothing left to give up.
+	Shrink() string
+}
+
+// memGuard keeps the heap under a limit by shrinking the Shrinkers, one
+// at a time and in the order given, whenever the heap grows past it,
+// warning each time. A run going over the limit this way gets slower
+// rather than being killed for running out of memory.
+type memGuard struct {
+	limit     uint64
+	shrinkers []Shrinker
+	warn      func(text string)
+	stop      chan struct{}
+	done      sync.WaitGroup
+}
+
+// guardMemory starts checking the heap against limit bytes every
+// memCheckEvery until Stop is called, also telling the garbage
+// collector to work harder to stay under it.
+func guardMemory(limit uint64, warn func(text string), shrinkers ...Shrinker) *memGuard {
+	g := &memGuard{limit: limit, shrinkers: shrinkers, warn: warn, stop: make(chan struct{})}
+	debug.SetMemoryLimit(int64(limit))
+	g.done.Add(1)
+	go func() {
+		defer g.done.Done()
+		ticker := time.NewTicker(memCheckEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				g.check()
+			case <-g.stop:
+				return
+			}
+		}
+	}()
+	return g
+}
+
+// check shrinks the first Shrinker that has anything left to give up if
+// the heap is over the limit.
+func (g *memGuard) check() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if m.HeapAlloc <= g.limit {
+		return
+	}
+	for len(g.shrinkers) > 0 {
+		if what := g.shrinkers[0].Shrink(); what != "" {
+			debug.FreeOSMemory()
+			g.warn(fmt.Sprintf("warning: the heap is at %d MB, over -max-mem, %s", m.HeapAlloc>>20, what))
+			return
+		}
+		g.shrinkers = g.shrinkers[1:]
+	}
+	g.warn(fmt.Sprintf("warning: the heap is at %d MB, over -max-mem, with nothing left to give up", m.HeapAlloc>>20))
+	g.limit = m.HeapAlloc + m.HeapAlloc/2
+}
+
+// Stop stops checking the heap.
+func (g *memGuard) Stop() {
+	close(g.stop)
+	g.done.Wait()
+}
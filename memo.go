@@ -0,0 +1,249 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// DeadStates remembers states of the search that lead to no solution,
+// so that a Solver reaching one again, by placing the same cells in
+// another way, can backtrack at once rather than search it again.
+//
+// A state is the number of pieces placed, the cells they cover and, for
+// pieces whose twin is placed, the twin's cells. Since the pieces are
+// placed in a fixed order and a placement is legal only if the cells
+// placed so far keep out of its shadow, that is all the rest of the
+// search depends on. It is given to a DeadStates as a key of bytes.
+type DeadStates interface {
+	// Dead returns true if the state with the key was added as dead.
+	Dead(key []byte) bool
+	// Add records the state with the key as dead.
+	Add(key []byte)
+	// Exact is false if Dead may also return true for states never
+	// added, so that a search may miss solutions.
+	Exact() bool
+	// Stats returns how the states were used so far.
+	Stats() MemoStats
+}
+
+// MemoStats count the lookups of a DeadStates: Hits found the state
+// dead, Misses didn't. Stored is the number of states held and Evictions
+// the number forgotten to make room for others.
+type MemoStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Stored    uint64 `json:"stored"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// String sums up the stats on a line.
+func (m MemoStats) String() string {
+	rate := 0.0
+	if m.Hits+m.Misses > 0 {
+		rate = 100 * float64(m.Hits) / float64(m.Hits+m.Misses)
+	}
+	return fmt.Sprintf("memo: %d hits, %d misses (%.1f%% hit), %d states stored, %d evicted", m.Hits, m.Misses, rate, m.Stored, m.Evictions)
+}
+
+// exactStateOverhead is roughly what an ExactDeadStates spends on a state
+// besides its key: the map entry, the list element and the string.
+const exactStateOverhead = 120
+
+// ExactDeadStates is a DeadStates remembering dead states exactly. With
+// a cap on its memory it forgets the least recently used states to stay
+// within it, which only costs the search the time to find them dead
+// again. It is safe for concurrent use.
+type ExactDeadStates struct {
+	mu sync.Mutex
+	// dead maps the keys of the states to their elements of lru, whose
+	// values are the keys, most recently used first.
+	dead  map[string]*list.Element
+	lru   *list.List
+	cap   int
+	size  int
+	stats MemoStats
+}
+
+// NewExactDeadStates returns an empty ExactDeadStates taking about up to
+// capacity bytes, or as many as it takes if capacity is 0.
+func NewExactDeadStates(capacity int) *ExactDeadStates {
+	return &ExactDeadStates{dead: map[string]*list.Element{}, lru: list.New(), cap: capacity}
+}
+
+// Dead returns true if the state was added and not evicted since.
+func (d *ExactDeadStates) Dead(key []byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.dead[string(key)]
+	if !ok {
+		d.stats.Misses++
+		return false
+	}
+	d.stats.Hits++
+	d.lru.MoveToFront(e)
+	return true
+}
+
+// Add records the state, evicting the least recently used ones if that
+// takes it over its capacity.
+func (d *ExactDeadStates) Add(key []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.dead[string(key)]; ok {
+		d.lru.MoveToFront(e)
+		return
+	}
+	k := string(key)
+	d.dead[k] = d.lru.PushFront(k)
+	d.size += len(k) + exactStateOverhead
+	d.evict()
+}
+
+// evict forgets the least recently used states until the rest fit in
+// the capacity. The states must be locked.
+func (d *ExactDeadStates) evict() {
+	for d.cap > 0 && d.size > d.cap && d.lru.Len() > 1 {
+		last := d.lru.Back()
+		old := d.lru.Remove(last).(string)
+		delete(d.dead, old)
+		d.size -= len(old) + exactStateOverhead
+		d.stats.Evictions++
+	}
+	d.stats.Stored = uint64(d.lru.Len())
+}
+
+// Shrink halves the memory the states may take, forgetting the least
+// recently used half of them. It gives up once a few are left.
+func (d *ExactDeadStates) Shrink() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lru.Len() < 1024 {
+		return ""
+	}
+	before := d.lru.Len()
+	d.cap = d.size / 2
+	d.evict()
+	// The map doesn't shrink as entries are deleted, a new one does.
+	dead := make(map[string]*list.Element, len(d.dead))
+	for k, e := range d.dead {
+		dead[k] = e
+	}
+	d.dead = dead
+	return fmt.Sprintf("-memo forgot %d of %d dead states and keeps at most %d MB", before-d.lru.Len(), before, d.cap>>20)
+}
+
+// Stats returns the stats so far.
+func (d *ExactDeadStates) Stats() MemoStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+// Exact returns true.
+func (d *ExactDeadStates) Exact() bool {
+	return true
+}
+
+// bloomHashes is the number of bits a BloomDeadStates sets per state.
+const bloomHashes = 4
+
+// BloomDeadStates is a DeadStates in a Bloom filter of a fixed size. It
+// never forgets a dead state but may take a state never added for dead,
+// more often the fuller it gets, so a search using it may skip a part of
+// the search space that has solutions. It is safe for concurrent use.
+type BloomDeadStates struct {
+	// hits, misses and stored count as in MemoStats, atomically. They
+	// come first to be 64-bit aligned.
+	hits, misses, stored uint64
+	bits                 []uint64
+}
+
+// NewBloomDeadStates returns an empty Bloom filter taking size bytes.
+func NewBloomDeadStates(size int) *BloomDeadStates {
+	words := size / 8
+	if words < 1 {
+		words = 1
+	}
+	return &BloomDeadStates{bits: make([]uint64, words)}
+}
+
+// bloomHash returns two independent 64-bit FNV-1a hashes of the key,
+// from which the bits of the key are derived by double hashing.
+func bloomHash(key []byte) (uint64, uint64) {
+	h1, h2 := uint64(14695981039346656037), uint64(0x9e3779b97f4a7c15)
+	for _, b := range key {
+		h1 = (h1 ^ uint64(b)) * 1099511628211
+		h2 = (h2 ^ uint64(b)) * 0xff51afd7ed558ccd
+	}
+	return h1, h2 | 1
+}
+
+// Dead returns true if all the bits of the state are set.
+func (d *BloomDeadStates) Dead(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	n := uint64(len(d.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		if atomic.LoadUint64(&d.bits[bit/64])&(1<<(bit%64)) == 0 {
+			atomic.AddUint64(&d.misses, 1)
+			return false
+		}
+	}
+	atomic.AddUint64(&d.hits, 1)
+	return true
+}
+
+// Add sets the bits of the state.
+func (d *BloomDeadStates) Add(key []byte) {
+	atomic.AddUint64(&d.stored, 1)
+	h1, h2 := bloomHash(key)
+	n := uint64(len(d.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		w := &d.bits[bit/64]
+		for {
+			old := atomic.LoadUint64(w)
+			if old&(1<<(bit%64)) != 0 || atomic.CompareAndSwapUint64(w, old, old|1<<(bit%64)) {
+				break
+			}
+		}
+	}
+}
+
+// Exact returns false.
+func (d *BloomDeadStates) Exact() bool {
+	return false
+}
+
+// Stats returns the stats so far. Stored counts the states added, which
+// are never evicted but make mistakes likelier as they pile up.
+func (d *BloomDeadStates) Stats() MemoStats {
+	return MemoStats{
+		Hits:   atomic.LoadUint64(&d.hits),
+		Misses: atomic.LoadUint64(&d.misses),
+		Stored: atomic.LoadUint64(&d.stored),
+	}
+}
+
+// deadStates are the dead states the Solvers of the linear and multi
+// backends share, as chosen with -memo, or nil.
+var deadStates DeadStates
+
+// memoStats returns the stats of deadStates, or nil if there are none.
+func memoStats() *MemoStats {
+	if deadStates == nil {
+		return nil
+	}
+	stats := deadStates.Stats()
+	return &stats
+}
+
+// exhausted returns whether a search that ran out of placements to try
+// searched the whole search space, which it can't be sure of if it
+// skipped states deadStates only guessed were dead or -corner-seed
+// dropped placements.
+func exhausted(done bool) bool {
+	return done && !cornerSeeded && (deadStates == nil || deadStates.Exact())
+}
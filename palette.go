@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/color"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Palette returns the colour of the i'th of n pieces.
+type Palette func(i, n int) color.RGBA
+
+// cycle returns a palette going round the colours given as #rrggbb.
+func cycle(colors ...string) Palette {
+	rgb := make([]color.RGBA, len(colors))
+	for i, s := range colors {
+		rgb[i], _ = parseColor(s)
+	}
+	return func(i, n int) color.RGBA { return rgb[i%len(rgb)] }
+}
+
+// palettes are the palettes to choose from with -palette.
+var palettes = map[string]Palette{
+	// okabe-ito is the colour blind safe palette of Okabe and Ito, less
+	// its black, which would hide the labels.
+	"okabe-ito": cycle("#e69f00", "#56b4e9", "#009e73", "#f0e442", "#0072b2", "#d55e00", "#cc79a7", "#999999"),
+	// tol is Paul Tol's bright palette, also colour blind safe.
+	"tol": cycle("#4477aa", "#66ccee", "#228833", "#ccbb44", "#ee6677", "#aa3377", "#bbbbbb"),
+	// wheel spreads the pieces evenly around the colour wheel, telling
+	// many apart as long as the colours can be seen.
+	"wheel": wheelColor,
+	// grey goes from dark to light grey, for printing in black and white.
+	"grey": func(i, n int) color.RGBA {
+		v := uint8(64 + 160*i/n)
+		return color.RGBA{v, v, v, 255}
+	},
+}
+
+// defaultPalette is the name of the palette used unless -palette says
+// otherwise.
+const defaultPalette = "okabe-ito"
+
+// paletteName is the palette chosen with -palette.
+var paletteName = defaultPalette
+
+// wheelColor returns the colour of the i'th of n pieces, spread evenly
+// around the colour wheel.
+func wheelColor(i, n int) color.RGBA {
+	h := 6 * float64(i) / float64(n)
+	f := uint8(255 * (h - float64(int(h))))
+	switch int(h) {
+	case 0:
+		return color.RGBA{255, f, 0, 255}
+	case 1:
+		return color.RGBA{255 - f, 255, 0, 255}
+	case 2:
+		return color.RGBA{0, 255, f, 255}
+	case 3:
+		return color.RGBA{0, 255 - f, 255, 255}
+	case 4:
+		return color.RGBA{f, 0, 255, 255}
+	}
+	return color.RGBA{255, 0, 255 - f, 255}
+}
+
+// paletteNames returns the names of the palettes, sorted.
+func paletteNames() []string {
+	var names []string
+	for name := range palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// paletteFlag defines the -palette flag on fs.
+func paletteFlag(fs *flag.FlagSet) {
+	fs.StringVar(&paletteName, "palette", defaultPalette, "colours of the pieces with -output ansi and in exported puzzles, unless the puzzle gives them: "+strings.Join(paletteNames(), ", "))
+}
+
+// checkPalette exits with a usage error if -palette names no palette.
+func checkPalette() {
+	if _, ok := palettes[paletteName]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown palette %q, try one of %s\n", paletteName, strings.Join(paletteNames(), ", "))
+		os.Exit(exitUsage)
+	}
+}
+
+// parseColor reads a colour written as #rrggbb.
+func parseColor(s string) (color.RGBA, error) {
+	c := color.RGBA{A: 255}
+	if len(s) != 7 || s[0] != '#' {
+		return c, fmt.Errorf("bad colour %q, want #rrggbb", s)
+	}
+	if _, err := fmt.Sscanf(s[1:], "%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, fmt.Errorf("bad colour %q, want #rrggbb", s)
+	}
+	return c, nil
+}
+
+// Colors returns the colours of the puzzle's pieces, those the puzzle
+// gives them or else the palette's.
+func (p *Puzzle) Colors(palette Palette) ([]color.RGBA, error) {
+	colors := make([]color.RGBA, len(p.Pieces))
+	for i, d := range p.Pieces {
+		if d.Color == "" {
+			colors[i] = palette(i, len(p.Pieces))
+			continue
+		}
+		c, err := parseColor(d.Color)
+		if err != nil {
+			return nil, fmt.Errorf("piece %s: %v", d.Symbol, err)
+		}
+		colors[i] = c
+	}
+	return colors, nil
+}
+
+// ansiPaint paints a cell of a grid, its label padded, in the colour of
+// the i'th piece of c with ANSI escape codes, black or white on it
+// whichever is easier to read. Cells are given a space more to be about
+// square; empty cells, i < 0, are left unpainted.
+func (c PieceChain) ansiPaint(i int, cell string) string {
+	if i < 0 {
+		return cell + " "
+	}
+	bg, ok := pieceColors[c[i].Piece]
+	if !ok {
+		bg = palettes[paletteName](i, len(c))
+	}
+	fg := 97
+	if 299*int(bg.R)+587*int(bg.G)+114*int(bg.B) > 128000 {
+		fg = 30
+	}
+	return fmt.Sprintf("\x1b[%d;48;2;%d;%d;%dm%s \x1b[0m", fg, bg.R, bg.G, bg.B, cell)
+}
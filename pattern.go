@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Colours of cells. A piece drawn with Shape may give its cells colours
+// by drawing them with letters instead of #, the same letter for the same
+// colour, and a board may colour its cells with Pattern. A piece's
+// coloured cells may then only cover cells of the board of the same
+// colour or without one, as the checkered pieces of a checkerboard
+// puzzle have to line up with the checkerboard. Cells drawn with # fit
+// any cell.
+
+// isColour returns true if r draws a coloured cell.
+func isColour(r rune) bool {
+	return unicode.IsLetter(r)
+}
+
+// colours returns the cells of each colour of the piece drawn as rows,
+// in the same bits as the mask ParseShape returns, or nil if it has
+// none.
+func colours(rows []string) (map[rune]uint64, error) {
+	width, _, _, err := ParseShape(rows)
+	if err != nil {
+		return nil, err
+	}
+	// ParseShape drops empty rows and columns around the piece.
+	x0, y0 := -1, -1
+	for y, row := range rows {
+		for x, r := range []rune(row) {
+			if r == '#' || isColour(r) {
+				if x0 < 0 || x < x0 {
+					x0 = x
+				}
+				if y0 < 0 {
+					y0 = y
+				}
+			}
+		}
+	}
+	var cs map[rune]uint64
+	for y, row := range rows {
+		for x, r := range []rune(row) {
+			if isColour(r) {
+				if cs == nil {
+					cs = map[rune]uint64{}
+				}
+				cs[r] |= 1 << (uint(y-y0)*width + uint(x-x0))
+			}
+		}
+	}
+	return cs, nil
+}
+
+// pattern returns the cells of each colour of the board, or nil if it
+// has no pattern.
+func (b *Board) pattern() (map[rune]Mask, error) {
+	if len(b.Pattern) == 0 {
+		return nil, nil
+	}
+	if uint(len(b.Pattern)) > b.Height {
+		return nil, fmt.Errorf("the pattern has %d rows, the board %d", len(b.Pattern), b.Height)
+	}
+	cs := map[rune]Mask{}
+	for y, row := range b.Pattern {
+		runes := []rune(row)
+		if uint(len(runes)) > b.Width {
+			return nil, fmt.Errorf("pattern row %d has %d cells, the board %d", y+1, len(runes), b.Width)
+		}
+		for x, r := range runes {
+			switch {
+			case isColour(r):
+				cs[r] = cs[r].OrBitWith(uint(x), uint(y), 1)
+			case r == '.' || r == '#' || r == ' ':
+			default:
+				return nil, fmt.Errorf("pattern row %d: unexpected %q, use letters for colours and . for any", y+1, r)
+			}
+		}
+	}
+	return cs, nil
+}
+
+// pattern returns the cells of each colour of the puzzle's boards as laid
+// out, or nil if none has a pattern.
+func (p *Puzzle) pattern() (map[rune]Mask, error) {
+	spots, err := p.Layout()
+	if err != nil {
+		return nil, err
+	}
+	var cs map[rune]Mask
+	for i, spot := range spots {
+		bcs, err := spot.Board.pattern()
+		if err != nil {
+			if len(p.Boards) > 0 {
+				return nil, fmt.Errorf("board %d: %v", i+1, err)
+			}
+			return nil, fmt.Errorf("board: %v", err)
+		}
+		for r, m := range bcs {
+			if cs == nil {
+				cs = map[rune]Mask{}
+			}
+			cs[r] = cs[r].OrWith(m.Translated(int(spot.X), int(spot.Y)))
+		}
+	}
+	return cs, nil
+}
+
+// transformedCells returns the cells of part of a shape, the whole of
+// which is base, after transform t, moved by as much as the whole is to
+// be normalized.
+func transformedCells(base, part Mask, t int) (whole, moved Mask) {
+	if t >= 4 {
+		base, part = base.Flipped(), part.Flipped()
+	}
+	for i := 0; i < t%4; i++ {
+		base, part = base.Rotated90(), part.Rotated90()
+	}
+	x, y, _, _ := base.Bounds()
+	return base.Normalized(), part.Translated(-int(x), -int(y))
+}
+
+// matchPattern restricts the piece, made by NewPiece with the width,
+// height and mask given and cs the cells of each colour, to the
+// placements whose coloured cells only cover cells of the pattern of the
+// same colour or of none. A placement fits if any of the transforms of
+// the piece giving its orientation does, as a piece looking the same
+// turned around may have its colours elsewhere.
+func (p *Piece) matchPattern(width, height uint, pmask uint64, cs map[rune]uint64, pattern map[rune]Mask) {
+	if len(cs) == 0 || len(pattern) == 0 {
+		return
+	}
+	toMask := func(bits uint64) Mask {
+		var m Mask
+		for y := uint(0); y < height; y++ {
+			for x := uint(0); x < width; x++ {
+				m = m.OrBitWith(x, y, uint(bits>>(y*width+x)&1))
+			}
+		}
+		return m
+	}
+	var coloured Mask
+	for _, m := range pattern {
+		coloured = coloured.OrWith(m)
+	}
+	// others[r] are the cells a cell of colour r may not cover: those of
+	// other colours.
+	others := map[rune]Mask{}
+	for r := range cs {
+		others[r] = Mask{coloured[0] &^ pattern[r][0], coloured[1] &^ pattern[r][1]}
+	}
+	// colourings[oi] holds the cells of each colour of each transform
+	// giving orientation oi, at the top left.
+	colourings := make([][]map[rune]Mask, len(p.Orientations))
+	base := toMask(pmask)
+	for t := 0; t < 8; t++ {
+		c := map[rune]Mask{}
+		var whole Mask
+		for r, bits := range cs {
+			whole, c[r] = transformedCells(base, toMask(bits), t)
+		}
+		for oi, o := range p.Orientations {
+			if o.Mask == whole {
+				colourings[oi] = append(colourings[oi], c)
+			}
+		}
+	}
+	p.restrict(func(mi int) bool {
+		pl := p.Placements[mi]
+		for _, c := range colourings[pl.Orientation] {
+			fits := true
+			for r, m := range c {
+				if !m.Translated(int(pl.X), int(pl.Y)).AndWith(others[r]).Zero() {
+					fits = false
+					break
+				}
+			}
+			if fits {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PieceJSON is the JSON form of a Piece:
+//
+//	{"symbol": "L", "shape": ["#.", "#.", "##"],
+//	 "orientations": [{"transform": 0, "width": 2, "height": 3, "shape": ["#.", "#.", "##"]}, ...]}
+//
+// Shape is the piece as it was defined and Orientations its distinct
+// rotations and reflections in the order NewPiece makes them, so the
+// orientation of a placement can be looked up without building the
+// piece. Placements is only written when the board or hints left the
+// piece fewer placements than an empty board would, and Touching when
+// the rules let the piece touch others.
+type PieceJSON struct {
+	Symbol       string            `json:"symbol"`
+	Shape        []string          `json:"shape"`
+	Orientations []OrientationJSON `json:"orientations,omitempty"`
+	Placements   []Placement       `json:"placements,omitempty"`
+	Touching     bool              `json:"touching,omitempty"`
+}
+
+// OrientationJSON is the JSON form of an Orientation.
+type OrientationJSON struct {
+	Transform int      `json:"transform"`
+	Width     uint     `json:"width"`
+	Height    uint     `json:"height"`
+	Shape     []string `json:"shape"`
+}
+
+// Shape draws the orientation the way ParseShape reads it.
+func (o Orientation) Shape() []string {
+	rows := o.Mask.Rows()[:o.Height]
+	for y := range rows {
+		rows[y] = rows[y][:o.Width]
+	}
+	return rows
+}
+
+// boardPlacements returns the number of placements the piece has on an
+// empty board.
+func (p *Piece) boardPlacements() int {
+	n := 0
+	for _, o := range p.Orientations {
+		n += int((BoardDim + 1 - o.Width) * (BoardDim + 1 - o.Height))
+	}
+	return n
+}
+
+// MarshalJSON writes the piece as a PieceJSON.
+func (p *Piece) MarshalJSON() ([]byte, error) {
+	pj := PieceJSON{Symbol: p.Symbol, Shape: p.Orientations[0].Shape()}
+	for _, o := range p.Orientations {
+		pj.Orientations = append(pj.Orientations, OrientationJSON{o.Transform, o.Width, o.Height, o.Shape()})
+	}
+	if len(p.Placements) != p.boardPlacements() {
+		pj.Placements = p.Placements
+	}
+	pj.Touching = len(p.Masks) > 0
+	for mi := range p.Masks {
+		if p.Shadows[mi] != p.Masks[mi] {
+			pj.Touching = false
+			break
+		}
+	}
+	return json.Marshal(pj)
+}
+
+// UnmarshalJSON builds the piece from a PieceJSON. Orientations may be
+// left out; if given they have to be the ones the shape makes.
+func (p *Piece) UnmarshalJSON(data []byte) error {
+	var pj PieceJSON
+	if err := json.Unmarshal(data, &pj); err != nil {
+		return err
+	}
+	w, h, v, err := ParseShape(pj.Shape)
+	if err != nil {
+		return fmt.Errorf("piece %s: %v", pj.Symbol, err)
+	}
+	piece, err := NewPiece(pj.Symbol, w, h, v)
+	if err != nil {
+		return fmt.Errorf("piece %s: %v", pj.Symbol, err)
+	}
+	if pj.Orientations != nil {
+		if len(pj.Orientations) != len(piece.Orientations) {
+			return fmt.Errorf("piece %s has %d orientations, not %d", pj.Symbol, len(piece.Orientations), len(pj.Orientations))
+		}
+		for i, o := range piece.Orientations {
+			oj := pj.Orientations[i]
+			ow, oh, ov, err := ParseShape(oj.Shape)
+			if err != nil {
+				return fmt.Errorf("piece %s, orientation %d: %v", pj.Symbol, i, err)
+			}
+			got := Orientation{oj.Transform, ow, oh, Mask{}}
+			for y := uint(0); y < oh; y++ {
+				for x := uint(0); x < ow; x++ {
+					got.Mask = got.Mask.OrBitWith(x, y, uint(ov>>(y*ow+x)&1))
+				}
+			}
+			if got != o || oj.Width != o.Width || oj.Height != o.Height {
+				return fmt.Errorf("piece %s: orientation %d is not %s as the shape makes it", pj.Symbol, i, o)
+			}
+		}
+	}
+	if pj.Placements != nil {
+		keep := map[Placement]bool{}
+		for _, pl := range pj.Placements {
+			keep[pl] = true
+		}
+		piece.restrict(func(mi int) bool { return keep[piece.Placements[mi]] })
+		if len(piece.Placements) != len(keep) {
+			return fmt.Errorf("piece %s: some placements are off the board or not of its orientations", pj.Symbol)
+		}
+	}
+	if pj.Touching {
+		piece.Shadows = append([]Mask(nil), piece.Masks...)
+	}
+	*p = *piece
+	return nil
+}
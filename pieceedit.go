@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// drawShadow draws the piece's first orientation, #, with its shadow
+// around it, +.
+func drawShadow(p *Piece) string {
+	o := p.Orientations[0]
+	m := o.Mask.Translated(1, 1)
+	shadow := m.Shadow()
+	s := strings.Builder{}
+	for y := uint(0); y < o.Height+2; y++ {
+		for x := uint(0); x < o.Width+2; x++ {
+			switch {
+			case m.At(x, y) == 1:
+				s.WriteString("# ")
+			case shadow.At(x, y) == 1:
+				s.WriteString("+ ")
+			default:
+				s.WriteString("  ")
+			}
+		}
+		s.WriteByte('\n')
+	}
+	return s.String()
+}
+
+const pieceEditHelp = `arrows or hjkl move, space toggles a cell, c clears, a appends the piece
+to the puzzle, q quits`
+
+// editPiece lets the user draw pieces, showing their orientations and
+// shadow, and appends them to the puzzle saved at path.
+func editPiece(t *terminal, puzzle *Puzzle, path string) error {
+	var cells [BoardDim][BoardDim]bool
+	x, y := 0, 0
+	status := ""
+	for {
+		var drawn [][2]int
+		for cy := range cells {
+			for cx := range cells[cy] {
+				if cells[cy][cx] {
+					drawn = append(drawn, [2]int{cx, cy})
+				}
+			}
+		}
+		var piece *Piece
+		preview := "draw a piece"
+		width, height, pmask, err := ParseCells(drawn)
+		if len(drawn) > 0 {
+			if err == nil {
+				piece, err = NewPiece("", width, height, pmask)
+			}
+			if err != nil {
+				preview = err.Error()
+			} else {
+				preview = fmt.Sprintf("%d orientations:\n%s\nshadow:\n%s", len(piece.Orientations), drawOrientations(piece), drawShadow(piece))
+			}
+		}
+
+		screen := fmt.Sprintf("pieces of %s: %d\n\n", path, len(puzzle.Pieces)) +
+			drawCells(BoardDim, BoardDim, x, y, func(x, y int) string {
+				if cells[y][x] {
+					return "#"
+				}
+				return "."
+			}) +
+			"\n" + pieceEditHelp + "\n" + status + "\n\n" + preview
+		t.draw(screen)
+		status = ""
+		k, err := t.readKey()
+		if err != nil {
+			return err
+		}
+		if moveCursor(k, &x, &y, BoardDim, BoardDim) {
+			continue
+		}
+		switch k {
+		case ' ':
+			cells[y][x] = !cells[y][x]
+		case 'c':
+			cells = [BoardDim][BoardDim]bool{}
+		case 'a':
+			if piece == nil {
+				status = "nothing to append"
+				break
+			}
+			symbol, err := t.prompt(screen, "symbol: ")
+			if err != nil {
+				return err
+			}
+			if symbol == "" {
+				break
+			}
+			if puzzle.hasPiece(symbol) {
+				status = fmt.Sprintf("there already is a piece %s", symbol)
+				break
+			}
+			puzzle.Pieces = append(puzzle.Pieces, PieceDef{Symbol: symbol, Shape: drawShape(width, height, pmask)})
+			if err := puzzle.Save(path); err != nil {
+				puzzle.Pieces = puzzle.Pieces[:len(puzzle.Pieces)-1]
+				status = err.Error()
+				break
+			}
+			status = fmt.Sprintf("appended %s", symbol)
+			if _, err := puzzle.Build(); err != nil {
+				status += fmt.Sprintf(", but the puzzle won't work: %v", err)
+			}
+		case 'q', 3:
+			return nil
+		}
+	}
+}
+
+// hasPiece returns true if the puzzle has a piece with the symbol.
+func (p *Puzzle) hasPiece(symbol string) bool {
+	for _, d := range p.Pieces {
+		if d.Symbol == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// editPieceCommand implements `hreen edit-piece FILE`.
+func editPieceCommand(args []string) {
+	fs := flag.NewFlagSet("edit-piece", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen edit-piece FILE")
+		fmt.Fprintln(os.Stderr, "Draws pieces in the terminal and appends them to a puzzle file, creating")
+		fmt.Fprintln(os.Stderr, "the file if needed.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	path := fs.Arg(0)
+	puzzle, err := LoadPuzzle(path)
+	if os.IsNotExist(err) {
+		puzzle, err = &Puzzle{Pieces: []PieceDef{}}, nil
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+
+	t, err := openTerminal()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	err = editPiece(t, puzzle, path)
+	t.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+}
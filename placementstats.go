@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// PlacementStats counts how often each placement of each piece appears
+// across the solutions it is given. It is a SolutionStore.
+type PlacementStats struct {
+	mu        sync.Mutex
+	Solutions int
+	// Counts holds the number of solutions using each mask index of each
+	// piece.
+	Counts map[*Piece]map[int]int
+}
+
+// NewPlacementStats returns empty PlacementStats.
+func NewPlacementStats() *PlacementStats {
+	return &PlacementStats{Counts: map[*Piece]map[int]int{}}
+}
+
+// Append counts the placements of the solution.
+func (s *PlacementStats) Append(c PieceChain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Solutions++
+	for _, p := range c {
+		counts := s.Counts[p.Piece]
+		if counts == nil {
+			counts = map[int]int{}
+			s.Counts[p.Piece] = counts
+		}
+		counts[p.MaskIndex]++
+	}
+	return nil
+}
+
+// Close does nothing, the counts stay available.
+func (s *PlacementStats) Close() error {
+	return nil
+}
+
+// Forced returns the mask indices of the piece that appear in every
+// solution.
+func (s *PlacementStats) Forced(p *Piece) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var forced []int
+	for mi, n := range s.Counts[p] {
+		if n == s.Solutions {
+			forced = append(forced, mi)
+		}
+	}
+	sort.Ints(forced)
+	return forced
+}
+
+// WriteText writes, per piece, how many distinct placements it has across
+// the solutions, how often each orientation is used and which placements
+// are forced.
+func (s *PlacementStats) WriteText(w io.Writer) {
+	s.mu.Lock()
+	var pieces []*Piece
+	for p := range s.Counts {
+		pieces = append(pieces, p)
+	}
+	s.mu.Unlock()
+	sort.Slice(pieces, func(i, j int) bool { return pieces[i].Symbol < pieces[j].Symbol })
+
+	fmt.Fprintf(w, "placements over %d solutions:\n", s.Solutions)
+	for _, p := range pieces {
+		s.mu.Lock()
+		counts := s.Counts[p]
+		orientations := make([]int, len(p.Orientations))
+		for mi, n := range counts {
+			orientations[p.Placements[mi].Orientation] += n
+		}
+		s.mu.Unlock()
+
+		fmt.Fprintf(w, "%-4s %d of %d placements used\n", p.Symbol, len(counts), len(p.Masks))
+		order := make([]int, len(orientations))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool { return orientations[order[i]] > orientations[order[j]] })
+		for _, oi := range order {
+			if orientations[oi] == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "       %-22s %5.1f%%\n", p.Orientations[oi], 100*float64(orientations[oi])/float64(s.Solutions))
+		}
+		for _, mi := range s.Forced(p) {
+			pl := p.Placements[mi]
+			fmt.Fprintf(w, "       forced: %s at %d,%d\n", p.Orientations[pl.Orientation], pl.X, pl.Y)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Game is a position of a puzzle being solved by hand: the pieces and the
+// placements made so far.
+type Game struct {
+	Pieces []*Piece
+	Chain  PieceChain
+	// undo holds the chains before each move, the latest last, and redo
+	// those undone, the latest undone last.
+	undo, redo []PieceChain
+}
+
+// NewGame returns a game of the puzzle made of pieces with nothing placed.
+func NewGame(pieces []*Piece) *Game {
+	return &Game{Pieces: pieces}
+}
+
+// piece returns the piece with the symbol.
+func (g *Game) piece(symbol string) (*Piece, error) {
+	for _, p := range g.Pieces {
+		if p.Symbol == symbol {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("there is no piece %s", symbol)
+}
+
+// placed returns the index of the piece in the chain or -1.
+func (g *Game) placed(p *Piece) int {
+	for i, pm := range g.Chain {
+		if pm.Piece == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// Place places the piece with the symbol in the orientation, numbered as
+// in puzzle hints, with the top left corner of its bounding box at x, y.
+// It returns an error saying why if the move is illegal.
+func (g *Game) Place(symbol string, orientation int, x, y uint) error {
+	p, err := g.piece(symbol)
+	if err != nil {
+		return err
+	}
+	if g.placed(p) >= 0 {
+		return fmt.Errorf("%s is already on the board, remove it first", symbol)
+	}
+	if orientation < 0 || orientation >= len(p.Orientations) {
+		return fmt.Errorf("%s has orientations 0 to %d", symbol, len(p.Orientations)-1)
+	}
+	mi := -1
+	for i, pl := range p.Placements {
+		if pl == (Placement{orientation, x, y}) {
+			mi = i
+		}
+	}
+	if mi < 0 {
+		return fmt.Errorf("%s doesn't fit on the board at %d,%d", symbol, x, y)
+	}
+	m := p.Masks[mi]
+	for _, pm := range g.Chain {
+		other := pm.Piece.Masks[pm.MaskIndex]
+		switch {
+		case !m.AndWith(other).Zero():
+			return fmt.Errorf("%s would overlap %s", symbol, pm.Piece.Symbol)
+		case !m.AndWith(pm.Piece.Shadows[pm.MaskIndex]).Zero() || !other.AndWith(p.Shadows[mi]).Zero():
+			return fmt.Errorf("%s would touch %s", symbol, pm.Piece.Symbol)
+		}
+	}
+	g.move(append(g.Chain[:len(g.Chain):len(g.Chain)], PieceMask{p, mi}))
+	return nil
+}
+
+// move makes chain the position, remembering the one before for Undo.
+func (g *Game) move(chain PieceChain) {
+	g.undo = append(g.undo, g.Chain)
+	g.redo = nil
+	g.Chain = chain
+}
+
+// Undo takes back the last move not already taken back.
+func (g *Game) Undo() error {
+	if len(g.undo) == 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	g.redo = append(g.redo, g.Chain)
+	g.Chain = g.undo[len(g.undo)-1]
+	g.undo = g.undo[:len(g.undo)-1]
+	return nil
+}
+
+// Redo makes the last move taken back by Undo again.
+func (g *Game) Redo() error {
+	if len(g.redo) == 0 {
+		return fmt.Errorf("nothing to redo")
+	}
+	g.undo = append(g.undo, g.Chain)
+	g.Chain = g.redo[len(g.redo)-1]
+	g.redo = g.redo[:len(g.redo)-1]
+	return nil
+}
+
+// Hint places a piece where it goes in a way of completing the position
+// found within budget. The move can be undone like any other.
+func (g *Game) Hint(budget time.Duration) (PieceMask, error) {
+	completion, sure := CanComplete(g.Chain, g.Remaining(), budget)
+	switch {
+	case !sure:
+		return PieceMask{}, fmt.Errorf("couldn't find a way on from here within %v", budget)
+	case completion == nil:
+		return PieceMask{}, fmt.Errorf("this can't be solved any more, try undo")
+	case len(completion) == 0:
+		return PieceMask{}, fmt.Errorf("the puzzle is solved")
+	}
+	g.move(append(g.Chain[:len(g.Chain):len(g.Chain)], completion[0]))
+	return completion[0], nil
+}
+
+// Remove takes the piece with the symbol off the board.
+func (g *Game) Remove(symbol string) error {
+	p, err := g.piece(symbol)
+	if err != nil {
+		return err
+	}
+	i := g.placed(p)
+	if i < 0 {
+		return fmt.Errorf("%s is not on the board", symbol)
+	}
+	g.move(append(g.Chain[:i:i], g.Chain[i+1:]...))
+	return nil
+}
+
+// Remaining returns the pieces not on the board yet.
+func (g *Game) Remaining() []*Piece {
+	var remaining []*Piece
+	for _, p := range g.Pieces {
+		if g.placed(p) < 0 {
+			remaining = append(remaining, p)
+		}
+	}
+	return remaining
+}
+
+// Solved returns true once all the pieces are on the board.
+func (g *Game) Solved() bool {
+	return len(g.Chain) == len(g.Pieces)
+}
+
+// Solvable searches for a way of placing the remaining pieces for up to
+// budget. The second result is false if the budget ran out before the
+// search could tell.
+func (g *Game) Solvable(budget time.Duration) (solvable, sure bool) {
+	completion, sure := CanComplete(g.Chain, g.Remaining(), budget)
+	return completion != nil, sure
+}
+
+// Board draws the placed pieces in their symbols.
+func (g *Game) Board() string {
+	return g.Chain.grid(func(i int) string { return g.Chain[i].Piece.Symbol })
+}
+
+// drawOrientations draws the orientations of the piece side by side,
+// numbered as in Game.Place.
+func drawOrientations(p *Piece) string {
+	height := uint(0)
+	for _, o := range p.Orientations {
+		if o.Height > height {
+			height = o.Height
+		}
+	}
+	rows := make([]string, height+1)
+	for oi, o := range p.Orientations {
+		width := int(o.Width)
+		if width < 2 {
+			width = 2
+		}
+		rows[0] += fmt.Sprintf("%-*d  ", width, oi)
+		for y := uint(0); y < height; y++ {
+			row := make([]byte, width)
+			for x := range row {
+				row[x] = ' '
+				if o.Mask.At(uint(x), y) == 1 {
+					row[x] = '#'
+				}
+			}
+			rows[y+1] += string(row) + "  "
+		}
+	}
+	for i := range rows {
+		rows[i] = strings.TrimRight(rows[i], " ")
+	}
+	return strings.Join(rows, "\n") + "\n"
+}
+
+// placeCommand makes the move of a place command, args being the command
+// and its arguments: the symbol, orientation, x and y.
+func placeCommand(g *Game, args []string) error {
+	if len(args) != 5 {
+		return fmt.Errorf("usage: place SYMBOL ORIENTATION X Y")
+	}
+	var n [3]uint64
+	for i, a := range args[2:] {
+		var err error
+		if n[i], err = strconv.ParseUint(a, 10, 32); err != nil {
+			return fmt.Errorf("%q is not a number", a)
+		}
+	}
+	return g.Place(args[1], int(n[0]), uint(n[1]), uint(n[2]))
+}
+
+const playHelp = `commands:
+  place SYMBOL ORIENTATION X Y   place a piece, X and Y being the top left of its box
+  remove SYMBOL                  take a piece off the board
+  undo                           take back the last move
+  redo                           make the last move taken back again
+  hint                           place a piece where it goes in a solution
+  pieces                         show the pieces left and their orientations
+  board                          show the board
+  check                          tell whether the position can still be solved
+  help                           show this
+  quit                           stop playing
+`
+
+// play runs a game reading commands from in and writing to out until
+// in ends or the player quits.
+func play(g *Game, in io.Reader, out io.Writer, budget time.Duration) {
+	fmt.Fprint(out, g.Board())
+	fmt.Fprint(out, "type help for the commands\n> ")
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			fmt.Fprint(out, "> ")
+			continue
+		}
+		var err error
+		switch args[0] {
+		case "place", "p":
+			if err = placeCommand(g, args); err == nil {
+				fmt.Fprint(out, g.Board())
+				if g.Solved() {
+					fmt.Fprintln(out, "solved!")
+				}
+			}
+		case "remove", "r":
+			if len(args) != 2 {
+				err = fmt.Errorf("usage: remove SYMBOL")
+				break
+			}
+			if err = g.Remove(args[1]); err == nil {
+				fmt.Fprint(out, g.Board())
+			}
+		case "undo", "u":
+			if err = g.Undo(); err == nil {
+				fmt.Fprint(out, g.Board())
+			}
+		case "redo":
+			if err = g.Redo(); err == nil {
+				fmt.Fprint(out, g.Board())
+			}
+		case "hint":
+			var pm PieceMask
+			if pm, err = g.Hint(budget); err == nil {
+				pl := pm.Piece.Placements[pm.MaskIndex]
+				fmt.Fprintf(out, "place %s %d %d %d\n", pm.Piece.Symbol, pl.Orientation, pl.X, pl.Y)
+				fmt.Fprint(out, g.Board())
+				if g.Solved() {
+					fmt.Fprintln(out, "solved!")
+				}
+			}
+		case "pieces":
+			for _, p := range g.Remaining() {
+				fmt.Fprintf(out, "%s:\n%s\n", p.Symbol, drawOrientations(p))
+			}
+		case "board":
+			fmt.Fprint(out, g.Board())
+		case "check":
+			switch solvable, sure := g.Solvable(budget); {
+			case !sure:
+				fmt.Fprintf(out, "couldn't tell within %v\n", budget)
+			case solvable:
+				fmt.Fprintln(out, "this can still be solved")
+			default:
+				fmt.Fprintln(out, "this can't be solved any more")
+			}
+		case "help":
+			fmt.Fprint(out, playHelp)
+		case "quit", "q":
+			return
+		default:
+			err = fmt.Errorf("unknown command %s, type help for the commands", args[0])
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+		}
+		fmt.Fprint(out, "> ")
+	}
+}
+
+// playCommand implements `hreen play`.
+func playCommand(args []string) {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	pf := puzzleFlag(fs)
+	budget := fs.Duration("check-time", 10*time.Second, "how long check and hint may search for a solution")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen play [flags]")
+		fmt.Fprintln(os.Stderr, "Solve the puzzle by hand, placing pieces with commands read from stdin.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	play(NewGame(pf.pieces()), os.Stdin, os.Stdout, *budget)
+}
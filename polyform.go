@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ImportPolyformText reads a puzzle in the plain text layout used by
+// Polyform Puzzler and similar tools to draw puzzles. A "board:" line is
+// followed by the board, # for its cells and . or spaces for holes and
+// the outside, and a "pieces:" line by the pieces drawn side by side,
+// each in its own letter:
+//
+//	board:
+//	########
+//	###..###
+//	pieces:
+//	FF  I  LLLL
+//	 FF I     L
+//	 F  I
+//
+// Lines starting with // are comments. Letters can be any character
+// other than . and space and each piece is named after its letter. As in
+// those tools pieces may touch, the board has to fit hreen's.
+func ImportPolyformText(r io.Reader) (*Puzzle, error) {
+	var board, drawing []string
+	var section *[]string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		switch {
+		case strings.HasPrefix(line, "//"):
+		case strings.EqualFold(line, "board:"):
+			section = &board
+		case strings.EqualFold(line, "pieces:"):
+			section = &drawing
+		case section == nil:
+			if line != "" {
+				return nil, fmt.Errorf("line %d: expected board: or pieces:", n)
+			}
+		default:
+			*section = append(*section, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	puzzle := &Puzzle{Rules: &Rules{Touching: true}}
+	if len(board) > 0 {
+		b, err := polyformBoard(board)
+		if err != nil {
+			return nil, err
+		}
+		puzzle.Board = b
+	}
+
+	cells := map[rune][][2]int{}
+	for y, line := range drawing {
+		for x, r := range []rune(line) {
+			if r != '.' && r != ' ' {
+				cells[r] = append(cells[r], [2]int{x, y})
+			}
+		}
+	}
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("no pieces")
+	}
+	var letters []string
+	for r := range cells {
+		letters = append(letters, string(r))
+	}
+	sort.Strings(letters)
+	for _, l := range letters {
+		width, height, pmask, err := ParseCells(cells[[]rune(l)[0]])
+		if err != nil {
+			return nil, fmt.Errorf("piece %s: %v", l, err)
+		}
+		puzzle.Pieces = append(puzzle.Pieces, PieceDef{Symbol: l, Shape: drawShape(width, height, pmask)})
+	}
+	return puzzle, nil
+}
+
+// polyformBoard returns the board drawn in rows of # and . or spaces.
+// Blank rows and columns around the board are ignored.
+func polyformBoard(rows []string) (*Board, error) {
+	var cells [][2]int
+	for y, row := range rows {
+		for x, r := range []rune(row) {
+			switch r {
+			case '#':
+				cells = append(cells, [2]int{x, y})
+			case '.', ' ':
+			default:
+				return nil, fmt.Errorf("board row %d: unexpected %q, use # and .", y+1, r)
+			}
+		}
+	}
+	if len(cells) == 0 {
+		return nil, fmt.Errorf("empty board")
+	}
+	x0, y0, x1, y1 := cells[0][0], cells[0][1], cells[0][0], cells[0][1]
+	for _, c := range cells[1:] {
+		if c[0] < x0 {
+			x0 = c[0]
+		}
+		if c[0] > x1 {
+			x1 = c[0]
+		}
+		if c[1] > y1 {
+			y1 = c[1]
+		}
+	}
+	b := &Board{Width: uint(x1 - x0 + 1), Height: uint(y1 - y0 + 1)}
+	if b.Width > BoardDim || b.Height > BoardDim {
+		return nil, fmt.Errorf("the %dx%d board doesn't fit on the %dx%d board", b.Width, b.Height, BoardDim, BoardDim)
+	}
+	inside := map[[2]int]bool{}
+	for _, c := range cells {
+		inside[[2]int{c[0] - x0, c[1] - y0}] = true
+	}
+	for y := 0; y < int(b.Height); y++ {
+		for x := 0; x < int(b.Width); x++ {
+			if !inside[[2]int{x, y}] {
+				b.Blocked = append(b.Blocked, [2]uint{uint(x), uint(y)})
+			}
+		}
+	}
+	return b, nil
+}
+
+// polyformCommand implements `hreen polyform import FILE`.
+func polyformCommand(args []string) {
+	fs := flag.NewFlagSet("polyform", flag.ExitOnError)
+	out := fs.String("o", "", "write the puzzle to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen polyform [flags] import FILE")
+		fmt.Fprintln(os.Stderr, "Converts a puzzle drawn as text, as by Polyform Puzzler, to a puzzle file.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 || fs.Arg(0) != "import" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	f, err := os.Open(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	defer f.Close()
+	puzzle, err := ImportPolyformText(f)
+	if err == nil {
+		_, err = puzzle.Build()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(1), err)
+		os.Exit(exitError)
+	}
+	if *out == "" {
+		err = puzzle.Write(os.Stdout)
+	} else {
+		err = puzzle.Save(*out)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+}
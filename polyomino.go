@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Polyominoes returns all the polyominoes of n cells, normalized to the
+// top left corner. Fixed polyominoes are told apart by any change,
+// one-sided ones are the same if they are rotations of each other and
+// free ones are the same if they are rotations or reflections of each
+// other. Each is returned in its canonical, smallest orientation. As
+// they are grown on the board n can be at most BoardDim-1.
+func Polyominoes(n int, oneSided, free bool) []Mask {
+	// Grow all fixed polyominoes of one cell more each round. Shapes are
+	// grown away from the edges so cells can be added on every side.
+	level := map[Mask]bool{Mask{}.OrBitWith(0, 0, 1): true}
+	for size := 1; size < n; size++ {
+		next := map[Mask]bool{}
+		for m := range level {
+			m = m.Translated(1, 1)
+			frontier := m.Shadow()
+			for y := uint(0); y < BoardDim; y++ {
+				for x := uint(0); x < BoardDim; x++ {
+					if frontier.At(x, y) == 1 && m.At(x, y) == 0 {
+						next[m.OrBitWith(x, y, 1).Normalized()] = true
+					}
+				}
+			}
+		}
+		level = next
+	}
+
+	transforms := 1
+	if free {
+		transforms = 8
+	} else if oneSided {
+		transforms = 4
+	}
+	shapes := map[Mask]bool{}
+	for m := range level {
+		c := m
+		for t := 1; t < transforms; t++ {
+			if o := m.Transformed(t); o.Less(c) {
+				c = o
+			}
+		}
+		shapes[c] = true
+	}
+
+	var result []Mask
+	for m := range shapes {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Less(result[j]) })
+	return result
+}
+
+// polyominoesCommand implements `hreen polyominoes -n N`.
+func polyominoesCommand(args []string) {
+	fs := flag.NewFlagSet("polyominoes", flag.ExitOnError)
+	n := fs.Int("n", 5, fmt.Sprintf("number of cells, 1 to %d", BoardDim-1))
+	oneSided := fs.Bool("one-sided", false, "tell apart reflections but not rotations")
+	fixed := fs.Bool("fixed", false, "tell apart both reflections and rotations")
+	out := fs.String("o", "", "write the pieces to this puzzle file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen polyominoes [flags]")
+		fmt.Fprintln(os.Stderr, "Prints all free, one-sided or fixed polyominoes of a size as a puzzle file.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *n < 1 || *n > BoardDim-1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	shapes := Polyominoes(*n, *oneSided, !*oneSided && !*fixed)
+	fmt.Fprintf(os.Stderr, "%d polyominoes\n", len(shapes))
+	puzzle := &Puzzle{}
+	for i, m := range shapes {
+		puzzle.Pieces = append(puzzle.Pieces, pieceDefOf(fmt.Sprintf("%d", i+1), m))
+	}
+	var err error
+	if *out == "" {
+		err = puzzle.Write(os.Stdout)
+	} else {
+		err = puzzle.Save(*out)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Preset is a complete, named puzzle: board, pieces and rules.
+type Preset struct {
+	Name        string
+	Description string
+	Puzzle      *Puzzle
+}
+
+// presets are the built-in presets, the default first.
+var presets = []Preset{
+	{"hreen", "the original twelve piece puzzle, pieces may not touch", &defaultPuzzle},
+	{"pentominoes-6x10", "the twelve pentominoes filling a 6x10 rectangle", &Puzzle{
+		Board:  &Board{Width: 10, Height: 6},
+		Pieces: pentominoes,
+		Rules:  &Rules{Touching: true},
+	}},
+	{"scott", "Dana Scott's 1958 puzzle: the twelve pentominoes on a chessboard with a hole in the middle", &Puzzle{
+		Board:  &Board{Width: 8, Height: 8, Blocked: [][2]uint{{3, 3}, {4, 3}, {3, 4}, {4, 4}}},
+		Pieces: pentominoes,
+		Rules:  &Rules{Touching: true},
+	}},
+}
+
+// presetNamed returns the preset called name, or nil if there is none.
+func presetNamed(name string) *Preset {
+	for i := range presets {
+		if presets[i].Name == name {
+			return &presets[i]
+		}
+	}
+	return nil
+}
+
+// presetsCommand implements `hreen presets`.
+func presetsCommand(args []string) {
+	fs := flag.NewFlagSet("presets", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen presets")
+		fmt.Fprintln(os.Stderr, "Lists the puzzles that can be chosen with -preset.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	for _, p := range presets {
+		fmt.Printf("%-18s %s\n", p.Name, p.Description)
+	}
+}
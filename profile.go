@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// ProfileVersion is the version of the profile file format.
+const ProfileVersion = 1
+
+// Profile is what the adaptive order learned about a puzzle's pieces,
+// saved by -profile for later runs. Pieces are found by symbol and their
+// placements by orientation and position, so a profile carries over to
+// other puzzles with the same pieces.
+type Profile struct {
+	Version int                      `json:"version"`
+	Pieces  map[string]*PieceProfile `json:"pieces"`
+}
+
+// PieceProfile is what a Profile knows about a piece.
+type PieceProfile struct {
+	// DeadEnds is the number of times the piece had no placements left.
+	DeadEnds   uint64                          `json:"dead_ends"`
+	Placements map[Placement]*PlacementProfile `json:"-"`
+	// List is Placements as saved, JSON having no struct keys.
+	List []PlacementProfile `json:"placements"`
+}
+
+// PlacementProfile is the SearchStats of a placement.
+type PlacementProfile struct {
+	Orientation int    `json:"orientation"`
+	X           uint   `json:"x"`
+	Y           uint   `json:"y"`
+	Tries       uint64 `json:"tries"`
+	Depth       uint64 `json:"depth"`
+}
+
+// NewProfile returns an empty profile.
+func NewProfile() *Profile {
+	return &Profile{Version: ProfileVersion, Pieces: map[string]*PieceProfile{}}
+}
+
+// LoadProfile reads the profile at path, or returns an empty one if
+// there is no file there yet.
+func LoadProfile(path string) (*Profile, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewProfile(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := NewProfile()
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if p.Version != ProfileVersion {
+		return nil, fmt.Errorf("%s: profile version %d, want %d", path, p.Version, ProfileVersion)
+	}
+	for _, pp := range p.Pieces {
+		pp.Placements = map[Placement]*PlacementProfile{}
+		for i := range pp.List {
+			pl := &pp.List[i]
+			pp.Placements[Placement{pl.Orientation, pl.X, pl.Y}] = pl
+		}
+	}
+	return p, nil
+}
+
+// Save writes the profile to path.
+func (p *Profile) Save(path string) error {
+	for _, pp := range p.Pieces {
+		pp.List = pp.List[:0]
+		for _, pl := range pp.Placements {
+			pp.List = append(pp.List, *pl)
+		}
+		sort.Slice(pp.List, func(i, j int) bool {
+			a, b := pp.List[i], pp.List[j]
+			if a.Orientation != b.Orientation {
+				return a.Orientation < b.Orientation
+			}
+			if a.Y != b.Y {
+				return a.Y < b.Y
+			}
+			return a.X < b.X
+		})
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// OrderPieces moves the pieces that ran into dead ends most often first,
+// after those with a single placement, keeping the order of the others.
+func (p *Profile) OrderPieces(pieces []*Piece) {
+	sortPiecesBy(pieces, func(piece *Piece) float32 {
+		if pp, ok := p.Pieces[piece.Symbol]; ok {
+			return float32(pp.DeadEnds)
+		}
+		return 0
+	})
+}
+
+// Apply adds what the profile knows to the statistics of a search of g.
+func (p *Profile) Apply(g *ConflictGraph, stats *SearchStats) {
+	for i, piece := range g.Pieces {
+		pp, ok := p.Pieces[piece.Symbol]
+		if !ok {
+			continue
+		}
+		stats.DeadEnds[i] += pp.DeadEnds
+		for mi, pl := range piece.Placements {
+			if known, ok := pp.Placements[pl]; ok {
+				stats.Tries[g.Offsets[i]+mi] += known.Tries
+				stats.Depth[g.Offsets[i]+mi] += known.Depth
+			}
+		}
+	}
+}
+
+// Learn updates what the profile knows about the pieces of g from the
+// statistics of a search of it, which started from the profile.
+func (p *Profile) Learn(g *ConflictGraph, stats *SearchStats) {
+	for i, piece := range g.Pieces {
+		pp, ok := p.Pieces[piece.Symbol]
+		if !ok {
+			pp = &PieceProfile{Placements: map[Placement]*PlacementProfile{}}
+			p.Pieces[piece.Symbol] = pp
+		}
+		pp.DeadEnds = stats.DeadEnds[i]
+		for mi, pl := range piece.Placements {
+			if tries := stats.Tries[g.Offsets[i]+mi]; tries > 0 {
+				pp.Placements[pl] = &PlacementProfile{
+					Orientation: pl.Orientation,
+					X:           pl.X,
+					Y:           pl.Y,
+					Tries:       tries,
+					Depth:       stats.Depth[g.Offsets[i]+mi],
+				}
+			}
+		}
+	}
+}
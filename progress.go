@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressEvery is how often -v reports the progress of the search.
+const progressEvery = time.Second
+
+// verbosity is how much hreen says about the search: -1 with -q, which
+// prints nothing but solutions, 0 by default, 1 with -v for periodic
+// progress and 2 with -vv for placements tried per piece as well.
+var verbosity int
+
+// progress watches the Solvers of a search and reports how they are
+// doing to a Reporter while verbosity is 1 or more.
+type progress struct {
+	rep     Reporter
+	mu      sync.Mutex
+	solvers []*Solver
+	// searches is the number of Solvers the search will run, if known
+	// before they are all added.
+	searches int
+	start    time.Time
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+// watchProgress starts reporting on the search to rep every
+// progressEvery until Stop is called. Solvers are added with Add as they
+// are made.
+func watchProgress(rep Reporter) *progress {
+	p := &progress{rep: rep, start: time.Now(), stop: make(chan struct{})}
+	if verbosity < 1 {
+		return p
+	}
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		ticker := time.NewTicker(progressEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.report()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// Expect says how many Solvers the search will run in all, so that the
+// share of it done counts those not added yet.
+func (p *progress) Expect(searches int) {
+	p.mu.Lock()
+	p.searches = searches
+	p.mu.Unlock()
+}
+
+// Add adds a Solver to report on.
+func (p *progress) Add(s *Solver) {
+	p.mu.Lock()
+	p.solvers = append(p.solvers, s)
+	p.mu.Unlock()
+}
+
+// Stop stops reporting, after a last report with -v.
+func (p *progress) Stop() {
+	if verbosity < 1 {
+		return
+	}
+	close(p.stop)
+	p.done.Wait()
+	p.report()
+}
+
+// report prints the placements tried so far, how fast and, for a single
+// Solver, how deep it is. With -vv it adds the placements tried per
+// piece in search order.
+func (p *progress) report() {
+	p.mu.Lock()
+	solvers := append([]*Solver(nil), p.solvers...)
+	searches := p.searches
+	p.mu.Unlock()
+	if searches < len(solvers) {
+		searches = len(solvers)
+	}
+
+	var nodes uint64
+	var levels []uint64
+	depth, running, best := 0, 0, 0
+	fraction := 0.0
+	for _, s := range solvers {
+		n, l, d, done := s.Progress()
+		nodes += n
+		if s.Share > 0 {
+			fraction += s.Fraction() * s.Share
+		} else {
+			fraction += s.Fraction() / float64(searches)
+		}
+		if b := s.BestDepth(); b > best {
+			best = b
+		}
+		if levels == nil {
+			levels = make([]uint64, len(l))
+		}
+		for i := range l {
+			levels[i] += l[i]
+		}
+		if !done {
+			depth = d
+			running++
+		}
+	}
+	elapsed := time.Since(p.start)
+	done := fmt.Sprintf("%.3f%%", 100*fraction)
+	if fraction > 0 && fraction < 1e-5 {
+		done = "<0.001%"
+	}
+	line := fmt.Sprintf(" %v: %s done, %d placements, %.0f/s", elapsed.Round(time.Second), done, nodes, float64(nodes)/elapsed.Seconds())
+	if len(solvers) == 1 {
+		line += fmt.Sprintf(", %d pieces placed", depth)
+	} else {
+		line += fmt.Sprintf(", %d of %d searches running", running, len(solvers))
+	}
+	line += fmt.Sprintf(", at most %d placed", best)
+	if verbosity >= 2 && levels != nil {
+		counts := make([]string, len(levels))
+		for i, n := range levels {
+			counts[i] = fmt.Sprint(n)
+		}
+		line += fmt.Sprintf("\n   per piece: %s", strings.Join(counts, " "))
+	}
+	p.rep.Message(line)
+}
+
+// Best returns the longest chain any of the Solvers reached.
+func (p *progress) Best() PieceChain {
+	p.mu.Lock()
+	solvers := append([]*Solver(nil), p.solvers...)
+	p.mu.Unlock()
+	var best PieceChain
+	for _, s := range solvers {
+		if b := s.Best(); len(b) > len(best) {
+			best = b
+		}
+	}
+	return best
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// span describes the cells from..to of rows or columns in words, counting
+// from 1.
+func span(what string, from, to uint) string {
+	if from == to {
+		return fmt.Sprintf("%s %d", what, from+1)
+	}
+	return fmt.Sprintf("%ss %d to %d", what, from+1, to+1)
+}
+
+// Prose describes the solution in sentences, a piece each, for reading
+// out by a screen reader rather than looking at a grid:
+//
+//	Piece Z occupies rows 3 to 5 and columns 2 to 4, rotated 90°, covering 5 of those 9 cells.
+//
+// Rows and columns count from 1 at the top left of the board the piece
+// is on.
+func (c PieceChain) Prose() string {
+	s := NewSolutionJSON(c)
+	b := strings.Builder{}
+	if len(c) == 1 {
+		b.WriteString("1 piece.\n")
+	} else {
+		fmt.Fprintf(&b, "%d pieces.\n", len(c))
+	}
+	for i, pm := range c {
+		pl := s.Placements[i]
+		o := pm.Piece.Orientations[pl.Orientation]
+		turned := "as given"
+		if o.Transform != 0 {
+			turned = o.String()
+		}
+		fmt.Fprintf(&b, "Piece %s occupies %s and %s", pl.Symbol, span("row", pl.Y, pl.Y+o.Height-1), span("column", pl.X, pl.X+o.Width-1))
+		if pl.Board != 0 {
+			fmt.Fprintf(&b, " of board %d", pl.Board)
+		}
+		fmt.Fprintf(&b, ", %s", turned)
+		if n := uint(len(pl.Cells)); n < o.Width*o.Height {
+			fmt.Fprintf(&b, ", covering %d of those %d cells", n, o.Width*o.Height)
+		}
+		b.WriteString(".\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PieceDef is the definition of a piece in a puzzle file, by width,
+// height and mask, by shape or by cells.
+type PieceDef struct {
+	Symbol string `json:"symbol"`
+	Width  uint   `json:"width,omitempty"`
+	Height uint   `json:"height,omitempty"`
+	// Mask is a binary number whose least significant bit is the top
+	// left cell of the piece, followed by the rest of the top row and
+	// then the rows below.
+	Mask string `json:"mask,omitempty"`
+	// Shape draws the piece row by row, # for a cell and . for a gap,
+	// or a letter for a cell of that colour, see Board.Pattern.
+	Shape []string `json:"shape,omitempty"`
+	// Cells lists the cells of the piece as x, y pairs.
+	Cells [][2]int `json:"cells,omitempty"`
+	// Color is the colour the piece is drawn in, as #rrggbb, rather
+	// than the palette's.
+	Color string `json:"color,omitempty"`
+}
+
+// ParseShape parses a piece drawn as rows of # for cells and . or spaces
+// for gaps into the width, height and mask arguments of NewPiece. Empty
+// rows and columns around the piece are ignored. Letters are cells too,
+// of the colours colours reads.
+func ParseShape(rows []string) (width, height uint, pmask uint64, err error) {
+	var cells [][2]uint
+	x0, y0 := ^uint(0), ^uint(0)
+	for y, row := range rows {
+		for x, r := range []rune(row) {
+			switch {
+			case r == '#' || isColour(r):
+				cells = append(cells, [2]uint{uint(x), uint(y)})
+				if uint(x) < x0 {
+					x0 = uint(x)
+				}
+				if uint(y) < y0 {
+					y0 = uint(y)
+				}
+				if uint(x)+1 > width {
+					width = uint(x) + 1
+				}
+				if uint(y)+1 > height {
+					height = uint(y) + 1
+				}
+			case r == '.' || r == ' ':
+			default:
+				return 0, 0, 0, fmt.Errorf("shape row %d: unexpected %q, use #, . or letters for coloured cells", y+1, r)
+			}
+		}
+	}
+	if len(cells) == 0 {
+		return 0, 0, 0, fmt.Errorf("empty shape")
+	}
+	width, height = width-x0, height-y0
+	if width*height > 64 {
+		return 0, 0, 0, fmt.Errorf("a %dx%d piece can't be described by a 64 bit mask", width, height)
+	}
+	for _, c := range cells {
+		pmask |= 1 << ((c[1]-y0)*width + c[0] - x0)
+	}
+	return width, height, pmask, nil
+}
+
+// drawShape draws a piece given by the width, height and mask arguments
+// of NewPiece the way ParseShape reads it.
+func drawShape(width, height uint, pmask uint64) []string {
+	rows := make([]string, height)
+	for y := range rows {
+		row := make([]byte, width)
+		for x := range row {
+			row[x] = '.'
+			if pmask>>(uint(y)*width+uint(x))&1 == 1 {
+				row[x] = '#'
+			}
+		}
+		rows[y] = string(row)
+	}
+	return rows
+}
+
+// ParseCells turns a piece given as a list of x, y cell coordinates into
+// the width, height and mask arguments of NewPiece. The coordinates may
+// start anywhere, the piece is moved to the origin.
+func ParseCells(cells [][2]int) (width, height uint, pmask uint64, err error) {
+	if len(cells) == 0 {
+		return 0, 0, 0, fmt.Errorf("no cells")
+	}
+	x0, y0, x1, y1 := cells[0][0], cells[0][1], cells[0][0], cells[0][1]
+	for _, c := range cells[1:] {
+		if c[0] < x0 {
+			x0 = c[0]
+		}
+		if c[1] < y0 {
+			y0 = c[1]
+		}
+		if c[0] > x1 {
+			x1 = c[0]
+		}
+		if c[1] > y1 {
+			y1 = c[1]
+		}
+	}
+	width, height = uint(x1-x0+1), uint(y1-y0+1)
+	if width*height > 64 {
+		return 0, 0, 0, fmt.Errorf("a %dx%d piece can't be described by a 64 bit mask", width, height)
+	}
+	for _, c := range cells {
+		bit := uint64(1) << (uint(c[1]-y0)*width + uint(c[0]-x0))
+		if pmask&bit != 0 {
+			return 0, 0, 0, fmt.Errorf("cell %d,%d is listed twice", c[0], c[1])
+		}
+		pmask |= bit
+	}
+	return width, height, pmask, nil
+}
+
+// parse returns the width, height and mask arguments of NewPiece for the
+// definition, whichever way it is given.
+func (d PieceDef) parse() (width, height uint, pmask uint64, err error) {
+	given := 0
+	for _, ok := range []bool{d.Mask != "", len(d.Shape) > 0, len(d.Cells) > 0} {
+		if ok {
+			given++
+		}
+	}
+	if given > 1 {
+		return 0, 0, 0, fmt.Errorf("only one of mask, shape and cells can be given")
+	}
+	switch {
+	case len(d.Shape) > 0:
+		return ParseShape(d.Shape)
+	case len(d.Cells) > 0:
+		return ParseCells(d.Cells)
+	}
+	v, err := strconv.ParseUint(d.Mask, 2, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("bad mask %q", d.Mask)
+	}
+	return d.Width, d.Height, v, nil
+}
+
+// Hint pre-places a piece of a puzzle at one of its placements.
+type Hint struct {
+	Symbol      string `json:"symbol"`
+	Orientation int    `json:"orientation"`
+	X           uint   `json:"x"`
+	Y           uint   `json:"y"`
+}
+
+// Board is the part of the board that pieces may cover.
+type Board struct {
+	Width  uint `json:"width"`
+	Height uint `json:"height"`
+	// Blocked lists the cells, as x, y pairs, that pieces may not cover.
+	Blocked [][2]uint `json:"blocked,omitempty"`
+	// Pattern colours the cells row by row, a letter per colour and .
+	// for none. A cell of a piece of a colour may only cover a cell of
+	// the same colour or of none.
+	Pattern []string `json:"pattern,omitempty"`
+}
+
+// Rules are the rules a solution has to follow besides fitting on the
+// board.
+type Rules struct {
+	// Touching allows pieces to touch along their edges.
+	Touching bool `json:"touching,omitempty"`
+}
+
+// Puzzle is a puzzle as stored in a puzzle file. Without a board the
+// whole board is used and without rules pieces may not touch.
+//
+// Instead of a board a puzzle may have several boards, which the pieces
+// have to fill between them, each piece going on one of them. They are
+// laid out next to each other, as by Layout, and the pieces placed on
+// that layout as on a single board.
+type Puzzle struct {
+	Board  *Board     `json:"board,omitempty"`
+	Boards []Board    `json:"boards,omitempty"`
+	Pieces []PieceDef `json:"pieces"`
+	Rules  *Rules     `json:"rules,omitempty"`
+	Hints  []Hint     `json:"hints,omitempty"`
+}
+
+// defaultPuzzle is the puzzle that started it all.
+var defaultPuzzle = Puzzle{
+	Pieces: []PieceDef{
+		{Symbol: "+", Width: 3, Height: 3, Mask: "010111010"},
+		{Symbol: "Z", Width: 3, Height: 3, Mask: "110010011"},
+		{Symbol: "-L", Width: 3, Height: 3, Mask: "010110011"},
+		{Symbol: "_L", Width: 3, Height: 3, Mask: "010010111"},
+		{Symbol: "|", Width: 1, Height: 5, Mask: "11111"},
+		{Symbol: "Li", Width: 2, Height: 3, Mask: "101111"},
+		{Symbol: "|.", Width: 2, Height: 4, Mask: "10101110"},
+		{Symbol: "L_", Width: 3, Height: 3, Mask: "100100111"},
+		{Symbol: "C", Width: 2, Height: 3, Mask: "111011"},
+		{Symbol: "M", Width: 3, Height: 3, Mask: "110011001"},
+		{Symbol: "_S", Width: 4, Height: 2, Mask: "00111110"},
+		{Symbol: "L", Width: 2, Height: 4, Mask: "10101011"},
+	},
+}
+
+// pentominoes are the twelve free pentominoes.
+var pentominoes = []PieceDef{
+	{Symbol: "F", Width: 3, Height: 3, Mask: "010011110"},
+	{Symbol: "I", Width: 5, Height: 1, Mask: "11111"},
+	{Symbol: "L", Width: 2, Height: 4, Mask: "11010101"},
+	{Symbol: "N", Width: 2, Height: 4, Mask: "01111010"},
+	{Symbol: "P", Width: 2, Height: 3, Mask: "011111"},
+	{Symbol: "T", Width: 3, Height: 3, Mask: "010010111"},
+	{Symbol: "U", Width: 3, Height: 2, Mask: "111101"},
+	{Symbol: "V", Width: 3, Height: 3, Mask: "111001001"},
+	{Symbol: "W", Width: 3, Height: 3, Mask: "110011001"},
+	{Symbol: "X", Width: 3, Height: 3, Mask: "010111010"},
+	{Symbol: "Y", Width: 2, Height: 4, Mask: "10101110"},
+	{Symbol: "Z", Width: 3, Height: 3, Mask: "110010011"},
+}
+
+// tetrominoes are the five free tetrominoes.
+var tetrominoes = []PieceDef{
+	{Symbol: "I", Width: 4, Height: 1, Mask: "1111"},
+	{Symbol: "O", Width: 2, Height: 2, Mask: "1111"},
+	{Symbol: "T", Width: 3, Height: 2, Mask: "010111"},
+	{Symbol: "S", Width: 3, Height: 2, Mask: "011110"},
+	{Symbol: "L", Width: 2, Height: 3, Mask: "110101"},
+}
+
+// pieceSets are the built-in piece sets by name.
+var pieceSets = map[string]*Puzzle{
+	"hreen":       &defaultPuzzle,
+	"pentominoes": {Pieces: pentominoes},
+	"tetrominoes": {Pieces: tetrominoes},
+}
+
+// pieceSetNames returns the names of the built-in piece sets in order.
+func pieceSetNames() []string {
+	var names []string
+	for name := range pieceSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadPuzzle reads a puzzle file.
+func LoadPuzzle(path string) (*Puzzle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Puzzle
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &p, nil
+}
+
+// Write writes the puzzle in the puzzle file format to w.
+func (p *Puzzle) Write(w io.Writer) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Save writes the puzzle to a puzzle file.
+func (p *Puzzle) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := p.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Build returns the pieces of the puzzle in the order they are defined.
+func (p *Puzzle) Build() ([]*Piece, error) {
+	pieces := make([]*Piece, len(p.Pieces))
+	for i, d := range p.Pieces {
+		w, h, v, err := d.parse()
+		if err == nil {
+			pieces[i], err = NewPiece(d.Symbol, w, h, v)
+		}
+		if err == nil && d.Color != "" {
+			_, err = parseColor(d.Color)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("piece %d, %s: %v", i+1, d.Symbol, err)
+		}
+	}
+
+	cells := uint(BoardDim * BoardDim)
+	if p.Board != nil || len(p.Boards) > 0 {
+		free, err := p.free()
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := p.pattern()
+		if err != nil {
+			return nil, err
+		}
+		for i, piece := range pieces {
+			piece.restrict(func(mi int) bool { return piece.Masks[mi].AndWith(free) == piece.Masks[mi] })
+			if pattern != nil && len(p.Pieces[i].Shape) > 0 {
+				cs, _ := colours(p.Pieces[i].Shape)
+				w, h, v, _ := p.Pieces[i].parse()
+				piece.matchPattern(w, h, v, cs, pattern)
+			}
+			if len(piece.Masks) == 0 {
+				return nil, fmt.Errorf("piece %s fits nowhere on the board", piece.Symbol)
+			}
+		}
+		cells = free.BitsSet()
+	}
+	area := uint(0)
+	for _, piece := range pieces {
+		area += piece.Orientations[0].Mask.BitsSet()
+	}
+	if len(p.Boards) > 1 && area != cells {
+		return nil, fmt.Errorf("the pieces cover %d cells but the boards have %d, and they have to fill them", area, cells)
+	}
+	if area > cells {
+		return nil, fmt.Errorf("the pieces cover %d cells but the board only has %d", area, cells)
+	}
+	if p.Rules != nil && p.Rules.Touching {
+		// Pieces that may touch only conflict where they overlap.
+		for _, piece := range pieces {
+			piece.Shadows = append([]Mask(nil), piece.Masks...)
+		}
+	}
+
+	for _, h := range p.Hints {
+		var piece *Piece
+		for _, pc := range pieces {
+			if pc.Symbol == h.Symbol {
+				piece = pc
+			}
+		}
+		if piece == nil {
+			return nil, fmt.Errorf("hint for unknown piece %s", h.Symbol)
+		}
+		hinted := Placement{h.Orientation, h.X, h.Y}
+		piece.restrict(func(mi int) bool { return piece.Placements[mi] == hinted })
+		if len(piece.Masks) != 1 {
+			return nil, fmt.Errorf("piece %s: hinted placement is not on the board", h.Symbol)
+		}
+	}
+	return pieces, nil
+}
+
+// free returns the mask of the cells pieces may cover.
+func (b *Board) free() (Mask, error) {
+	if b.Width == 0 || b.Height == 0 || b.Width > BoardDim || b.Height > BoardDim {
+		return Mask{}, fmt.Errorf("size must be from 1x1 to %dx%d, not %dx%d", BoardDim, BoardDim, b.Width, b.Height)
+	}
+	var m Mask
+	for y := uint(0); y < b.Height; y++ {
+		for x := uint(0); x < b.Width; x++ {
+			m = m.OrBitWith(x, y, 1)
+		}
+	}
+	for _, c := range b.Blocked {
+		if c[0] >= b.Width || c[1] >= b.Height {
+			return Mask{}, fmt.Errorf("blocked cell %d,%d is not on the board", c[0], c[1])
+		}
+		m = m.AndBitWith(c[0], c[1], 0)
+	}
+	return m, nil
+}
+
+// boardSpot is where a board of a puzzle is on the grid the pieces are
+// placed on.
+type boardSpot struct {
+	Board
+	X, Y uint
+}
+
+// Layout returns where the boards of the puzzle go on the grid: left to
+// right and then in rows top to bottom, in the order given, with a column
+// or row of cells between them so that pieces on one can't reach another
+// or touch the pieces on it. A single board is at the top left and a
+// puzzle without boards has no layout.
+func (p *Puzzle) Layout() ([]boardSpot, error) {
+	if p.Board != nil {
+		if len(p.Boards) > 0 {
+			return nil, fmt.Errorf("a puzzle can have a board or boards, not both")
+		}
+		return []boardSpot{{Board: *p.Board}}, nil
+	}
+	var spots []boardSpot
+	x, y, rowHeight := uint(0), uint(0), uint(0)
+	for i, b := range p.Boards {
+		if x > 0 && x+b.Width > BoardDim {
+			x, y, rowHeight = 0, y+rowHeight+1, 0
+		}
+		if x+b.Width > BoardDim || y+b.Height > BoardDim {
+			return nil, fmt.Errorf("board %d doesn't fit next to the others on the %dx%d grid", i+1, BoardDim, BoardDim)
+		}
+		spots = append(spots, boardSpot{b, x, y})
+		x += b.Width + 1
+		if b.Height > rowHeight {
+			rowHeight = b.Height
+		}
+	}
+	return spots, nil
+}
+
+// free returns the cells of the grid pieces may cover, those of the
+// puzzle's boards as laid out.
+func (p *Puzzle) free() (Mask, error) {
+	spots, err := p.Layout()
+	if err != nil {
+		return Mask{}, err
+	}
+	var free Mask
+	for i, spot := range spots {
+		m, err := spot.Board.free()
+		if err != nil {
+			if len(p.Boards) > 0 {
+				return Mask{}, fmt.Errorf("board %d: %v", i+1, err)
+			}
+			return Mask{}, fmt.Errorf("board: %v", err)
+		}
+		free = free.OrWith(m.Translated(int(spot.X), int(spot.Y)))
+	}
+	return free, nil
+}
+
+// hintOf returns a hint placing a piece as in pm.
+func hintOf(pm PieceMask) Hint {
+	pl := pm.Piece.Placements[pm.MaskIndex]
+	return Hint{pm.Piece.Symbol, pl.Orientation, pl.X, pl.Y}
+}
+
+// puzzleFlags are the flags choosing the puzzle to work on.
+type puzzleFlags struct {
+	path     *string
+	pieceSet *string
+	preset   *string
+}
+
+// puzzleFlag registers the -puzzle, -pieceset and -preset flags on fs.
+func puzzleFlag(fs *flag.FlagSet) *puzzleFlags {
+	return &puzzleFlags{
+		path:     fs.String("puzzle", "", "puzzle file to use instead of the original hreen puzzle"),
+		pieceSet: fs.String("pieceset", "", "built-in piece set to use instead of the original hreen puzzle: "+strings.Join(pieceSetNames(), ", ")),
+		preset:   fs.String("preset", "", "built-in puzzle to use instead of the original hreen puzzle, see hreen presets"),
+	}
+}
+
+// puzzle returns the puzzle chosen by the flags. It exits if the puzzle
+// can't be loaded.
+func (f *puzzleFlags) puzzle() *Puzzle {
+	chosen := 0
+	for _, s := range []*string{f.path, f.pieceSet, f.preset} {
+		if *s != "" {
+			chosen++
+		}
+	}
+	if chosen > 1 {
+		fmt.Fprintln(os.Stderr, "only one of -puzzle, -pieceset and -preset can be used")
+		os.Exit(exitUsage)
+	}
+
+	switch {
+	case *f.pieceSet != "":
+		p := pieceSets[*f.pieceSet]
+		if p == nil {
+			fmt.Fprintf(os.Stderr, "unknown piece set %q, try one of %s\n", *f.pieceSet, strings.Join(pieceSetNames(), ", "))
+			os.Exit(exitUsage)
+		}
+		return p
+	case *f.preset != "":
+		preset := presetNamed(*f.preset)
+		if preset == nil {
+			fmt.Fprintf(os.Stderr, "unknown preset %q, see hreen presets\n", *f.preset)
+			os.Exit(exitUsage)
+		}
+		return preset.Puzzle
+	case *f.path != "":
+		p, err := LoadPuzzle(*f.path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		return p
+	}
+	return &defaultPuzzle
+}
+
+// pieces returns the pieces of the puzzle chosen by the flags. It exits
+// if the puzzle can't be loaded.
+func (f *puzzleFlags) pieces() []*Piece {
+	return buildPieces(f.puzzle(), *f.path)
+}
+
+// loadPieces returns the pieces of the puzzle file at path, or of the
+// default puzzle if path is empty. It exits if the puzzle can't be
+// loaded.
+func loadPieces(path string) []*Piece {
+	if path == "" {
+		return buildPieces(&defaultPuzzle, "hreen")
+	}
+	p, err := LoadPuzzle(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	return buildPieces(p, path)
+}
+
+// buildPieces returns the pieces of the puzzle loaded from path, warning
+// about pieces of the same shape, and makes it the puzzle solutions are
+// printed for. It exits if the puzzle is invalid.
+func buildPieces(p *Puzzle, path string) []*Piece {
+	pieces, err := p.Build()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		os.Exit(exitError)
+	}
+	usePuzzle(p, pieces)
+	for _, dup := range DuplicateShapes(pieces) {
+		fmt.Fprintf(os.Stderr, "%s: warning: pieces %s have the same shape\n", path, strings.Join(dup, ", "))
+	}
+	return pieces
+}
+
+// pieceDefOf returns the definition of a piece shaped like the occupied
+// cells of m.
+func pieceDefOf(symbol string, m Mask) PieceDef {
+	x0, y0, w, h := m.Bounds()
+	v := uint64(0)
+	for y := uint(0); y < h; y++ {
+		for x := uint(0); x < w; x++ {
+			v |= uint64(m.At(x0+x, y0+y)) << (y*w + x)
+		}
+	}
+	return PieceDef{Symbol: symbol, Width: w, Height: h, Mask: fmt.Sprintf("%0*b", w*h, v)}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// SolutionIndex numbers the solutions of a puzzle from 0 in canonical
+// order, the order -canonical finds them in, so that a solution can be
+// named by its number and found again from it without the others being
+// stored. It counts the solutions below the states of the search it
+// passes, remembering the counts by state as -memo does, so that
+// numbering a solution costs a search of the part of the search space
+// before it at most, and usually much less.
+//
+// The pieces are taken in the order given, which is part of what the
+// canonical order means. A SolutionIndex is not safe for concurrent use.
+type SolutionIndex struct {
+	g      *ConflictGraph
+	cands  *Candidates
+	twins  []int
+	chain  PieceChain
+	counts map[string]uint64
+	key    []byte
+}
+
+// NewSolutionIndex returns the index of the solutions of the pieces.
+func NewSolutionIndex(pieces []*Piece) *SolutionIndex {
+	g := NewConflictGraph(pieces)
+	return &SolutionIndex{
+		g:      g,
+		cands:  NewCandidates(g),
+		twins:  pieceTwins(pieces),
+		counts: map[string]uint64{},
+	}
+}
+
+// place places the next piece at mask index mi.
+func (x *SolutionIndex) place(mi int) {
+	depth := len(x.chain)
+	x.cands.Place(depth, mi)
+	x.chain = append(x.chain, PieceMask{x.g.Pieces[depth], mi})
+}
+
+// unplace undoes the last place.
+func (x *SolutionIndex) unplace() {
+	x.cands.Unplace()
+	x.chain = x.chain[:len(x.chain)-1]
+}
+
+// count returns the number of solutions completing the chain.
+func (x *SolutionIndex) count() uint64 {
+	if len(x.chain) == len(x.g.Pieces) {
+		return 1
+	}
+	x.key = stateKey(x.key[:0], x.chain, nil, x.twins)
+	if n, ok := x.counts[string(x.key)]; ok {
+		return n
+	}
+	key := string(x.key)
+	var n uint64
+	for _, mi := range legalPlacements(x.cands, x.chain, x.twins) {
+		x.place(mi)
+		n += x.count()
+		x.unplace()
+	}
+	x.counts[key] = n
+	return n
+}
+
+// Count returns the number of solutions.
+func (x *SolutionIndex) Count() uint64 {
+	return x.count()
+}
+
+// Rank returns the number of the solution: how many solutions come
+// before it. The pieces of the chain may come in any order, and pieces
+// of the same shape may swap places.
+func (x *SolutionIndex) Rank(c PieceChain) (uint64, error) {
+	canonical, err := x.canonical(c)
+	if err != nil {
+		return 0, err
+	}
+	var rank uint64
+	for _, pm := range canonical {
+		found := false
+		for _, mi := range legalPlacements(x.cands, x.chain, x.twins) {
+			if mi == pm.MaskIndex {
+				found = true
+				break
+			}
+			x.place(mi)
+			rank += x.count()
+			x.unplace()
+		}
+		if !found {
+			for len(x.chain) > 0 {
+				x.unplace()
+			}
+			return 0, fmt.Errorf("piece %s can't be placed there, the chain isn't a solution", pm.Piece.Symbol)
+		}
+		x.place(pm.MaskIndex)
+	}
+	for len(x.chain) > 0 {
+		x.unplace()
+	}
+	return rank, nil
+}
+
+// canonical returns the chain with the pieces in the index's order and
+// pieces of the same shape in mask order, as the search lists it.
+func (x *SolutionIndex) canonical(c PieceChain) (PieceChain, error) {
+	if len(c) != len(x.g.Pieces) {
+		return nil, fmt.Errorf("a solution places all %d pieces, not %d", len(x.g.Pieces), len(c))
+	}
+	at := map[*Piece]int{}
+	for i, p := range x.g.Pieces {
+		at[p] = i
+	}
+	canonical := make(PieceChain, len(c))
+	for _, pm := range c {
+		i, ok := at[pm.Piece]
+		if !ok {
+			return nil, fmt.Errorf("piece %s is not in the puzzle", pm.Piece.Symbol)
+		}
+		if canonical[i].Piece != nil {
+			return nil, fmt.Errorf("piece %s is placed twice", pm.Piece.Symbol)
+		}
+		canonical[i] = pm
+	}
+	// Sort the cells of each group of twins into mask order and give
+	// them back to the pieces in order, by insertion as groups are
+	// small.
+	for i := range canonical {
+		for j := i; x.twins[j] >= 0; j = x.twins[j] {
+			t := x.twins[j]
+			a, b := canonical[t], canonical[j]
+			am, bm := a.Piece.Masks[a.MaskIndex], b.Piece.Masks[b.MaskIndex]
+			if am.Less(bm) {
+				break
+			}
+			ai, bi := maskIndex(b.Piece, am), maskIndex(a.Piece, bm)
+			if ai < 0 || bi < 0 {
+				return nil, fmt.Errorf("pieces %s and %s can't swap places", a.Piece.Symbol, b.Piece.Symbol)
+			}
+			canonical[t], canonical[j] = PieceMask{a.Piece, bi}, PieceMask{b.Piece, ai}
+		}
+	}
+	return canonical, nil
+}
+
+// maskIndex returns the index of the mask of the piece, or -1 if it has
+// no such placement.
+func maskIndex(p *Piece, m Mask) int {
+	for mi, pm := range p.Masks {
+		if pm == m {
+			return mi
+		}
+	}
+	return -1
+}
+
+// Unrank returns the solution numbered n, counting from 0.
+func (x *SolutionIndex) Unrank(n uint64) (PieceChain, error) {
+	if total := x.count(); n >= total {
+		return nil, fmt.Errorf("there are only %d solutions", total)
+	}
+	defer func() {
+		for len(x.chain) > 0 {
+			x.unplace()
+		}
+	}()
+	for len(x.chain) < len(x.g.Pieces) {
+		for _, mi := range legalPlacements(x.cands, x.chain, x.twins) {
+			x.place(mi)
+			below := x.count()
+			if n < below {
+				break
+			}
+			n -= below
+			x.unplace()
+		}
+	}
+	return append(PieceChain(nil), x.chain...), nil
+}
+
+// RankSolution returns the number of the solution c of the pieces in
+// canonical order. Numbering many solutions is quicker with a
+// SolutionIndex, which keeps its counts from one to the next.
+func RankSolution(pieces []*Piece, c PieceChain) (uint64, error) {
+	return NewSolutionIndex(pieces).Rank(c)
+}
+
+// UnrankSolution returns the solution of the pieces numbered n in
+// canonical order.
+func UnrankSolution(pieces []*Piece, n uint64) (PieceChain, error) {
+	return NewSolutionIndex(pieces).Unrank(n)
+}
+
+// rankCommand implements `hreen rank CODE...`.
+func rankCommand(args []string) {
+	fs := flag.NewFlagSet("rank", flag.ExitOnError)
+	pf := puzzleFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen rank [flags] CODE...")
+		fmt.Fprintln(os.Stderr, "Prints the number of each solution given in the text encoding of")
+		fmt.Fprintln(os.Stderr, "-output code, counting from 0 in the order of -canonical.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	pieces := pf.pieces()
+	index := NewSolutionIndex(pieces)
+	for _, code := range fs.Args() {
+		chain, err := DecodeChainText(code, pieces)
+		if err == nil {
+			var rank uint64
+			if rank, err = index.Rank(chain); err == nil {
+				fmt.Println(rank)
+				continue
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%q: %v\n", code, err)
+		os.Exit(exitError)
+	}
+}
+
+// unrankCommand implements `hreen unrank N...`.
+func unrankCommand(args []string) {
+	fs := flag.NewFlagSet("unrank", flag.ExitOnError)
+	pf := puzzleFlag(fs)
+	output := outputFlag(fs)
+	count := fs.Bool("count", false, "print the number of solutions instead")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen unrank [flags] N...")
+		fmt.Fprintln(os.Stderr, "Draws the solutions numbered N, counting from 0 in the order of")
+		fmt.Fprintln(os.Stderr, "-canonical, without finding the others.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 && !*count {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	checkOutput(*output)
+	index := NewSolutionIndex(pf.pieces())
+	if *count {
+		fmt.Println(index.Count())
+		return
+	}
+	w := solutionWriter(os.Stdout, *output)
+	for _, arg := range fs.Args() {
+		n, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bad solution number %q\n", arg)
+			os.Exit(exitUsage)
+		}
+		chain, err := index.Unrank(n)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "solution %d: %v\n", n, err)
+			os.Exit(exitError)
+		}
+		if err := writeSolution(w, *output, nil, nil, chain); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+	}
+	if err := flushSolutions(w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+}
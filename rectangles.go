@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// tileShape is an orientation of a piece as the offsets of its cells from
+// its first cell in reading order. gutter marks the cells that only keep
+// copies apart, see tileShapes.
+type tileShape struct {
+	cells  [][2]int
+	gutter []bool
+}
+
+// tileShapes returns the orientations of the piece as tileShapes. If
+// apart, so that copies may not touch, each is grown by a gutter of a
+// cell to its right, below it and at its bottom right: tiling a rectangle
+// a cell wider and higher than the board with the grown copies leaves the
+// copies themselves on the board, none touching another even at a
+// corner, with a gutter of a cell between them.
+func tileShapes(p *Piece, apart bool) ([]tileShape, error) {
+	var shapes []tileShape
+	seen := map[Mask]bool{}
+	for _, o := range p.Orientations {
+		m := o.Mask
+		if apart {
+			if o.Width >= BoardDim || o.Height >= BoardDim {
+				return nil, fmt.Errorf("piece %s is too big to be kept apart", p.Symbol)
+			}
+			m = m.OrWith(m.Translated(1, 0)).OrWith(m.Translated(0, 1)).OrWith(m.Translated(1, 1))
+		}
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		var s tileShape
+		for y := uint(0); y < BoardDim; y++ {
+			for x := uint(0); x < BoardDim; x++ {
+				if m.At(x, y) == 1 {
+					s.cells = append(s.cells, [2]int{int(x), int(y)})
+					s.gutter = append(s.gutter, o.Mask.At(x, y) == 0)
+				}
+			}
+		}
+		first := s.cells[0]
+		for i := range s.cells {
+			s.cells[i][0] -= first[0]
+			s.cells[i][1] -= first[1]
+		}
+		shapes = append(shapes, s)
+	}
+	return shapes, nil
+}
+
+// RectangleTiling is a tiling of a rectangle by copies of a piece, as
+// found by TileRectangle.
+type RectangleTiling struct {
+	Width, Height int
+	// Copies is the number of copies of the piece.
+	Copies int
+	// cells holds, for each cell of the rectangle tiled in reading order,
+	// the number of the copy covering it from 1, or the copy's number
+	// negated if it is a cell of its gutter. stride is the width of the
+	// rectangle tiled, a cell more than Width if the copies were kept
+	// apart.
+	cells  []int
+	stride int
+}
+
+// WriteText draws the tiling with a label per copy, as chains are drawn,
+// and . for gutters.
+func (t *RectangleTiling) WriteText(w io.Writer) error {
+	b := strings.Builder{}
+	for y := 0; y < t.Height; y++ {
+		for x := 0; x < t.Width; x++ {
+			if c := t.cells[y*t.stride+x]; c > 0 {
+				b.WriteString(chainLabel(c - 1))
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// span returns the offsets of the leftmost, rightmost and bottom cells of
+// the shape from its first.
+func (s tileShape) span() (left, right, bottom int) {
+	for _, c := range s.cells {
+		if c[0] < left {
+			left = c[0]
+		}
+		if c[0] > right {
+			right = c[0]
+		}
+		if c[1] > bottom {
+			bottom = c[1]
+		}
+	}
+	return left, right, bottom
+}
+
+// TileRectangle looks for a tiling of the width by height rectangle with
+// copies of the shapes, as tileShapes returns them, trying at most budget
+// placements, or any number if budget is 0. As Tiling does it covers the
+// first empty cell at every step, and with faultFree it only finds a
+// tiling without a fault, as Tiling.FaultFree. It returns the tiling, or
+// nil if there is none, the placements tried and whether it could tell
+// before the budget ran out. With shapes kept apart the rectangle
+// searched is a cell wider and higher.
+func TileRectangle(shapes []tileShape, apart, faultFree bool, width, height int, budget uint64) (tiling *RectangleTiling, nodes uint64, sure bool) {
+	w, h := width, height
+	if apart {
+		w, h = w+1, h+1
+	}
+	cells := make([]int, w*h)
+	// rows[y] counts the copies crossing the line below row y and
+	// cols[x] those crossing the line right of column x.
+	rows, cols := make([]int, h), make([]int, w)
+	cross := func(x, y int, s tileShape, by int) {
+		left, right, bottom := s.span()
+		for i := y; i < y+bottom; i++ {
+			rows[i] += by
+		}
+		for i := x + left; i < x+right; i++ {
+			cols[i] += by
+		}
+	}
+	faulty := func(lines []int, n int) bool {
+		for _, c := range lines[:n] {
+			if c == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	out := false
+	var tile func(from, copies int) bool
+	tile = func(from, copies int) bool {
+		for from < len(cells) && cells[from] != 0 {
+			from++
+		}
+		if from == len(cells) {
+			if faultFree && (faulty(rows, h-1) || faulty(cols, w-1)) {
+				return false
+			}
+			tiling = &RectangleTiling{width, height, copies, append([]int(nil), cells...), w}
+			return true
+		}
+		x, y := from%w, from/w
+		// The copies still to place start on row y or below, so none of
+		// them can cross the lines above it.
+		if faultFree && faulty(rows, y) {
+			return false
+		}
+		for _, s := range shapes {
+			fits := true
+			for _, c := range s.cells {
+				cx, cy := x+c[0], y+c[1]
+				if cx < 0 || cx >= w || cy >= h || cells[cy*w+cx] != 0 {
+					fits = false
+					break
+				}
+			}
+			if !fits {
+				continue
+			}
+			nodes++
+			if budget > 0 && nodes > budget {
+				out = true
+				return true
+			}
+			for i, c := range s.cells {
+				label := copies + 1
+				if s.gutter[i] {
+					label = -label
+				}
+				cells[(y+c[1])*w+x+c[0]] = label
+			}
+			cross(x, y, s, 1)
+			if tile(from+1, copies+1) {
+				return true
+			}
+			cross(x, y, s, -1)
+			for _, c := range s.cells {
+				cells[(y+c[1])*w+x+c[0]] = 0
+			}
+		}
+		return false
+	}
+	tile(0, 0)
+	return tiling, nodes, !out
+}
+
+// rectanglesPlay looks for the smallest rectangles up to maxSize on a
+// side that copies of the piece tile, kept apart if apart and without a
+// fault if faultFree, trying at most budget placements on each. It
+// prints the rectangles of the smallest area that can be tiled, or all
+// that can with all, drawing the first, and exits like the solver: with
+// exitUnsolvable if there is none and exitGaveUp if the budget ran out
+// before it could tell.
+func rectanglesPlay(piece *Piece, maxSize int, apart, faultFree, all bool, budget uint64) {
+	shapes, err := tileShapes(piece, apart)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	size := len(shapes[0].cells)
+	type rectangle struct{ w, h int }
+	var rects []rectangle
+	for w := 1; w <= maxSize; w++ {
+		for h := w; h <= maxSize; h++ {
+			area := w * h
+			if apart {
+				area = (w + 1) * (h + 1)
+			}
+			if area%size == 0 {
+				rects = append(rects, rectangle{w, h})
+			}
+		}
+	}
+	sort.SliceStable(rects, func(i, j int) bool { return rects[i].w*rects[i].h < rects[j].w*rects[j].h })
+
+	found, unknown, smallest := 0, 0, 0
+	for _, r := range rects {
+		if smallest > 0 && r.w*r.h > smallest && !all {
+			break
+		}
+		tiling, nodes, sure := TileRectangle(shapes, apart, faultFree, r.w, r.h, budget)
+		switch {
+		case tiling != nil:
+			found++
+			fmt.Printf("%dx%d: %d copies\n", r.w, r.h, tiling.Copies)
+			if smallest == 0 {
+				smallest = r.w * r.h
+				if err := tiling.WriteText(os.Stdout); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(exitError)
+				}
+			}
+		case !sure:
+			unknown++
+			fmt.Fprintf(os.Stderr, "%dx%d: gave up after %d placements\n", r.w, r.h, nodes)
+		}
+	}
+	rule := ""
+	switch {
+	case apart:
+		rule = " kept apart"
+	case faultFree:
+		rule = " without a fault"
+	}
+	switch {
+	case found > 0:
+		os.Exit(exitSolved)
+	case unknown > 0:
+		fmt.Fprintf(os.Stderr, "no rectangle up to %dx%d found that copies of piece %s%s tile, %d unsearched past the -budget\n", maxSize, maxSize, piece.Symbol, rule, unknown)
+		os.Exit(exitGaveUp)
+	}
+	fmt.Fprintf(os.Stderr, "copies of piece %s%s tile no rectangle up to %dx%d\n", piece.Symbol, rule, maxSize, maxSize)
+	os.Exit(exitUnsolvable)
+}
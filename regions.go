@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// regionCheck is set by -region-check for the Solvers of the linear and
+// multi backends, see Solver.RegionCheck.
+var regionCheck bool
+
+// regionsOf splits m into its regions of edge-connected cells, setting
+// cell[c] to the index of the region of each cell c of m.
+func regionsOf(m Mask, cell *[BoardDim * BoardDim]int) []Mask {
+	var regions []Mask
+	for y := uint(0); y < BoardDim; y++ {
+		for x := uint(0); x < BoardDim; x++ {
+			if m.At(x, y) == 0 {
+				continue
+			}
+			var region Mask
+			stack := [][2]uint{{x, y}}
+			m = m.AndBitWith(x, y, 0)
+			for len(stack) > 0 {
+				c := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				region = region.OrBitWith(c[0], c[1], 1)
+				cell[c[1]*BoardDim+c[0]] = len(regions)
+				for _, d := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+					nx, ny := int(c[0])+d[0], int(c[1])+d[1]
+					if nx < 0 || ny < 0 || nx >= BoardDim || ny >= BoardDim || m.At(uint(nx), uint(ny)) == 0 {
+						continue
+					}
+					m = m.AndBitWith(uint(nx), uint(ny), 0)
+					stack = append(stack, [2]uint{uint(nx), uint(ny)})
+				}
+			}
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// overcrowded checks that the pieces still to place can share out the
+// regions of the board their legal placements leave them. Every
+// placement, its cells being connected, lies in a single region, so a
+// set of regions has to hold all the pieces whose placements all lie in
+// it, and can't if their cells outnumber its own: it is Hall's condition
+// for matching the pieces' cells to the regions' that a piece fits in.
+// Not every set of regions is checked, only each region on its own and
+// the regions each piece could go in, which catches a region too small
+// for the pieces that could only go there even when the region is big
+// enough for any one of them. overcrowded returns a reason the branch is
+// a dead end, or "" if the pieces may yet fit.
+func (s *Solver) overcrowded() string {
+	remaining := make([]int, 0, len(s.g.Pieces)-len(s.chain))
+	var free Mask
+	for i, p := range s.g.Pieces {
+		if s.cands.Placed(i) {
+			continue
+		}
+		remaining = append(remaining, i)
+		set := s.cands.Sets[i]
+		for mi := set.Next(0); mi != -1; mi = set.Next(mi + 1) {
+			free = free.OrWith(p.Masks[mi])
+		}
+	}
+	var cell [BoardDim * BoardDim]int
+	regions := regionsOf(free, &cell)
+	if len(regions) > 64 {
+		return ""
+	}
+	// in[k] is the set of the regions the k'th remaining piece's
+	// placements lie in, one bit per region.
+	in := make([]uint64, len(remaining))
+	var sets []uint64
+	for r := range regions {
+		sets = append(sets, 1<<uint(r))
+	}
+	for k, i := range remaining {
+		set := s.cands.Sets[i]
+		for mi := set.Next(0); mi != -1; mi = set.Next(mi + 1) {
+			in[k] |= 1 << uint(cell[firstCell(s.g.Pieces[i].Masks[mi])])
+		}
+		if bits.OnesCount64(in[k]) > 1 {
+			sets = append(sets, in[k])
+		}
+	}
+	for _, set := range sets {
+		need, area := uint(0), uint(0)
+		var pieces []string
+		for k, i := range remaining {
+			if in[k] != 0 && in[k]&^set == 0 {
+				need += s.g.Pieces[i].Orientations[0].Mask.BitsSet()
+				pieces = append(pieces, s.g.Pieces[i].Symbol)
+			}
+		}
+		for ; set != 0; set &= set - 1 {
+			area += regions[bits.TrailingZeros64(set)].BitsSet()
+		}
+		if need > area {
+			return fmt.Sprintf("pieces %s need %d cells but the regions they fit in have %d", strings.Join(pieces, ", "), need, area)
+		}
+	}
+	return ""
+}
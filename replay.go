@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// readSolution returns the n'th solution, counting from 1, of those
+// written one after another in JSON to r, as by -output json.
+func readSolution(r io.Reader, n int) (SolutionJSON, error) {
+	dec := json.NewDecoder(r)
+	for i := 1; ; i++ {
+		var s SolutionJSON
+		if err := dec.Decode(&s); err == io.EOF {
+			return s, fmt.Errorf("the file has only %d solutions", i-1)
+		} else if err != nil {
+			return s, fmt.Errorf("solution %d: %v", i, err)
+		}
+		if i == n {
+			return s, nil
+		}
+	}
+}
+
+// replayStep draws the board after the first n placements of the chain.
+func replayStep(chain PieceChain, n int) string {
+	s := fmt.Sprintf("placement %d of %d", n, len(chain))
+	if n > 0 {
+		pm := chain[n-1]
+		pl := pm.Piece.Placements[pm.MaskIndex]
+		s += fmt.Sprintf(": %s in orientation %d at %d,%d", pm.Piece.Symbol, pl.Orientation, pl.X, pl.Y)
+	}
+	shown := chain[:n]
+	return s + "\n\n" + shown.grid(func(i int) string { return shown[i].Piece.Symbol })
+}
+
+// replayCommand implements `hreen replay FILE`.
+func replayCommand(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	pf := puzzleFlag(fs)
+	n := fs.Int("n", 1, "which solution in the file to replay, counting from 1")
+	delay := fs.Duration("delay", 0, "time between placements, 0 to step through them with the keyboard")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen replay [flags] FILE")
+		fmt.Fprintln(os.Stderr, "Shows how a solution printed by -output json comes together, one piece")
+		fmt.Fprintln(os.Stderr, "at a time in the order it was found.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	pieces := pf.pieces()
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	s, err := readSolution(f, *n)
+	f.Close()
+	var chain PieceChain
+	if err == nil {
+		chain, err = s.Chain(pieces)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		os.Exit(exitError)
+	}
+
+	if *delay > 0 {
+		for i := 0; i <= len(chain); i++ {
+			if i > 0 {
+				time.Sleep(*delay)
+			}
+			fmt.Println(replayStep(chain, i))
+		}
+		return
+	}
+
+	t, err := openTerminal()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	defer t.Close()
+	i := 0
+	for {
+		t.draw(replayStep(chain, i) + "\nspace or right for the next piece, left for the one before, q quits\n")
+		k, err := t.readKey()
+		if err != nil {
+			return
+		}
+		switch k {
+		case ' ', keyEnter, keyRight, 'l':
+			if i < len(chain) {
+				i++
+			}
+		case keyLeft, keyBackspace, 'h':
+			if i > 0 {
+				i--
+			}
+		case 'q', 3:
+			return
+		}
+	}
+}
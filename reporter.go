@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Reporter is told what the backends find and how their search is going,
+// so that the command line, the server and other callers can present it
+// as they like. The backends print nothing themselves.
+type Reporter interface {
+	// Solution is told about each solution found once it is verified,
+	// with when it was found if the backend knows.
+	Solution(chain PieceChain, found *Discovery)
+	// Message is told how the search is going, in one or more lines of
+	// text.
+	Message(text string)
+}
+
+// Silent is a Reporter that ignores everything.
+type Silent struct{}
+
+// Solution does nothing.
+func (Silent) Solution(PieceChain, *Discovery) {}
+
+// Message does nothing.
+func (Silent) Message(string) {}
+
+// WriterReporter is the Reporter of the command line. It writes
+// solutions to Solutions as by -output and messages to Messages, and is
+// safe for concurrent use.
+type WriterReporter struct {
+	Solutions, Messages io.Writer
+	// Format is how solutions are written, text, symbols or json.
+	Format string
+	// Timing adds when each solution was found, if the backend knows.
+	Timing bool
+
+	mu  sync.Mutex
+	err error
+}
+
+// Solution writes the solution.
+func (r *WriterReporter) Solution(chain PieceChain, found *Discovery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintln(r.Messages, " woohoo - we did it!!!!")
+	if !r.Timing {
+		found = nil
+	}
+	if found != nil && r.Format != "json" {
+		fmt.Fprintf(r.Messages, " solution %d after %v and %d placements\n", found.Index, found.Elapsed, found.Nodes)
+	}
+	if err := writeSolution(r.Solutions, r.Format, nil, found, chain); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// Message writes the text on a line of its own.
+func (r *WriterReporter) Message(text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintln(r.Messages, text)
+}
+
+// Err returns the first error writing a solution, if any.
+func (r *WriterReporter) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of a Job.
+const (
+	// JobQueued means the job waits for a worker.
+	JobQueued = "queued"
+	// JobRunning means a worker is solving the job's puzzle.
+	JobRunning = "running"
+	// JobDone means the search is over and the job has an Outcome.
+	JobDone = "done"
+	// JobFailed means the search broke down and the job has an Error.
+	JobFailed = "failed"
+)
+
+// Job is a puzzle submitted to `hreen serve` and what became of it.
+type Job struct {
+	// ID is the job's sequence number, zero padded so that IDs sort in
+	// the order the jobs were submitted.
+	ID        string     `json:"id"`
+	State     string     `json:"state"`
+	Puzzle    *Puzzle    `json:"puzzle"`
+	Submitted time.Time  `json:"submitted"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	// Restarts is the number of times the job was running when the
+	// server stopped and was queued again when it started.
+	Restarts int           `json:"restarts,omitempty"`
+	Outcome  string        `json:"outcome,omitempty"`
+	Nodes    uint64        `json:"nodes,omitempty"`
+	Solution *SolutionJSON `json:"solution,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Elapsed  time.Duration `json:"elapsed_ns,omitempty"`
+	// Client is who submitted the job, see jobServer.client, and
+	// MaxNodes the placements it asked the search to be cut off at, if
+	// fewer than the server's.
+	Client   string `json:"client,omitempty"`
+	MaxNodes uint64 `json:"max_nodes,omitempty"`
+}
+
+// JobQueue holds the jobs of `hreen serve`, keeping each in a JSON file
+// of its own in a directory so that queued and running jobs survive a
+// restart. Files are replaced atomically on every change of state.
+type JobQueue struct {
+	dir    string
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   map[string]*Job
+	queued []string
+	last   int
+}
+
+// OpenJobQueue opens the queue kept in dir, creating dir if needed. Jobs
+// that were running when the queue was last used are queued again, to
+// be solved from the start.
+func OpenJobQueue(dir string) (*JobQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	q := &JobQueue{dir: dir, jobs: map[string]*Job{}}
+	q.cond = sync.NewCond(&q.mu)
+	sort.Strings(paths)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		j := &Job{}
+		if err := json.Unmarshal(data, j); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		q.jobs[j.ID] = j
+		if n, err := strconv.Atoi(j.ID); err == nil && n > q.last {
+			q.last = n
+		}
+		if j.State == JobRunning {
+			j.State, j.Started = JobQueued, nil
+			j.Restarts++
+			if err := q.save(j); err != nil {
+				return nil, err
+			}
+		}
+		if j.State == JobQueued {
+			q.queued = append(q.queued, j.ID)
+		}
+	}
+	return q, nil
+}
+
+// save writes the job's file. The queue must be locked.
+func (q *JobQueue) save(j *Job) error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(q.dir, j.ID+".json"), append(data, '\n'))
+}
+
+// errTooManyJobs is returned by Submit for a client with as many jobs
+// queued and running as it may have.
+var errTooManyJobs = errors.New("too many jobs queued and running")
+
+// Submit queues a job to solve the puzzle for the client, cut off at
+// maxNodes placements if not 0. If limit is not 0 and the client already
+// has that many jobs queued or running it returns errTooManyJobs.
+func (q *JobQueue) Submit(p *Puzzle, client string, maxNodes uint64, limit int) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if limit > 0 {
+		active := 0
+		for _, j := range q.jobs {
+			if j.Client == client && (j.State == JobQueued || j.State == JobRunning) {
+				active++
+			}
+		}
+		if active >= limit {
+			return Job{}, errTooManyJobs
+		}
+	}
+	q.last++
+	j := &Job{ID: fmt.Sprintf("%06d", q.last), State: JobQueued, Puzzle: p, Client: client, MaxNodes: maxNodes, Submitted: time.Now().UTC()}
+	if err := q.save(j); err != nil {
+		q.last--
+		return Job{}, err
+	}
+	q.jobs[j.ID] = j
+	q.queued = append(q.queued, j.ID)
+	q.cond.Signal()
+	return *j, nil
+}
+
+// Take waits for a queued job, marks it running and returns it. If the
+// job's file can't be saved the job stays queued and Take returns it as
+// it was, with the error.
+func (q *JobQueue) Take() (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.queued) == 0 {
+		q.cond.Wait()
+	}
+	j := q.jobs[q.queued[0]]
+	running := *j
+	now := time.Now().UTC()
+	running.State, running.Started = JobRunning, &now
+	if err := q.save(&running); err != nil {
+		return *j, err
+	}
+	*j = running
+	q.queued = q.queued[1:]
+	return running, nil
+}
+
+// Finish records the job, taken before, as done or failed.
+func (q *JobQueue) Finish(j Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now().UTC()
+	j.Finished = &now
+	q.jobs[j.ID] = &j
+	return q.save(&j)
+}
+
+// Get returns the job with the ID.
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// List returns all the jobs in the order they were submitted.
+func (q *JobQueue) List() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID < jobs[b].ID })
+	return jobs
+}
+
+// Counts returns the number of jobs in each state.
+func (q *JobQueue) Counts() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	counts := map[string]int{JobQueued: 0, JobRunning: 0, JobDone: 0, JobFailed: 0}
+	for _, j := range q.jobs {
+		counts[j.State]++
+	}
+	return counts
+}
+
+// Busy returns the time spent solving jobs since a time, up to now for
+// the jobs still running.
+func (q *JobQueue) Busy(since time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	var busy time.Duration
+	for _, j := range q.jobs {
+		if j.Started == nil || j.State == JobQueued {
+			continue
+		}
+		start, end := *j.Started, now
+		if j.Finished != nil {
+			end = *j.Finished
+		}
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(start) {
+			busy += end.Sub(start)
+		}
+	}
+	return busy
+}
+
+// runJob solves the job's puzzle with the config and returns the job
+// done, or failed if the puzzle doesn't build or the solver panics.
+func runJob(j Job, config BatchConfig) (done Job) {
+	done = j
+	defer func() {
+		if e := recover(); e != nil {
+			done.State, done.Error = JobFailed, fmt.Sprint("panic: ", e)
+		}
+	}()
+	pieces, err := j.Puzzle.Build()
+	if err != nil {
+		done.State, done.Error = JobFailed, err.Error()
+		return done
+	}
+	built := append([]*Piece(nil), pieces...)
+	if j.MaxNodes > 0 && (config.Budget == 0 || j.MaxNodes < config.Budget) {
+		config.Budget = j.MaxNodes
+	}
+	start := time.Now()
+	chain, solver, outcome := solveWithin(pieces, config)
+	done.State, done.Outcome, done.Nodes, done.Elapsed = JobDone, outcome, solver.Nodes, time.Since(start)
+	if chain != nil {
+		puzzleMu.Lock()
+		usePuzzle(j.Puzzle, built)
+		s := NewSolutionJSON(chain)
+		puzzleMu.Unlock()
+		done.Solution = &s
+	}
+	return done
+}
+
+// version is the version of hreen, set when building a release with
+// -ldflags "-X main.version=...".
+var version = "devel"
+
+// maxPuzzleBytes is the size of the largest puzzle `hreen serve` takes.
+const maxPuzzleBytes = 1 << 20
+
+// jobServer is the HTTP API of `hreen serve`.
+type jobServer struct {
+	queue   *JobQueue
+	started time.Time
+	workers int
+	// tokens holds the names of the clients by token, or is nil to let
+	// anyone in. limiter, if not nil, limits each client's requests,
+	// maxJobs, if not 0, the jobs each may have queued and running and
+	// maxNodes, if not 0, the placements any job may try.
+	tokens   map[string]string
+	limiter  *rateLimiter
+	maxJobs  int
+	maxNodes uint64
+}
+
+// ServerStatus is what `hreen serve` reports at /status.
+type ServerStatus struct {
+	Version   string        `json:"version"`
+	GoVersion string        `json:"go_version"`
+	Started   time.Time     `json:"started"`
+	Uptime    time.Duration `json:"uptime_ns"`
+	Workers   int           `json:"workers"`
+	// Jobs is the number of jobs in each state.
+	Jobs map[string]int `json:"jobs"`
+	// Utilization is the share of the workers' time since the server
+	// started that went on solving jobs.
+	Utilization float64 `json:"utilization"`
+}
+
+// writeJSON writes v as the JSON response with the status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// jobs serves /jobs: GET lists the jobs and POST submits a puzzle, in
+// the JSON of puzzle files and up to maxPuzzleBytes, as a new job, cut
+// off at the placements of the max_nodes parameter if given. With
+// tokens each client only sees its own jobs.
+func (s *jobServer) jobs(w http.ResponseWriter, r *http.Request, client string) {
+	switch r.Method {
+	case http.MethodGet:
+		jobs := s.queue.List()
+		if s.tokens != nil {
+			own := jobs[:0]
+			for _, j := range jobs {
+				if j.Client == client {
+					own = append(own, j)
+				}
+			}
+			jobs = own
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		var maxNodes uint64
+		if v := r.URL.Query().Get("max_nodes"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil || n == 0 {
+				http.Error(w, fmt.Sprintf("bad max_nodes %q", v), http.StatusBadRequest)
+				return
+			}
+			if s.maxNodes > 0 && n > s.maxNodes {
+				http.Error(w, fmt.Sprintf("max_nodes may be at most %d", s.maxNodes), http.StatusBadRequest)
+				return
+			}
+			maxNodes = n
+		}
+		p := &Puzzle{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPuzzleBytes)).Decode(p); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("puzzle larger than %d bytes", maxPuzzleBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, fmt.Sprintf("bad puzzle: %v", err), http.StatusBadRequest)
+			return
+		}
+		if _, err := p.Build(); err != nil {
+			http.Error(w, fmt.Sprintf("bad puzzle: %v", err), http.StatusBadRequest)
+			return
+		}
+		j, err := s.queue.Submit(p, client, maxNodes, s.maxJobs)
+		if err == errTooManyJobs {
+			http.Error(w, fmt.Sprintf("%v, at most %d at a time", err, s.maxJobs), http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", "/jobs/"+j.ID)
+		writeJSON(w, http.StatusCreated, j)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// job serves GET /jobs/ID, the job with the ID, if it is the client's
+// with tokens.
+func (s *jobServer) job(w http.ResponseWriter, r *http.Request, client string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	j, ok := s.queue.Get(strings.TrimPrefix(r.URL.Path, "/jobs/"))
+	if !ok || s.tokens != nil && j.Client != client {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// healthz serves GET /healthz, answering ok as long as the server runs,
+// for load balancers.
+func (s *jobServer) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// status serves GET /status, the ServerStatus, for monitoring.
+func (s *jobServer) status(w http.ResponseWriter, r *http.Request, client string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	uptime := time.Since(s.started)
+	st := ServerStatus{
+		Version:   version,
+		GoVersion: runtime.Version(),
+		Started:   s.started.UTC(),
+		Uptime:    uptime,
+		Workers:   s.workers,
+		Jobs:      s.queue.Counts(),
+	}
+	if uptime > 0 {
+		st.Utilization = float64(s.queue.Busy(s.started)) / float64(uptime) / float64(s.workers)
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// serveCommand implements `hreen serve`.
+func serveCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	dir := fs.String("dir", "hreen-jobs", "directory to keep the jobs in")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of jobs to solve at a time")
+	budget := fs.Uint64("max-nodes", 100000000, "placements to try per job before giving up, 0 for no limit")
+	timeout := fs.Duration("timeout", 0, "time to search each job before giving up, 0 for no limit")
+	tokensPath := fs.String("tokens", "", "file of the clients allowed in, a line each with its name and the token it sends as Authorization: Bearer TOKEN")
+	rate := fs.Int("rate", 0, "requests each client may make a minute, 0 for no limit")
+	maxJobs := fs.Int("max-jobs", 0, "jobs each client may have queued and running at once, 0 for no limit")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen serve [flags]")
+		fmt.Fprintln(os.Stderr, "Solves puzzles submitted over HTTP. POST a puzzle file to /jobs to queue")
+		fmt.Fprintln(os.Stderr, "it, GET /jobs/ID for its state and solution and GET /jobs to list all")
+		fmt.Fprintln(os.Stderr, "the jobs. Jobs are kept in -dir and survive restarts. GET /healthz answers")
+		fmt.Fprintln(os.Stderr, "ok while the server runs and GET /status reports on it in JSON.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 0 || *workers < 1 || *rate < 0 || *maxJobs < 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	started := time.Now()
+	queue, err := OpenJobQueue(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	config := BatchConfig{Order: "shadow", Budget: *budget, Timeout: *timeout}
+	for w := 0; w < *workers; w++ {
+		go func() {
+			for {
+				j, err := queue.Take()
+				if err != nil {
+					// The job is still queued, try it again in a while.
+					fmt.Fprintf(os.Stderr, "job %s: %v\n", j.ID, err)
+					time.Sleep(time.Second)
+					continue
+				}
+				if err := queue.Finish(runJob(j, config)); err != nil {
+					fmt.Fprintf(os.Stderr, "job %s: %v\n", j.ID, err)
+				}
+			}
+		}()
+	}
+
+	s := &jobServer{queue: queue, started: started, workers: *workers, maxJobs: *maxJobs, maxNodes: *budget}
+	if *tokensPath != "" {
+		if s.tokens, err = loadTokens(*tokensPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+	}
+	if *rate > 0 {
+		s.limiter = newRateLimiter(*rate)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", s.guarded(s.jobs))
+	mux.HandleFunc("/jobs/", s.guarded(s.job))
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/status", s.guarded(s.status))
+	fmt.Fprintf(os.Stderr, "serving on %s, jobs in %s\n", *addr, *dir)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadTokens reads the clients allowed to use `hreen serve` from a file
+// with a line per client, its name and its token separated by spaces.
+// Blank lines and lines starting with # are skipped. It returns the
+// clients' names by token.
+func loadTokens(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tokens := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a client's name and its token", path, line)
+		}
+		if _, ok := tokens[fields[1]]; ok {
+			return nil, fmt.Errorf("%s:%d: token of %s given twice", path, line, fields[0])
+		}
+		tokens[fields[1]] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", path)
+	}
+	return tokens, nil
+}
+
+// rateLimiter lets each client make requests at a rate, in bursts of up
+// to as many as it may make a minute, refusing the others.
+type rateLimiter struct {
+	mu sync.Mutex
+	// perMinute is the requests a client may make a minute, and
+	// allowance the requests each client may still make at once as of
+	// when it last made one.
+	perMinute float64
+	allowance map[string]float64
+	last      map[string]time.Time
+	// pruned is when the clients idle for a minute were last dropped.
+	pruned time.Time
+}
+
+// newRateLimiter returns a limiter letting each client make perMinute
+// requests a minute.
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{perMinute: float64(perMinute), allowance: map[string]float64{}, last: map[string]time.Time{}}
+}
+
+// Allow returns whether the client may make a request now, counting it
+// if so, or else how long until it may.
+func (l *rateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.pruned) >= time.Minute {
+		l.prune(now)
+	}
+	allowance, ok := l.allowance[client]
+	if !ok {
+		allowance = l.perMinute
+	} else {
+		allowance = math.Min(l.perMinute, allowance+now.Sub(l.last[client]).Minutes()*l.perMinute)
+	}
+	l.last[client] = now
+	if allowance < 1 {
+		l.allowance[client] = allowance
+		return false, time.Duration((1 - allowance) / l.perMinute * float64(time.Minute))
+	}
+	l.allowance[client] = allowance - 1
+	return true, 0
+}
+
+// prune drops the clients that made no request for a minute, which have
+// their full allowance back, as if they had made none, so that the
+// limiter only holds the clients seen lately. The limiter must be
+// locked.
+func (l *rateLimiter) prune(now time.Time) {
+	for client, last := range l.last {
+		if now.Sub(last) >= time.Minute {
+			delete(l.last, client)
+			delete(l.allowance, client)
+		}
+	}
+	l.pruned = now
+}
+
+// client returns who made the request: the name of the client whose
+// token it bears if the server has tokens, or else the host it came
+// from. ok is false if the server has tokens and the request bears none
+// of them.
+func (s *jobServer) client(r *http.Request) (client string, ok bool) {
+	if s.tokens == nil {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return host, true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	client, ok = s.tokens[strings.TrimPrefix(auth, "Bearer ")]
+	return client, ok
+}
+
+// guarded wraps a handler of the API so that it only serves clients
+// with a token, if the server has tokens, and within the rate limit, if
+// it has one, passing it the client.
+func (s *jobServer) guarded(h func(w http.ResponseWriter, r *http.Request, client string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, ok := s.client(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="hreen"`)
+			http.Error(w, "a valid token is needed", http.StatusUnauthorized)
+			return
+		}
+		if s.limiter != nil {
+			if ok, wait := s.limiter.Allow(client); !ok {
+				w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		h(w, r, client)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// SolutionVersion is the version of the JSON structure of a solution. It
+// only goes up when a field changes meaning or goes away; new fields may
+// be added without it changing.
+const SolutionVersion = 1
+
+// SolutionJSON is the JSON structure of a solution, as printed by
+// -output json and `hreen archive -output json`:
+//
+//	{"version": 1, "id": 7, "placements": [
+//	  {"symbol": "+", "orientation": 0, "x": 3, "y": 0,
+//	   "cells": [[4, 0], [3, 1], [4, 1], [5, 1], [4, 2]]},
+//	  ...
+//	]}
+//
+// id is only there for solutions with a number, like those in an
+// archive, and found only with -timing. hash is the solution's Hash in
+// hexadecimal, as JSON numbers lose the low bits of 64-bit integers in
+// many languages.
+type SolutionJSON struct {
+	Version    int             `json:"version"`
+	ID         *uint64         `json:"id,omitempty"`
+	Hash       string          `json:"hash"`
+	Found      *Discovery      `json:"found,omitempty"`
+	Placements []PlacementJSON `json:"placements"`
+}
+
+// Discovery records when a search found a solution: the how manyth
+// solution it was, the time since the search started and the number of
+// placements tried by then. With the multi backend Nodes only counts the
+// placements of the search of the first piece's placement that found it.
+type Discovery struct {
+	Index   int           `json:"index"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+	Nodes   uint64        `json:"nodes"`
+}
+
+// PlacementJSON is the JSON structure of the placement of a piece in a
+// solution. Orientation indexes the distinct rotations and reflections
+// of the piece in the order hreen generates them, as in puzzle hints. X
+// and Y are the offset of the top left corner of the piece's bounding box
+// and Cells lists the cells it covers as x, y pairs.
+//
+// In a puzzle with several boards Board is the number of the board the
+// piece is on, counting from 1, and X, Y and Cells are relative to that
+// board.
+type PlacementJSON struct {
+	Symbol      string    `json:"symbol"`
+	Board       int       `json:"board,omitempty"`
+	Orientation int       `json:"orientation"`
+	X           uint      `json:"x"`
+	Y           uint      `json:"y"`
+	Cells       [][2]uint `json:"cells"`
+}
+
+// NewSolutionJSON returns the JSON structure of the solution.
+func NewSolutionJSON(c PieceChain) SolutionJSON {
+	s := SolutionJSON{Version: SolutionVersion, Hash: fmt.Sprintf("%016x", c.Hash()), Placements: make([]PlacementJSON, len(c))}
+	for i, pm := range c {
+		pl := pm.Piece.Placements[pm.MaskIndex]
+		p := PlacementJSON{Symbol: pm.Piece.Symbol, Orientation: pl.Orientation, X: pl.X, Y: pl.Y}
+		var x0, y0 uint
+		for b, spot := range boardSpots {
+			if pl.X >= spot.X && pl.X < spot.X+spot.Width && pl.Y >= spot.Y && pl.Y < spot.Y+spot.Height {
+				p.Board, x0, y0 = b+1, spot.X, spot.Y
+				p.X -= x0
+				p.Y -= y0
+			}
+		}
+		m := pm.Piece.Masks[pm.MaskIndex]
+		for y := uint(0); y < BoardDim; y++ {
+			for x := uint(0); x < BoardDim; x++ {
+				if m.At(x, y) == 1 {
+					p.Cells = append(p.Cells, [2]uint{x - x0, y - y0})
+				}
+			}
+		}
+		s.Placements[i] = p
+	}
+	return s
+}
+
+// MarshalJSON encodes the chain as a SolutionJSON.
+func (c PieceChain) MarshalJSON() ([]byte, error) {
+	return json.Marshal(NewSolutionJSON(c))
+}
+
+// Chain returns the solution as a chain of the given pieces. Cells are
+// not needed to place the pieces and are ignored.
+func (s SolutionJSON) Chain(pieces []*Piece) (PieceChain, error) {
+	if s.Version != SolutionVersion {
+		return nil, fmt.Errorf("solution version %d, want %d", s.Version, SolutionVersion)
+	}
+	bySymbol := map[string]*Piece{}
+	for _, p := range pieces {
+		bySymbol[p.Symbol] = p
+	}
+	var chain PieceChain
+	for _, pl := range s.Placements {
+		p, ok := bySymbol[pl.Symbol]
+		if !ok {
+			return nil, fmt.Errorf("unknown piece %q", pl.Symbol)
+		}
+		want := Placement{pl.Orientation, pl.X, pl.Y}
+		if pl.Board > 0 {
+			if pl.Board > len(boardSpots) {
+				return nil, fmt.Errorf("piece %s is on board %d, the puzzle has %d", pl.Symbol, pl.Board, len(boardSpots))
+			}
+			want.X += boardSpots[pl.Board-1].X
+			want.Y += boardSpots[pl.Board-1].Y
+		}
+		mi := -1
+		for i, other := range p.Placements {
+			if other == want {
+				mi = i
+				break
+			}
+		}
+		if mi < 0 {
+			return nil, fmt.Errorf("piece %s has no orientation %d at %d,%d", pl.Symbol, pl.Orientation, pl.X, pl.Y)
+		}
+		chain = append(chain, PieceMask{p, mi})
+	}
+	return chain, nil
+}
+
+// writeSolution writes the solution to w in format, text, symbols, json
+// or code, with the given id and discovery unless they are nil. Only
+// json has the discovery and the id.
+func writeSolution(w io.Writer, format string, id *uint64, found *Discovery, c PieceChain) error {
+	if format == "code" {
+		code, err := EncodeChainText(c, builtPieces)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, code)
+		return err
+	}
+	if format == "json" {
+		s := NewSolutionJSON(c)
+		s.ID = id
+		s.Found = found
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}
+	if format == "braille" {
+		return writeBraille(w, c)
+	}
+	if format == "prose" {
+		text := c.Prose()
+		if id != nil {
+			text = fmt.Sprintf("Solution %d, %s", *id, text)
+		}
+		_, err := fmt.Fprintln(w, text)
+		return err
+	}
+	label := chainLabel
+	if format == "symbols" {
+		label = c.symbol
+	}
+	var paint func(i int, cell string) string
+	if format == "ansi" {
+		paint = c.ansiPaint
+	}
+	grid := c.paintedBoards(label, paint)
+	if format == "symbols" {
+		grid += c.legend()
+	}
+	if id != nil {
+		_, err := fmt.Fprintf(w, "solution %d\n%s\n", *id, grid)
+		return err
+	}
+	_, err := fmt.Fprintln(w, grid)
+	return err
+}
+
+// outputFlag registers the -output flag on fs, and -palette for the
+// colours of -output ansi.
+func outputFlag(fs *flag.FlagSet) *string {
+	paletteFlag(fs)
+	return fs.String("output", "text", "how to print solutions: text with a label per piece, symbols with the pieces' own symbols and a legend, ansi like text with each piece in its colour for terminals, prose with a sentence per piece for screen readers, braille with the outlines of the pieces in Braille patterns, many solutions to a line, json with one solution per line, or code with one line of the text encoding of the placements per solution, for hreen show")
+}
+
+// checkOutput exits if format is not a known output format.
+func checkOutput(format string) {
+	if format != "text" && format != "symbols" && format != "ansi" && format != "prose" && format != "braille" && format != "json" && format != "code" {
+		fmt.Fprintf(os.Stderr, "unknown output %q, use text, symbols, ansi, prose, braille, json or code\n", format)
+		os.Exit(exitUsage)
+	}
+	checkPalette()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SolutionStore is somewhere to keep the solutions found by a search.
+type SolutionStore interface {
+	Append(c PieceChain) error
+	Close() error
+}
+
+// SolutionStores appends solutions to all of its stores.
+type SolutionStores []SolutionStore
+
+// Append appends the solution to each store, stopping at the first error.
+func (ss SolutionStores) Append(c PieceChain) error {
+	for _, s := range ss {
+		if err := s.Append(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all the stores and returns the first error.
+func (ss SolutionStores) Close() error {
+	var first error
+	for _, s := range ss {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// SolutionLog is an append-only file of solutions, one per line, that
+// survives the process crashing part way through an enumeration. Each
+// solution is written with a single write call and the file is synced to
+// disk every so many solutions.
+type SolutionLog struct {
+	mu        sync.Mutex
+	f         *os.File
+	syncEvery int
+	unsynced  int
+}
+
+// OpenSolutionLog opens the log at path for appending, creating it if
+// needed. A syncEvery of 0 or less syncs after every solution.
+func OpenSolutionLog(path string, syncEvery int) (*SolutionLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &SolutionLog{f: f, syncEvery: syncEvery}, nil
+}
+
+// logRecord returns the log line of a solution: the symbol, orientation
+// and x and y of each piece in chain order, as symbol:orientation:x:y.
+// Unlike mask indices these don't change when hints, a board or
+// -break-symmetry restrict the placements of a piece, so the line reads
+// back the same with the pieces as defined.
+func logRecord(c PieceChain) string {
+	fields := make([]string, len(c))
+	for i, p := range c {
+		pl := p.Piece.Placements[p.MaskIndex]
+		fields[i] = fmt.Sprintf("%s:%d:%d:%d", p.Piece.Symbol, pl.Orientation, pl.X, pl.Y)
+	}
+	return strings.Join(fields, " ") + "\n"
+}
+
+// parseLogRecord parses a log line written by logRecord back into a chain
+// of the given pieces. It returns an error if a piece is unknown or has
+// no such placement.
+func parseLogRecord(line string, pieces []*Piece) (PieceChain, error) {
+	bySymbol := map[string]*Piece{}
+	for _, p := range pieces {
+		bySymbol[p.Symbol] = p
+	}
+	var chain PieceChain
+	for _, field := range strings.Fields(line) {
+		// The symbol may itself hold colons, the numbers are the last
+		// three parts.
+		parts := strings.Split(field, ":")
+		if len(parts) < 4 {
+			return nil, fmt.Errorf("bad placement %q", field)
+		}
+		symbol := strings.Join(parts[:len(parts)-3], ":")
+		p, ok := bySymbol[symbol]
+		if !ok {
+			return nil, fmt.Errorf("unknown piece %q", symbol)
+		}
+		var n [3]int
+		for k, part := range parts[len(parts)-3:] {
+			v, err := strconv.Atoi(part)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("bad placement %q", field)
+			}
+			n[k] = v
+		}
+		want := Placement{n[0], uint(n[1]), uint(n[2])}
+		mi := -1
+		for i, pl := range p.Placements {
+			if pl == want {
+				mi = i
+				break
+			}
+		}
+		if mi < 0 {
+			return nil, fmt.Errorf("piece %s has no placement %q", symbol, field)
+		}
+		chain = append(chain, PieceMask{p, mi})
+	}
+	return chain, nil
+}
+
+// Append writes the solution to the log.
+func (l *SolutionLog) Append(c PieceChain) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.WriteString(logRecord(c)); err != nil {
+		return err
+	}
+	l.unsynced++
+	if l.unsynced >= l.syncEvery {
+		l.unsynced = 0
+		return l.f.Sync()
+	}
+	return nil
+}
+
+// Close syncs and closes the log.
+func (l *SolutionLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.f.Sync(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+// readSolutionLog returns the complete lines of the log at path and the
+// number of torn lines, left by a crash, that it skipped.
+func readSolutionLog(path string) (lines []string, torn int, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasSuffix(line, "\n") {
+			torn++
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, torn, nil
+}
+
+// writeFileAtomic replaces the file at path with data, never leaving a
+// partially written file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// MergeSolutionLogs writes the solutions of all the logs at paths to the
+// log at out, dropping duplicates and torn lines. Solutions are duplicates
+// if they place the same pieces the same way, whatever the order of the
+// pieces in the chain. out may be one of paths, it is replaced atomically.
+func MergeSolutionLogs(out string, paths ...string) (kept, dropped int, err error) {
+	set := NewDedupSet()
+	var buf bytes.Buffer
+	for _, path := range paths {
+		lines, torn, err := readSolutionLog(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		dropped += torn
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			sort.Strings(fields)
+			if !set.Add(strings.Join(fields, " ")) {
+				dropped++
+				continue
+			}
+			buf.WriteString(line)
+			kept++
+		}
+	}
+	return kept, dropped, writeFileAtomic(out, buf.Bytes())
+}
+
+// CompactSolutionLog rewrites the log at path without duplicate solutions
+// and without a torn last line left by a crash.
+func CompactSolutionLog(path string) (kept, dropped int, err error) {
+	return MergeSolutionLogs(path, path)
+}
+
+// compactLogCommand implements `hreen compact-log FILE...`.
+func compactLogCommand(args []string) {
+	fs := flag.NewFlagSet("compact-log", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen compact-log FILE...")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	for _, path := range fs.Args() {
+		kept, dropped, err := CompactSolutionLog(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(exitError)
+		}
+		fmt.Printf("%s: kept %d solutions, dropped %d\n", path, kept, dropped)
+	}
+}
+
+// mergeLogsCommand implements `hreen merge-logs OUT FILE...`.
+func mergeLogsCommand(args []string) {
+	fs := flag.NewFlagSet("merge-logs", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen merge-logs OUT FILE...")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	kept, dropped, err := MergeSolutionLogs(fs.Arg(0), fs.Args()[1:]...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	fmt.Printf("%s: kept %d solutions, dropped %d\n", fs.Arg(0), kept, dropped)
+}
@@ -0,0 +1,819 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fractionLevels is the number of levels of the search tree the Solver
+// estimates how much of the search space it explored from.
+const fractionLevels = 6
+
+// frame is one level of the Solver's search stack: the placements of a
+// piece to be tried, in order, and how many of them have been tried.
+type frame struct {
+	// piece is the index of the piece in the graph, or -1 if there is
+	// none left to place.
+	piece       int
+	maskIndices []int
+	next        int
+	// solved is set once a solution was found below the frame.
+	solved bool
+	// deepest is the most pieces placed at once below the frame.
+	deepest int
+	// dead is set if the frame's state was found in the Solver's Dead
+	// states, so it has nothing to try.
+	dead bool
+	// given is the number of placements given away by Split, which were
+	// cut off the end of maskIndices, and split is set on the frames
+	// they were given away at or below, which the Solver no longer
+	// searches all of.
+	given int
+	split bool
+}
+
+// Solver runs a depth first search of the search space one node at a
+// time, keeping its position in an explicit stack rather than on the
+// goroutine's call stack. This allows the search to be stopped and picked
+// up again at any point.
+//
+// A Solver is safe for concurrent use: while one goroutine runs Next(),
+// another can Pause() it, single Step() it and inspect its Chain() and
+// Candidates() before letting it Resume().
+type Solver struct {
+	mu      sync.Mutex
+	resumed *sync.Cond
+	paused  bool
+
+	g     *ConflictGraph
+	cands *Candidates
+	chain PieceChain
+	// order holds the index in the graph of each piece of the chain.
+	order []int
+	stack []frame
+	done  bool
+	// cancelled is set by Cancel to stop the search for good.
+	cancelled bool
+	// twins[i] is the index of the last piece before the i'th piece
+	// with the same shape, or -1. Twins are kept in increasing mask
+	// order so that swapping them doesn't count as another solution.
+	twins []int
+
+	// Nodes is the number of placements tried so far.
+	Nodes uint64
+	// levels[i] is the number of placements of the i'th piece of the
+	// graph tried. depth is the number of pieces placed. finished is 1
+	// once the search is done. They are updated atomically so that
+	// Progress doesn't have to wait for the lock.
+	levels   []uint64
+	depth    int32
+	finished int32
+	// fraction holds the bits of the float64 share of the search space
+	// explored, as estimated by updateFraction.
+	fraction uint64
+	// best is the longest chain the search has reached, bestDepth its
+	// length.
+	best      PieceChain
+	bestDepth int32
+
+	// Stats, if set, records how far the search gets below each
+	// placement, and the placements that got furthest are tried first.
+	Stats *SearchStats
+
+	// Explain, if set, is called with the chain and the reason whenever
+	// the search runs into a dead end with no more than ExplainDepth
+	// pieces placed.
+	Explain      func(chain PieceChain, reason string)
+	ExplainDepth int
+
+	// Dead, if set, remembers the states below which the search found
+	// no solution, and the search backtracks as soon as it reaches one
+	// of them again. It may be shared by Solvers of the same graph.
+	Dead DeadStates
+	// key is where stateKey builds the key of the current state.
+	key []byte
+	// slab is where the solutions returned are cut from, see
+	// newSolution.
+	slab []PieceMask
+
+	// Phases, if set, adds up the time every phaseSample'th step spends
+	// in each phase. sampling is set during such a step and steps counts
+	// the steps taken.
+	Phases   *PhaseTimes
+	sampling bool
+	steps    int
+
+	// Ordered, if set, tries the placements of each piece in the order
+	// of its masks, which is by orientation, then row, then column,
+	// rather than the likeliest first. The solutions then come in
+	// lexicographic order of their mask indices, piece by piece.
+	Ordered bool
+
+	// BorderFirst, if set and the Solver isn't Ordered, tries first the
+	// placements of each piece that leave the least of the board newly
+	// exposed, see sortByExposure, rather than those growing the chain's
+	// shadow the least. halos and board, the cells the pieces can
+	// reach, are what it works them out from.
+	BorderFirst bool
+	halos       [][]Mask
+	board       Mask
+
+	// Corners, if not 0 and the Solver isn't Ordered, tries first the
+	// placements of the first Corners pieces that cover one of the
+	// corners of the board, whatever the order of the others.
+	Corners int
+	corners Mask
+
+	// FailFirst, if set and the Solver isn't Ordered, places next
+	// whichever piece has the fewest placements left rather than the
+	// pieces in the graph's order, see nextPiece. It takes effect from
+	// the next piece to place.
+	FailFirst bool
+
+	// ForwardCheck, if set, ends the branch as soon as a placement
+	// leaves a piece still to place without a placement, see wipedOut,
+	// rather than once that piece's turn comes.
+	ForwardCheck bool
+
+	// RegionCheck, if set, ends the branch as soon as the pieces still
+	// to place can't share out the regions of the board left to them,
+	// see overcrowded.
+	RegionCheck bool
+
+	// Trace, if set, is called with the chain at every event of the
+	// search: TracePlace once a piece is placed, TraceBacktrack before
+	// it is taken back and TraceSolution at a solution. The last piece
+	// of the chain is the one placed or taken back. The chain is only
+	// valid until Trace returns.
+	Trace func(event string, chain PieceChain)
+
+	// OnPanic, if set, is called with a Dump of the state of the search
+	// if a step panics, before the panic carries on.
+	OnPanic func(d *SolverDump)
+
+	// MaxDepth, if not 0, stops the search at chains of MaxDepth pieces,
+	// which Next returns like solutions.
+	MaxDepth int
+
+	// Share is the share of the whole search space the Solver was given
+	// to search, by which progress weighs its Fraction, or 0 if not
+	// known. given is the share of that the Solver gave away with Split.
+	Share float64
+	given float64
+}
+
+// NewSolver returns a Solver that searches for all the ways of
+// completing prefix, which may place any of g.Pieces in any order.
+func NewSolver(g *ConflictGraph, prefix PieceChain) *Solver {
+	s := &Solver{
+		g:      g,
+		cands:  NewCandidates(g),
+		chain:  make(PieceChain, len(prefix), len(g.Pieces)),
+		levels: make([]uint64, len(g.Pieces)),
+	}
+	s.resumed = sync.NewCond(&s.mu)
+	s.twins = pieceTwins(g.Pieces)
+	copy(s.chain, prefix)
+	s.depth = int32(len(prefix))
+	s.best = append(PieceChain(nil), prefix...)
+	s.bestDepth = s.depth
+	for _, pm := range prefix {
+		i := g.Index(pm.Piece)
+		s.cands.Place(i, pm.MaskIndex)
+		s.order = append(s.order, i)
+	}
+	s.push()
+	return s
+}
+
+// push adds a frame for the piece following the chain with its legal
+// placements ordered by how little they grow the chain's shadow, or in
+// mask order if the Solver is Ordered.
+func (s *Solver) push() {
+	depth := len(s.chain)
+	if depth == len(s.g.Pieces) {
+		s.stack = append(s.stack, frame{piece: -1, deepest: depth})
+		return
+	}
+	if s.Dead != nil {
+		start := s.phaseStart()
+		dead := s.Dead.Dead(s.stateKey())
+		s.phaseEnd(PhaseMemo, start)
+		if dead {
+			s.stack = append(s.stack, frame{piece: -1, deepest: depth, dead: true})
+			return
+		}
+	}
+	if s.ForwardCheck {
+		if j := s.wipedOut(); j >= 0 {
+			if s.Explain != nil && depth <= s.ExplainDepth {
+				s.Explain(s.chain, deadEndReason(s.chain, s.g.Pieces[j], 0, s.remaining()))
+			}
+			if s.Stats != nil {
+				s.Stats.deadEnd(j)
+			}
+			s.stack = append(s.stack, frame{piece: -1, deepest: depth})
+			return
+		}
+	}
+	if s.RegionCheck {
+		if reason := s.overcrowded(); reason != "" {
+			if s.Explain != nil && depth <= s.ExplainDepth {
+				s.Explain(s.chain, reason)
+			}
+			s.stack = append(s.stack, frame{piece: -1, deepest: depth})
+			return
+		}
+	}
+	i := s.nextPiece()
+	piece := s.g.Pieces[i]
+	start := s.phaseStart()
+	chainShadow := s.chain.Shadow()
+	s.phaseEnd(PhaseShadow, start)
+
+	start = s.phaseStart()
+	maskIndices := placementsOf(s.cands, i, s.chain, s.twins)
+	s.phaseEnd(PhaseCandidates, start)
+	if len(maskIndices) == 0 && s.Explain != nil && depth <= s.ExplainDepth {
+		s.Explain(s.chain, deadEndReason(s.chain, piece, s.cands.Count(i), s.remaining()))
+	}
+	start = s.phaseStart()
+	switch {
+	case s.Ordered:
+	case s.BorderFirst:
+		s.sortByExposure(i, maskIndices)
+	default:
+		sort.Slice(maskIndices, func(i, j int) bool {
+			ibits := chainShadow.OrWith(piece.Masks[maskIndices[i]]).BitsSet()
+			jbits := chainShadow.OrWith(piece.Masks[maskIndices[j]]).BitsSet()
+			return ibits < jbits
+		})
+	}
+	if s.Stats != nil {
+		if s.cands.Count(i) == 0 {
+			s.Stats.deadEnd(i)
+		}
+		if !s.Ordered {
+			s.sortBySurvival(i, maskIndices)
+		}
+	}
+	if depth < s.Corners && !s.Ordered {
+		s.cornersFirst(i, maskIndices)
+	}
+	s.phaseEnd(PhaseSort, start)
+	s.stack = append(s.stack, frame{piece: i, maskIndices: maskIndices, deepest: depth})
+}
+
+// remaining returns the pieces not placed yet.
+func (s *Solver) remaining() []*Piece {
+	var pieces []*Piece
+	for i, p := range s.g.Pieces {
+		if !s.cands.Placed(i) {
+			pieces = append(pieces, p)
+		}
+	}
+	return pieces
+}
+
+// stateKey returns the key of the state of the search at the chain for
+// DeadStates: the number of pieces placed, the cells they cover and the
+// cells of the placed twins of pieces still to place, which those have
+// to be placed after. With FailFirst, placing the pieces in any order,
+// which pieces are placed is part of it too. The key is only valid until
+// the next call.
+func (s *Solver) stateKey() []byte {
+	var order []int
+	if s.FailFirst {
+		order = s.order
+	}
+	s.key = stateKey(s.key[:0], s.chain, order, s.twins)
+	return s.key
+}
+
+// stateKey appends the key of the search state at chain to key, see
+// Solver.stateKey. order holds the index of each piece of the chain, or
+// is nil if the chain places the first pieces in order. twins are the
+// pieces' twins, as from pieceTwins.
+func stateKey(key []byte, chain PieceChain, order, twins []int) []byte {
+	var occupied Mask
+	for _, pm := range chain {
+		occupied = occupied.OrWith(pm.Piece.Masks[pm.MaskIndex])
+	}
+	put := func(m Mask) {
+		for _, w := range m {
+			for b := uint(0); b < 64; b += 8 {
+				key = append(key, byte(w>>b))
+			}
+		}
+	}
+	key = append(key, byte(len(chain)), byte(len(chain)>>8))
+	put(occupied)
+	if order == nil {
+		for i := len(chain); i < len(twins); i++ {
+			if t := twins[i]; t >= 0 && t < len(chain) {
+				put(chain[t].Piece.Masks[chain[t].MaskIndex])
+			}
+		}
+		return key
+	}
+	placed := len(key)
+	for i := 0; i < (len(twins)+7)/8; i++ {
+		key = append(key, 0)
+	}
+	for _, i := range order {
+		key[placed+i/8] |= 1 << uint(i%8)
+	}
+	for i, t := range twins {
+		if t < 0 || key[placed+i/8]>>uint(i%8)&1 == 1 {
+			continue
+		}
+		for k, j := range order {
+			if j == t {
+				put(chain[k].Piece.Masks[chain[k].MaskIndex])
+			}
+		}
+	}
+	return key
+}
+
+// pieceTwins returns, for each piece, the index of the last piece before
+// it that it is interchangeable with, or -1.
+func pieceTwins(pieces []*Piece) []int {
+	twins := make([]int, len(pieces))
+	for i, p := range pieces {
+		twins[i] = -1
+		for j := i - 1; j >= 0; j-- {
+			if pieces[j].Interchangeable(p) {
+				twins[i] = j
+				break
+			}
+		}
+	}
+	return twins
+}
+
+// legalPlacements returns the mask indices of the placements of the piece
+// following chain, which places the first pieces in order, that cands
+// still has, in order, leaving out those that would put the piece before
+// its twin in mask order.
+func legalPlacements(cands *Candidates, chain PieceChain, twins []int) []int {
+	return placementsOf(cands, len(chain), chain, twins)
+}
+
+// placementsOf returns the legal placements of the i'th piece after
+// chain like legalPlacements, for a chain placing the pieces in any
+// order. The piece's twin must be placed.
+func placementsOf(cands *Candidates, i int, chain PieceChain, twins []int) []int {
+	piece := cands.g.Pieces[i]
+	var twin *PieceMask
+	if t := twins[i]; t != -1 {
+		for k := len(chain) - 1; k >= 0; k-- {
+			if chain[k].Piece == cands.g.Pieces[t] {
+				twin = &chain[k]
+				break
+			}
+		}
+	}
+	set := cands.Sets[i]
+	maskIndices := make([]int, 0, cands.Count(i))
+	for mi := set.Next(0); mi != -1; mi = set.Next(mi + 1) {
+		if twin != nil && !twin.Piece.Masks[twin.MaskIndex].Less(piece.Masks[mi]) {
+			continue
+		}
+		maskIndices = append(maskIndices, mi)
+	}
+	return maskIndices
+}
+
+// sortBySurvival moves the placements of the i'th piece that led
+// furthest in the search so far first, keeping the order of those that
+// did equally well. Placements not tried yet count as doing as well as
+// the piece's others on average.
+func (s *Solver) sortBySurvival(piece int, maskIndices []int) {
+	offset := s.g.Offsets[piece]
+	var sum float64
+	var n int
+	scores := make([]float64, len(maskIndices))
+	for i, mi := range maskIndices {
+		scores[i] = s.Stats.survival(offset + mi)
+		if scores[i] >= 0 {
+			sum += scores[i]
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	for i := range scores {
+		if scores[i] < 0 {
+			scores[i] = sum / float64(n)
+		}
+	}
+	sort.Stable(bySurvival{maskIndices, scores})
+}
+
+// bySurvival sorts mask indices by their scores, highest first.
+type bySurvival struct {
+	maskIndices []int
+	scores      []float64
+}
+
+func (b bySurvival) Len() int           { return len(b.maskIndices) }
+func (b bySurvival) Less(i, j int) bool { return b.scores[i] > b.scores[j] }
+func (b bySurvival) Swap(i, j int) {
+	b.maskIndices[i], b.maskIndices[j] = b.maskIndices[j], b.maskIndices[i]
+	b.scores[i], b.scores[j] = b.scores[j], b.scores[i]
+}
+
+// pop removes the top frame and the piece placed before it was pushed.
+func (s *Solver) pop() {
+	popped := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+	if len(s.stack) == 0 {
+		s.done = true
+		atomic.StoreInt32(&s.finished, 1)
+		return
+	}
+	if s.Dead != nil && !popped.solved && !popped.dead && !popped.split {
+		start := s.phaseStart()
+		s.Dead.Add(s.stateKey())
+		s.phaseEnd(PhaseMemo, start)
+	}
+	if s.Trace != nil {
+		s.Trace(TraceBacktrack, s.chain)
+	}
+	if s.Stats != nil {
+		last := s.chain[len(s.chain)-1]
+		s.Stats.tried(s.g.Offsets[s.order[len(s.order)-1]]+last.MaskIndex, popped.deepest)
+		if parent := &s.stack[len(s.stack)-1]; popped.deepest > parent.deepest {
+			parent.deepest = popped.deepest
+		}
+	}
+	s.chain = s.chain[:len(s.chain)-1]
+	s.order = s.order[:len(s.order)-1]
+	atomic.StoreInt32(&s.depth, int32(len(s.chain)))
+	start := s.phaseStart()
+	s.cands.Unplace()
+	s.phaseEnd(PhaseUnplace, start)
+}
+
+// step advances the search by a single placement or backtrack. It
+// returns a copy of the chain if the placement completed a solution.
+func (s *Solver) step() PieceChain {
+	if len(s.stack) <= fractionLevels {
+		defer s.updateFraction()
+	}
+	if s.Phases != nil {
+		s.steps++
+		if s.steps%phaseSample == 0 {
+			s.sampling = true
+			start := time.Now()
+			defer func() {
+				s.Phases.step(time.Since(start))
+				s.sampling = false
+			}()
+		}
+	}
+	top := &s.stack[len(s.stack)-1]
+	if top.next == len(top.maskIndices) {
+		if depth := len(s.chain); top.next > 0 && !top.solved && !top.split && s.Explain != nil && depth <= s.ExplainDepth {
+			reason := fmt.Sprintf("all %d placements of piece %s led to dead ends", top.next, s.g.Pieces[top.piece].Symbol)
+			if top.next == 1 {
+				reason = fmt.Sprintf("the only placement of piece %s led to a dead end", s.g.Pieces[top.piece].Symbol)
+			}
+			s.Explain(s.chain, reason)
+		}
+		s.pop()
+		return nil
+	}
+	mi := top.maskIndices[top.next]
+	top.next++
+
+	s.chain = append(s.chain, PieceMask{s.g.Pieces[top.piece], mi})
+	s.order = append(s.order, top.piece)
+	start := s.phaseStart()
+	s.cands.Place(top.piece, mi)
+	s.phaseEnd(PhasePlace, start)
+	s.Nodes++
+	atomic.AddUint64(&s.levels[top.piece], 1)
+	atomic.StoreInt32(&s.depth, int32(len(s.chain)))
+	if s.Trace != nil {
+		s.Trace(TracePlace, s.chain)
+	}
+	if len(s.chain) > len(s.best) {
+		s.best = append(s.best[:0], s.chain...)
+		atomic.StoreInt32(&s.bestDepth, int32(len(s.best)))
+	}
+	if len(s.chain) == s.MaxDepth && s.MaxDepth < len(s.g.Pieces) {
+		// Nothing to try below, but not a dead end either.
+		s.stack = append(s.stack, frame{piece: -1, deepest: len(s.chain)})
+		for i := range s.stack {
+			s.stack[i].solved = true
+		}
+		return append(PieceChain(nil), s.chain...)
+	}
+	s.push()
+
+	if len(s.chain) == len(s.g.Pieces) {
+		for i := range s.stack {
+			s.stack[i].solved = true
+		}
+		if s.Trace != nil {
+			s.Trace(TraceSolution, s.chain)
+		}
+		solution := s.newSolution()
+		copy(solution, s.chain)
+		return solution
+	}
+	return nil
+}
+
+// solutionSlab is the number of solutions a Solver allocates room for at
+// a time.
+const solutionSlab = 1024
+
+// newSolution returns room for a copy of a full chain. Rather than
+// allocating every solution of an enumeration, which may find millions,
+// on its own, the Solver cuts them from a slab it allocates for
+// solutionSlab of them at a time. The slab is freed once none of its
+// solutions are referenced any more, so keeping a solution keeps its
+// slab alive: callers keeping a few solutions out of many and caring
+// about memory may copy them.
+func (s *Solver) newSolution() PieceChain {
+	n := len(s.g.Pieces)
+	if len(s.slab) < n {
+		s.slab = make([]PieceMask, n*solutionSlab)
+	}
+	solution := PieceChain(s.slab[:n:n])
+	s.slab = s.slab[n:]
+	return solution
+}
+
+// updateFraction estimates the share of the search space explored from
+// the position in the top fractionLevels frames. Every placement of a
+// frame is taken to hold an equal share of the frame's share, so the
+// placements tried so far account for that many shares, less the one
+// still being explored below. The placements given away by Split are
+// never counted, as their new Solvers count them.
+func (s *Solver) updateFraction() {
+	f, share := 0.0, 1.0
+	if s.done {
+		f = 1 - s.given
+	}
+	for d := 0; d < len(s.stack) && d < fractionLevels; d++ {
+		fr := s.stack[d]
+		placements := len(fr.maskIndices) + fr.given
+		if d == len(s.stack)-1 {
+			if placements == 0 {
+				f += share
+			} else {
+				f += share * float64(fr.next) / float64(placements)
+			}
+			break
+		}
+		share /= float64(placements)
+		f += share * float64(fr.next-1)
+	}
+	atomic.StoreUint64(&s.fraction, math.Float64bits(f))
+}
+
+// Fraction returns an estimate of the share of the search space explored
+// so far, from 0 to 1. It only ever goes up and, like Progress, it doesn't
+// wait for the lock.
+func (s *Solver) Fraction() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.fraction))
+}
+
+// Split gives away the placements not tried yet of the shallowest piece
+// that has any left, but for the next piece to place, as new Solvers
+// searching their parts of the search space, which is how a search too
+// big for one worker is shared by several. The Solver carries on with
+// the rest. The new Solvers have their Share set and nothing else; Split
+// returns none if there is nothing left to give away.
+func (s *Solver) Split() []*Solver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || s.cancelled {
+		return nil
+	}
+	base := len(s.chain) - (len(s.stack) - 1)
+	share := 1.0
+	for i := 0; i < len(s.stack)-1; i++ {
+		fr := &s.stack[i]
+		placements := len(fr.maskIndices) + fr.given
+		share /= float64(placements)
+		if fr.next == len(fr.maskIndices) {
+			continue
+		}
+		depth := base + i
+		var subs []*Solver
+		for _, mi := range fr.maskIndices[fr.next:] {
+			prefix := append(append(PieceChain(nil), s.chain[:depth]...), PieceMask{s.g.Pieces[fr.piece], mi})
+			sub := NewSolver(s.g, prefix)
+			sub.Share = s.Share * share
+			subs = append(subs, sub)
+		}
+		fr.given += len(subs)
+		fr.maskIndices = fr.maskIndices[:fr.next]
+		for j := 0; j <= i; j++ {
+			s.stack[j].split = true
+		}
+		s.given += share * float64(len(subs))
+		return subs
+	}
+	return nil
+}
+
+// Next continues the search and returns the next solution or nil once
+// the search space is exhausted or the Solver is cancelled. While the
+// Solver is paused Next waits.
+func (s *Solver) Next() PieceChain {
+	if s.OnPanic != nil {
+		defer s.dumpOnPanic()
+	}
+	for {
+		// The lock is only held for a step at a time so that the
+		// other methods can get in between steps.
+		s.mu.Lock()
+		for s.paused && !s.cancelled {
+			s.resumed.Wait()
+		}
+		if s.done || s.cancelled {
+			s.mu.Unlock()
+			return nil
+		}
+		solution := s.step()
+		s.mu.Unlock()
+		if solution != nil {
+			return solution
+		}
+	}
+}
+
+// Step advances the search by up to n placements or backtracks and
+// returns the first solution found on the way, if any. Step works
+// whether or not the Solver is paused.
+func (s *Solver) Step(n int) PieceChain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.OnPanic != nil {
+		defer s.dumpOnPanic()
+	}
+	for ; n > 0 && !s.done && !s.cancelled; n-- {
+		if solution := s.step(); solution != nil {
+			return solution
+		}
+	}
+	return nil
+}
+
+// Cancel stops the search for good: Next() and Step() return nil from
+// then on, even if the Solver is paused.
+func (s *Solver) Cancel() {
+	s.mu.Lock()
+	s.cancelled = true
+	s.mu.Unlock()
+	s.resumed.Broadcast()
+}
+
+// Cancelled returns true if the Solver was cancelled before the search
+// space was exhausted.
+func (s *Solver) Cancelled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cancelled && !s.done
+}
+
+// Pause makes Next() wait before its next step until Resume() is called.
+func (s *Solver) Pause() {
+	s.mu.Lock()
+	s.paused = true
+	s.mu.Unlock()
+}
+
+// Resume lets a paused Next() carry on.
+func (s *Solver) Resume() {
+	s.mu.Lock()
+	s.paused = false
+	s.mu.Unlock()
+	s.resumed.Broadcast()
+}
+
+// Chain returns a copy of the partial chain the search is currently at.
+func (s *Solver) Chain() PieceChain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chain := make(PieceChain, len(s.chain))
+	copy(chain, s.chain)
+	return chain
+}
+
+// Candidates returns, for each piece not in the current chain, the mask
+// indices of its placements that are still legal. Placed pieces get nil.
+func (s *Solver) Candidates() [][]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cands := make([][]int, len(s.g.Pieces))
+	for i := range s.g.Pieces {
+		if s.cands.Placed(i) {
+			continue
+		}
+		set := s.cands.Sets[i]
+		cands[i] = []int{}
+		for mi := set.Next(0); mi != -1; mi = set.Next(mi + 1) {
+			cands[i] = append(cands[i], mi)
+		}
+	}
+	return cands
+}
+
+// Progress returns the number of placements tried so far, in all and of
+// each piece, the number of pieces currently placed and whether the
+// search is done. Unlike reading Nodes it is safe while another goroutine
+// runs Next(), and it doesn't wait for it.
+func (s *Solver) Progress() (nodes uint64, levels []uint64, depth int, done bool) {
+	levels = make([]uint64, len(s.levels))
+	for i := range levels {
+		levels[i] = atomic.LoadUint64(&s.levels[i])
+		nodes += levels[i]
+	}
+	return nodes, levels, int(atomic.LoadInt32(&s.depth)), atomic.LoadInt32(&s.finished) == 1
+}
+
+// Best returns a copy of the longest chain the search has reached so far,
+// a solution once it found one. Until it finds one it is the closest the
+// search came.
+func (s *Solver) Best() PieceChain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(PieceChain(nil), s.best...)
+}
+
+// BestDepth returns the length of Best without waiting for the lock.
+func (s *Solver) BestDepth() int {
+	return int(atomic.LoadInt32(&s.bestDepth))
+}
+
+// Done returns true once the search space is exhausted.
+func (s *Solver) Done() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+// CanComplete searches for up to budget for a way of placing the
+// remaining pieces around the partial solution chain, which may place its
+// pieces in any order. It returns the placements of the remaining pieces
+// completing chain, or nil if there is none. sure is false if the budget
+// ran out before the search could tell.
+func CanComplete(chain PieceChain, remaining []*Piece, budget time.Duration) (completion PieceChain, sure bool) {
+	// The remaining pieces are searched on their own, with only the
+	// placements that fit around chain. original and kept map them and
+	// their mask indices back to the remaining pieces.
+	pieces := make([]*Piece, len(remaining))
+	original := map[*Piece]*Piece{}
+	kept := map[*Piece][]int{}
+	for i, p := range remaining {
+		cp := *p
+		var indices []int
+		cp.restrict(func(mi int) bool {
+			for _, pm := range chain {
+				if !p.Masks[mi].AndWith(pm.Piece.Shadows[pm.MaskIndex]).Zero() ||
+					!pm.Piece.Masks[pm.MaskIndex].AndWith(p.Shadows[mi]).Zero() {
+					return false
+				}
+			}
+			indices = append(indices, mi)
+			return true
+		})
+		if len(cp.Masks) == 0 {
+			return nil, true
+		}
+		pieces[i] = &cp
+		original[&cp] = p
+		kept[&cp] = indices
+	}
+	if len(pieces) == 0 {
+		return PieceChain{}, true
+	}
+	sortPieces(pieces)
+
+	solver := NewSolver(NewConflictGraph(pieces), nil)
+	deadline := time.Now().Add(budget)
+	for !solver.Done() {
+		if time.Now().After(deadline) {
+			return nil, false
+		}
+		if solution := solver.Step(1 << 12); solution != nil {
+			for i, pm := range solution {
+				solution[i] = PieceMask{original[pm.Piece], kept[pm.Piece][pm.MaskIndex]}
+			}
+			return solution, true
+		}
+	}
+	return nil, true
+}
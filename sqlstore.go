@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sqlBatch is the number of solutions written per transaction, so that a
+// script cut short by a crash still loads all but the last batch.
+const sqlBatch = 1000
+
+// sqlSchema creates the tables of the solution database. Placements are
+// stored with their bounding box so that questions like "where does the +
+// piece touch the border" are plain SQL.
+const sqlSchema = `CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	started TEXT NOT NULL,
+	backend TEXT NOT NULL,
+	seed INTEGER NOT NULL,
+	pieces TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS solutions (
+	id INTEGER PRIMARY KEY,
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	hash TEXT NOT NULL,
+	grid TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS solutions_hash ON solutions(hash);
+CREATE TABLE IF NOT EXISTS placements (
+	solution_id INTEGER NOT NULL REFERENCES solutions(id),
+	piece TEXT NOT NULL,
+	mask_index INTEGER NOT NULL,
+	orientation INTEGER NOT NULL,
+	x INTEGER NOT NULL,
+	y INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL
+);
+`
+
+// SQLStore writes solutions, their canonical hashes, piece placements and
+// the run's metadata as a SQL script for SQLite. hreen has no
+// dependencies beyond the standard library so rather than linking a
+// SQLite driver the script is loaded with the sqlite3 shell:
+//
+//	sqlite3 solutions.db < solutions.sql
+type SQLStore struct {
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	batched int
+}
+
+// sqlQuote returns s as a SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// CreateSQLStore creates a SQL script at path, recording a run of the
+// given backend and seed over pieces.
+func CreateSQLStore(path, backend string, seed int64, pieces []*Piece) (*SQLStore, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLStore{f: f, w: bufio.NewWriter(f)}
+	symbols := make([]string, len(pieces))
+	for i, p := range pieces {
+		symbols[i] = p.Symbol
+	}
+	s.w.WriteString(sqlSchema)
+	fmt.Fprintf(s.w, "BEGIN;\nINSERT INTO runs (started, backend, seed, pieces) VALUES (%s, %s, %d, %s);\n",
+		sqlQuote(time.Now().UTC().Format(time.RFC3339)), sqlQuote(backend), seed, sqlQuote(strings.Join(symbols, " ")))
+	return s, nil
+}
+
+// Append writes the solution to the script.
+func (s *SQLStore) Append(c PieceChain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, "INSERT INTO solutions (run_id, hash, grid) VALUES ((SELECT max(id) FROM runs), %s, %s);\n",
+		sqlQuote(fmt.Sprintf("%016x", canonicalHash(canonicalKey(c)))), sqlQuote(c.String()))
+	for _, p := range c {
+		pl := p.Piece.Placements[p.MaskIndex]
+		o := p.Piece.Orientations[pl.Orientation]
+		fmt.Fprintf(s.w, "INSERT INTO placements VALUES ((SELECT max(id) FROM solutions), %s, %d, %d, %d, %d, %d, %d);\n",
+			sqlQuote(p.Piece.Symbol), p.MaskIndex, o.Transform, pl.X, pl.Y, o.Width, o.Height)
+	}
+	s.batched++
+	if s.batched >= sqlBatch {
+		s.batched = 0
+		s.w.WriteString("COMMIT;\nBEGIN;\n")
+		return s.w.Flush()
+	}
+	return nil
+}
+
+// Close finishes the script.
+func (s *SQLStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.WriteString("COMMIT;\n")
+	if err := s.w.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// Outcomes of a run.
+const (
+	// OutcomeSolved means at least one solution was found.
+	OutcomeSolved = "solved"
+	// OutcomeUnsolvable means the whole search space was searched
+	// without finding a solution.
+	OutcomeUnsolvable = "unsolvable"
+	// OutcomeGaveUp means the backend stopped without finding a
+	// solution or proving there is none.
+	OutcomeGaveUp = "gave up"
+	// OutcomeCancelled means the run was stopped from outside, as by
+	// interrupting hreen, before it was over.
+	OutcomeCancelled = "cancelled"
+)
+
+// RunSummary is the JSON summary of a run written by -summary, for
+// scripts aggregating the results of many runs.
+type RunSummary struct {
+	// PuzzleHash identifies the puzzle: runs of the same pieces, board,
+	// rules and hints have the same hash.
+	PuzzleHash string `json:"puzzle_hash"`
+	// Config holds the value of every flag of the run by name.
+	Config  map[string]string `json:"config"`
+	Stats   RunStats          `json:"stats"`
+	Outcome string            `json:"outcome"`
+}
+
+// NewRunSummary returns the summary of a run over pieces, configured by
+// the flags in fs, that ended with stats.
+func NewRunSummary(pieces []*Piece, fs *flag.FlagSet, stats RunStats) RunSummary {
+	s := RunSummary{
+		PuzzleHash: puzzleHash(pieces),
+		Config:     map[string]string{},
+		Stats:      stats,
+		Outcome:    stats.Outcome(),
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		s.Config[f.Name] = f.Value.String()
+	})
+	return s
+}
+
+// Outcome returns the outcome of a run with the stats.
+func (s RunStats) Outcome() string {
+	switch {
+	case s.Solutions > 0:
+		return OutcomeSolved
+	case s.Exhausted:
+		return OutcomeUnsolvable
+	}
+	return OutcomeGaveUp
+}
+
+// puzzleHash returns a hash of the placements and shadows of the pieces
+// in symbol order, which captures the board, rules and hints of the
+// puzzle as well as the shapes of its pieces.
+func puzzleHash(pieces []*Piece) string {
+	sorted := append([]*Piece(nil), pieces...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Symbol < sorted[j].Symbol })
+	h := fnv.New64a()
+	for _, p := range sorted {
+		h.Write([]byte(p.Symbol))
+		h.Write([]byte{0})
+		binary.Write(h, binary.LittleEndian, uint64(len(p.Masks)))
+		for mi := range p.Masks {
+			binary.Write(h, binary.LittleEndian, p.Masks[mi])
+			binary.Write(h, binary.LittleEndian, p.Shadows[mi])
+		}
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// WriteRunSummary writes the summary as JSON to the file at path, or to
+// stdout if path is -.
+func WriteRunSummary(path string, s RunSummary) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
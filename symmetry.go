@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Symmetry is a rotation or reflection of a rectangle of the board onto
+// itself: Transform as for Mask.Transformed, flipping horizontally if 4
+// or more and then rotating clockwise Transform%4 times by 90 degrees.
+type Symmetry struct {
+	Transform int
+	// X, Y, Width and Height are the rectangle.
+	X, Y, Width, Height uint
+}
+
+// String describes the symmetry like an orientation.
+func (s Symmetry) String() string {
+	return Orientation{Transform: s.Transform}.String()
+}
+
+// Map returns where the symmetry takes cell x, y of the rectangle.
+func (s Symmetry) Map(x, y uint) (uint, uint) {
+	x, y = x-s.X, y-s.Y
+	w, h := s.Width, s.Height
+	if s.Transform >= 4 {
+		x = w - 1 - x
+	}
+	for i := 0; i < s.Transform%4; i++ {
+		x, y = h-1-y, x
+		w, h = h, w
+	}
+	return x + s.X, y + s.Y
+}
+
+// MapMask returns the cells of m the symmetry takes the cells of m to.
+// Cells outside the rectangle are lost.
+func (s Symmetry) MapMask(m Mask) Mask {
+	var out Mask
+	for y := s.Y; y < s.Y+s.Height; y++ {
+		for x := s.X; x < s.X+s.Width; x++ {
+			if m.At(x, y) == 1 {
+				tx, ty := s.Map(x, y)
+				out = out.OrBitWith(tx, ty, 1)
+			}
+		}
+	}
+	return out
+}
+
+// rectangleSymmetries returns the symmetries of a rectangle, the identity
+// first: all eight for a square, the four that keep the sides where they
+// are for any other.
+func rectangleSymmetries(x, y, w, h uint) []Symmetry {
+	var syms []Symmetry
+	for t := 0; t < 8; t++ {
+		if t%2 == 1 && w != h {
+			continue
+		}
+		syms = append(syms, Symmetry{t, x, y, w, h})
+	}
+	return syms
+}
+
+// reach returns the cells any placement of any of the pieces covers.
+func reach(pieces []*Piece) Mask {
+	var m Mask
+	for _, p := range pieces {
+		for _, pm := range p.Masks {
+			m = m.OrWith(pm)
+		}
+	}
+	return m
+}
+
+// BoardSymmetries returns the symmetries of the smallest rectangle
+// holding the cells the pieces can reach, which is the board of a puzzle
+// with a single board. Solutions are taken to one another by them as
+// long as the board has no blocked cells or hints breaking them, see
+// PuzzleSymmetries.
+func BoardSymmetries(pieces []*Piece) []Symmetry {
+	x, y, w, h := reach(pieces).Bounds()
+	return rectangleSymmetries(x, y, w, h)
+}
+
+// Keeps returns true if the symmetry takes every placement of each piece
+// to another placement of the same piece, so that it takes solutions to
+// solutions. The shape of the board, blocked cells, hints and the layout
+// of several boards may all keep it from doing so.
+func (s Symmetry) Keeps(pieces []*Piece) bool {
+	for _, p := range pieces {
+		masks := make(map[Mask]bool, len(p.Masks))
+		for _, m := range p.Masks {
+			masks[m] = true
+		}
+		for _, m := range p.Masks {
+			if !masks[s.MapMask(m)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// PuzzleSymmetries returns the symmetries of the board that the pieces
+// keep, the identity first, and those they break.
+func PuzzleSymmetries(pieces []*Piece) (kept, broken []Symmetry) {
+	for _, s := range BoardSymmetries(pieces) {
+		if s.Keeps(pieces) {
+			kept = append(kept, s)
+		} else {
+			broken = append(broken, s)
+		}
+	}
+	return kept, broken
+}
+
+// BreakSymmetry restricts the placements of one of the pieces so that of
+// the solutions the symmetries take to one another the search finds few,
+// often one: it keeps only the placements that come first, in mask
+// order, of those the symmetries take them to. It picks a piece with no
+// other of the same shape, as pieces of the same shape can swap places,
+// preferring one with the fewest placements some symmetry leaves where
+// they are, as solutions placing it so may be found more than once. It
+// returns the piece, or nil if there is no symmetry or piece to break
+// them with, and the number of placements it had.
+func BreakSymmetry(pieces []*Piece, syms []Symmetry) (*Piece, int) {
+	if len(syms) < 2 {
+		return nil, 0
+	}
+	var best *Piece
+	bestFixed := 0
+	for i, p := range pieces {
+		if interchangeable(pieces, i) {
+			continue
+		}
+		fixed := 0
+		for _, m := range p.Masks {
+			for _, s := range syms[1:] {
+				if s.MapMask(m) == m {
+					fixed++
+					break
+				}
+			}
+		}
+		if best == nil || fixed < bestFixed {
+			best, bestFixed = p, fixed
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+	placements := len(best.Masks)
+	best.restrict(func(mi int) bool {
+		m := best.Masks[mi]
+		for _, s := range syms[1:] {
+			if s.MapMask(m).Less(m) {
+				return false
+			}
+		}
+		return true
+	})
+	return best, placements
+}
+
+// interchangeable returns true if the i'th piece is interchangeable with
+// another of the pieces.
+func interchangeable(pieces []*Piece, i int) bool {
+	for j, p := range pieces {
+		if j != i && p.Interchangeable(pieces[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// SymmetryCounter is a SolutionStore counting the solutions it is given
+// up to symmetry: solutions the symmetries of the puzzle take to one
+// another, and those only swapping interchangeable pieces, count once.
+// It counts the solutions that come first of those the symmetries take
+// them to, comparing their forms, which is one of each class, and by Burnside's
+// lemma checks that the solutions were closed under the symmetries, as a
+// full enumeration is.
+type SymmetryCounter struct {
+	mu   sync.Mutex
+	syms []Symmetry
+	// shapes numbers the pieces by shape, from 1, interchangeable pieces
+	// sharing a number.
+	shapes map[*Piece]byte
+	// Solutions is the number of solutions and Classes the number up to
+	// symmetry.
+	Solutions, Classes int
+	// fixed counts the solutions each symmetry takes to themselves.
+	fixed []int
+	// broken are the symmetries of the board the puzzle lacks.
+	broken []Symmetry
+	// seen holds the first form of each class counted, if the solutions
+	// counted may not include the first of each class.
+	seen map[string]bool
+}
+
+// NewSymmetryCounter returns a counter of the solutions of the pieces up
+// to the symmetries the puzzle has. If broken, the search breaks them
+// with BreakSymmetry, so the counter remembers the classes it counted
+// rather than counting the first solution of each.
+func NewSymmetryCounter(pieces []*Piece, broken bool) *SymmetryCounter {
+	syms, lacks := PuzzleSymmetries(pieces)
+	c := &SymmetryCounter{syms: syms, broken: lacks, shapes: map[*Piece]byte{}, fixed: make([]int, len(syms))}
+	if broken {
+		c.seen = map[string]bool{}
+	}
+	var shapes []*Piece
+	for _, p := range pieces {
+		for i, q := range shapes {
+			if q.Interchangeable(p) {
+				c.shapes[p] = byte(i + 1)
+				break
+			}
+		}
+		if c.shapes[p] == 0 {
+			shapes = append(shapes, p)
+			c.shapes[p] = byte(len(shapes))
+		}
+	}
+	return c
+}
+
+// form returns the form of the chain after the symmetry: the number of
+// the shape and the cells of each piece, in order, so that solutions only
+// swapping pieces of the same shape have the same form.
+func (c *SymmetryCounter) form(chain PieceChain, s Symmetry) string {
+	type shaped struct {
+		shape byte
+		cells Mask
+	}
+	pieces := make([]shaped, len(chain))
+	for i, pm := range chain {
+		m := pm.Piece.Masks[pm.MaskIndex]
+		if s.Transform != 0 {
+			m = s.MapMask(m)
+		}
+		pieces[i] = shaped{c.shapes[pm.Piece], m}
+	}
+	sort.Slice(pieces, func(i, j int) bool {
+		if pieces[i].shape != pieces[j].shape {
+			return pieces[i].shape < pieces[j].shape
+		}
+		return pieces[i].cells.Less(pieces[j].cells)
+	})
+	form := make([]byte, 0, len(pieces)*17)
+	for _, p := range pieces {
+		form = append(form, p.shape)
+		for _, w := range []uint64{p.cells[1], p.cells[0]} {
+			for b := 56; b >= 0; b -= 8 {
+				form = append(form, byte(w>>uint(b)))
+			}
+		}
+	}
+	return string(form)
+}
+
+// Append counts the solution.
+func (c *SymmetryCounter) Append(chain PieceChain) error {
+	own := c.form(chain, c.syms[0])
+	least := own
+	var fixed []int
+	for i, s := range c.syms {
+		f := own
+		if i > 0 {
+			f = c.form(chain, s)
+		}
+		if f == own {
+			fixed = append(fixed, i)
+		} else if f < least {
+			least = f
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Solutions++
+	switch {
+	case c.seen != nil:
+		if !c.seen[least] {
+			c.seen[least] = true
+			c.Classes++
+		}
+	case least == own:
+		c.Classes++
+	}
+	for _, i := range fixed {
+		c.fixed[i]++
+	}
+	return nil
+}
+
+// Close does nothing.
+func (c *SymmetryCounter) Close() error {
+	return nil
+}
+
+// Closed returns whether the solutions counted are closed under the
+// symmetries, so that the classes count whole classes.
+func (c *SymmetryCounter) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sum := 0
+	for _, n := range c.fixed {
+		sum += n
+	}
+	return sum == c.Classes*len(c.syms)
+}
+
+// WriteText writes the counts.
+func (c *SymmetryCounter) WriteText(w io.Writer) {
+	closed := c.Closed()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.syms[0]
+	fmt.Fprintf(w, "%d solutions, %d up to symmetry, the puzzle keeping %d of the %d symmetries of the %dx%d board\n", c.Solutions, c.Classes, len(c.syms), len(c.syms)+len(c.broken), s.Width, s.Height)
+	for i, sym := range c.syms[1:] {
+		fmt.Fprintf(w, "  %d unchanged %s\n", c.fixed[i+1], sym)
+	}
+	for _, sym := range c.broken {
+		fmt.Fprintf(w, "  not counting the board %s, which the blocked cells, hints or boards break\n", sym)
+	}
+	if c.seen == nil && !closed {
+		fmt.Fprintln(w, "  the solutions aren't closed under the symmetries: the search was cut short or the puzzle lacks some of them, so the classes may be partial")
+	}
+}
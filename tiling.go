@@ -0,0 +1,299 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Tiling fills the free cells of a board with copies of a single piece,
+// as many as it takes, as in asking whether a polyomino tiles the board.
+// Rather than placing a number of copies as pieces of their own, which
+// could swap places, it covers the first empty cell in reading order at
+// every step with any placement of the piece starting there, so the
+// copies are only told apart by where they go and each tiling is found
+// once. The copies of a tiling touch, so the no-touch rule doesn't apply.
+type Tiling struct {
+	piece *Piece
+	free  Mask
+	// first[c] are the mask indices of the placements of the piece whose
+	// first cell in reading order is cell c.
+	first [BoardDim * BoardDim][]int
+	// FaultFree, if set, only finds the tilings without a fault: a line
+	// between two rows or two columns of the board that no copy crosses,
+	// splitting the tiling into two. lines are the lines between rows
+	// and between columns that have free cells on both sides, bit y for
+	// the line below row y and bit x for the line right of column x, and
+	// crosses[mi] the lines placement mi crosses.
+	FaultFree bool
+	lines     lineSet
+	crosses   []lineSet
+	// Nodes is the number of placements tried.
+	Nodes uint64
+	// cut is set once the search was stopped before finding all the
+	// tilings.
+	cut bool
+}
+
+// NewTiling returns the tilings of the free cells with copies of the
+// piece, using the placements the piece has.
+func NewTiling(piece *Piece, free Mask) *Tiling {
+	t := &Tiling{piece: piece, free: free, crosses: make([]lineSet, len(piece.Masks))}
+	for mi, m := range piece.Masks {
+		if m.AndWith(free) == m {
+			c := firstCell(m)
+			t.first[c] = append(t.first[c], mi)
+		}
+		t.crosses[mi] = crossings(m)
+	}
+	for i := uint(0); i+1 < BoardDim; i++ {
+		var rows, cols [2]bool
+		for j := uint(0); j < BoardDim; j++ {
+			rows[0] = rows[0] || free.At(j, i) == 1
+			rows[1] = rows[1] || free.At(j, i+1) == 1
+			cols[0] = cols[0] || free.At(i, j) == 1
+			cols[1] = cols[1] || free.At(i+1, j) == 1
+		}
+		if rows[0] && rows[1] {
+			t.lines.rows |= 1 << i
+		}
+		if cols[0] && cols[1] {
+			t.lines.cols |= 1 << i
+		}
+	}
+	return t
+}
+
+// lineSet is a set of the lines between the rows and the columns of the
+// board, bit y of rows for the line below row y and bit x of cols for the
+// line right of column x.
+type lineSet struct {
+	rows, cols uint16
+}
+
+// crossings returns the lines the cells of m cross. As a piece's cells are
+// connected, those are the lines between its top and bottom rows and its
+// leftmost and rightmost columns.
+func crossings(m Mask) lineSet {
+	x, y, w, h := m.Bounds()
+	return lineSet{(1<<(h-1) - 1) << y, (1<<(w-1) - 1) << x}
+}
+
+// firstCell returns the index of the first occupied cell of m in reading
+// order, y*BoardDim+x, which must not be empty.
+func firstCell(m Mask) int {
+	if m[0] != 0 {
+		return bits.TrailingZeros64(m[0])
+	}
+	return 64 + bits.TrailingZeros64(m[1])
+}
+
+// Copies returns the number of copies of the piece a tiling takes, or 0
+// if the piece's cells don't divide the board's, so that there is none.
+func (t *Tiling) Copies() int {
+	cells, size := t.free.BitsSet(), t.piece.Orientations[0].Mask.BitsSet()
+	if cells%size != 0 {
+		return 0
+	}
+	return int(cells / size)
+}
+
+// Tile passes every tiling to sink, as a chain placing the copies in the
+// order they were placed, until the sink returns false or stop is closed.
+// The chain is only valid until sink returns. Tile returns true if it
+// found all the tilings.
+func (t *Tiling) Tile(sink Sink, stop <-chan struct{}) bool {
+	t.cut = false
+	if t.Copies() == 0 {
+		return true
+	}
+	sunk := !t.tile(Mask{}, lineSet{}, make(PieceChain, 0, t.Copies()), sink, stop)
+	return !sunk && !t.cut
+}
+
+// tile covers the free cells not yet covered, the copies placed crossing
+// the lines crossed. It returns false to end the search.
+func (t *Tiling) tile(covered Mask, crossed lineSet, chain PieceChain, sink Sink, stop <-chan struct{}) bool {
+	rest := Mask{t.free[0] &^ covered[0], t.free[1] &^ covered[1]}
+	if rest.Zero() {
+		if t.FaultFree && (t.lines.rows&^crossed.rows != 0 || t.lines.cols&^crossed.cols != 0) {
+			return true
+		}
+		return sink(chain)
+	}
+	c := firstCell(rest)
+	// The copies still to place start on the row of the first empty
+	// cell or below, so none of them can cross the lines above it.
+	if above := uint16(1)<<uint(c/BoardDim) - 1; t.FaultFree && t.lines.rows&above&^crossed.rows != 0 {
+		return true
+	}
+	for _, mi := range t.first[c] {
+		m := t.piece.Masks[mi]
+		if !m.AndWith(covered).Zero() {
+			continue
+		}
+		t.Nodes++
+		if t.Nodes%(1<<12) == 0 {
+			select {
+			case <-stop:
+				t.cut = true
+				return false
+			default:
+			}
+		}
+		lines := lineSet{crossed.rows | t.crosses[mi].rows, crossed.cols | t.crosses[mi].cols}
+		if !t.tile(covered.OrWith(m), lines, append(chain, PieceMask{t.piece, mi}), sink, stop) {
+			return false
+		}
+	}
+	return true
+}
+
+// tileCommand implements `hreen tile`.
+func tileCommand(args []string) {
+	fs := flag.NewFlagSet("tile", flag.ExitOnError)
+	pf := puzzleFlag(fs)
+	symbol := fs.String("piece", "", "symbol of the piece of the puzzle to tile the board with, by default its first")
+	all := fs.Bool("all", false, "print all the tilings rather than the first")
+	count := fs.Bool("count", false, "print the number of tilings instead")
+	output := outputFlag(fs)
+	shape := fs.String("shape", "", "file drawing the piece to tile with instead, as a shape in a puzzle file, one row to a line")
+	maxSize := fs.Int("max-size", 0, "rather than the board, look for the smallest rectangles up to this size on a side that the piece tiles")
+	noTouch := fs.Bool("no-touch", false, "with -max-size, keep the copies apart, not touching even at a corner")
+	faultFree := fs.Bool("fault-free", false, "only find tilings without a fault, a straight line across the board between copies")
+	budget := fs.Uint64("budget", 10000000, "with -max-size, placements to try on each rectangle before giving up on it, 0 for no limit")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen tile [flags]")
+		fmt.Fprintln(os.Stderr, "Fills the board of the puzzle with as many copies of one of its pieces")
+		fmt.Fprintln(os.Stderr, "as it takes, leaving out the other pieces and the hints, or with")
+		fmt.Fprintln(os.Stderr, "-max-size looks for the rectangles copies of the piece tile.")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 0 || *maxSize < 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *maxSize > 0 && *count {
+		fmt.Fprintln(os.Stderr, "-max-size finds a tiling of each rectangle, it can't -count them")
+		os.Exit(exitUsage)
+	}
+	if *noTouch && *maxSize == 0 {
+		fmt.Fprintln(os.Stderr, "-no-touch needs -max-size, copies filling a board touch")
+		os.Exit(exitUsage)
+	}
+	if *noTouch && *faultFree {
+		fmt.Fprintln(os.Stderr, "copies kept apart have gutters between them, -fault-free needs them to touch")
+		os.Exit(exitUsage)
+	}
+	checkOutput(*output)
+
+	p := *pf.puzzle()
+	if len(p.Pieces) == 0 {
+		fmt.Fprintln(os.Stderr, "the puzzle has no pieces")
+		os.Exit(exitError)
+	}
+	def := p.Pieces[0]
+	if *shape != "" {
+		data, err := ioutil.ReadFile(*shape)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		name := filepath.Base(*shape)
+		def = PieceDef{Symbol: strings.TrimSuffix(name, filepath.Ext(name)), Shape: strings.Split(strings.TrimRight(string(data), "\n"), "\n")}
+	} else if *symbol != "" {
+		found := false
+		for _, d := range p.Pieces {
+			if d.Symbol == *symbol {
+				def, found = d, true
+				break
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "the puzzle has no piece %s\n", *symbol)
+			os.Exit(exitUsage)
+		}
+	}
+	p.Pieces, p.Hints = []PieceDef{def}, nil
+	path := *pf.path
+	if path == "" {
+		path = "hreen"
+	}
+	free := Mask{^uint64(0), 1<<(BoardDim*BoardDim-64) - 1}
+	if p.Board != nil || len(p.Boards) > 0 {
+		var err error
+		if free, err = p.free(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(exitError)
+		}
+	}
+	if *maxSize > 0 {
+		pieces, err := (&Puzzle{Pieces: p.Pieces}).Build()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(exitError)
+		}
+		rectanglesPlay(pieces[0], *maxSize, *noTouch, *faultFree, *all, *budget)
+	}
+	piece := buildPieces(&p, path)[0]
+
+	tiling := NewTiling(piece, free)
+	tiling.FaultFree = *faultFree
+	if tiling.Copies() == 0 {
+		fmt.Fprintf(os.Stderr, "the %d cells of the board can't be split into pieces of %d\n", free.BitsSet(), piece.Orientations[0].Mask.BitsSet())
+		os.Exit(exitUnsolvable)
+	}
+	stop := make(chan struct{})
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		close(stop)
+	}()
+
+	w := solutionWriter(os.Stdout, *output)
+	tilings := 0
+	var werr error
+	start := time.Now()
+	complete := tiling.Tile(func(chain PieceChain) bool {
+		tilings++
+		if !*count {
+			werr = writeSolution(w, *output, nil, nil, chain)
+		}
+		return werr == nil && (*all || *count)
+	}, stop)
+	if werr == nil {
+		werr = flushSolutions(w)
+	}
+	if werr != nil {
+		fmt.Fprintln(os.Stderr, werr)
+		os.Exit(exitError)
+	}
+	if *count {
+		fmt.Println(tilings)
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if tilings == 1 && !*all && !*count {
+		fmt.Fprintf(os.Stderr, "tiled with %d copies of piece %s after %d placements in %v\n", tiling.Copies(), piece.Symbol, tiling.Nodes, elapsed)
+	} else {
+		fmt.Fprintf(os.Stderr, "%d tilings with %d copies of piece %s, after %d placements in %v\n", tilings, tiling.Copies(), piece.Symbol, tiling.Nodes, elapsed)
+	}
+	if tiling.cut {
+		fmt.Fprintln(os.Stderr, "stopped before finding them all")
+	}
+	switch {
+	case tilings > 0:
+		os.Exit(exitSolved)
+	case complete:
+		os.Exit(exitUnsolvable)
+	}
+	os.Exit(exitGaveUp)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events of a traced search, see Solver.Trace.
+const (
+	// TracePrefix is a piece a search was given placed, before it
+	// started, as the searches of the multi backend are.
+	TracePrefix = "prefix"
+	// TracePlace is a piece placed.
+	TracePlace = "place"
+	// TraceBacktrack is a piece taken back.
+	TraceBacktrack = "backtrack"
+	// TraceSolution is a solution found.
+	TraceSolution = "solution"
+)
+
+// TraceEvent is an event of a traced search, as -trace writes them, a
+// line of JSON each.
+type TraceEvent struct {
+	Event string `json:"event"`
+	// Search numbers the Solvers of the run from 0 in the order they
+	// started.
+	Search int `json:"search"`
+	// Depth is the number of pieces placed with the piece of the event.
+	Depth     int    `json:"depth"`
+	Piece     string `json:"piece"`
+	MaskIndex int    `json:"mask_index"`
+	// Mask is the cells of the placement, in hexadecimal as written by
+	// Mask.MarshalText, so that traces can be drawn without the puzzle.
+	Mask string `json:"mask"`
+	// Elapsed is the time from the start of the run.
+	Elapsed time.Duration `json:"elapsed_ns"`
+}
+
+// traces is where -trace writes the events of the Solvers of the linear
+// and multi backends, or nil without it.
+var traces *traceFile
+
+// traceFile writes the events of the Solvers added to a file as lines of
+// JSON, down to a depth.
+type traceFile struct {
+	mu       sync.Mutex
+	file     *os.File
+	w        *bufio.Writer
+	maxDepth int
+	searches int
+	start    time.Time
+	err      error
+}
+
+// createTrace creates the file to write traces to, recording only the
+// events with at most maxDepth pieces placed, or all of them if maxDepth
+// is 0.
+func createTrace(path string, maxDepth int) (*traceFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &traceFile{file: f, w: bufio.NewWriter(f), maxDepth: maxDepth, start: time.Now()}, nil
+}
+
+// Add traces the Solver from then on, starting with the pieces it was
+// given placed.
+func (t *traceFile) Add(s *Solver) {
+	t.mu.Lock()
+	search := t.searches
+	t.searches++
+	t.mu.Unlock()
+	chain := s.Chain()
+	for i := range chain {
+		t.write(search, TracePrefix, chain[:i+1])
+	}
+	s.Trace = func(event string, chain PieceChain) {
+		t.write(search, event, chain)
+	}
+}
+
+// write writes the event of the search at the chain, unless it is too
+// deep.
+func (t *traceFile) write(search int, event string, chain PieceChain) {
+	if t.maxDepth > 0 && len(chain) > t.maxDepth {
+		return
+	}
+	pm := chain[len(chain)-1]
+	mask, _ := pm.Piece.Masks[pm.MaskIndex].MarshalText()
+	e := TraceEvent{event, search, len(chain), pm.Piece.Symbol, pm.MaskIndex, string(mask), time.Since(t.start)}
+	data, err := json.Marshal(e)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		_, err = t.w.Write(append(data, '\n'))
+	}
+	if err != nil && t.err == nil {
+		t.err = err
+	}
+}
+
+// Close writes out the events left and closes the file, returning the
+// first error writing them.
+func (t *traceFile) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.w.Flush()
+	if cerr := t.file.Close(); err == nil {
+		err = cerr
+	}
+	if t.err != nil {
+		err = t.err
+	}
+	return err
+}
+
+// readTrace reads the events of a trace.
+func readTrace(r io.Reader) ([]TraceEvent, error) {
+	var events []TraceEvent
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e TraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// traceChainAt replays the events of the search of the index'th event up
+// to it and returns the pieces it had placed then, drawn from the masks
+// of the events.
+func traceChainAt(events []TraceEvent, index int) (PieceChain, error) {
+	var chain PieceChain
+	search := events[index].Search
+	for _, e := range events[:index+1] {
+		if e.Search != search {
+			continue
+		}
+		switch e.Event {
+		case TracePrefix, TracePlace:
+			var m Mask
+			if err := m.UnmarshalText([]byte(e.Mask)); err != nil {
+				return nil, err
+			}
+			chain = append(chain[:e.Depth-1], PieceMask{&Piece{Symbol: e.Piece, Masks: []Mask{m}}, 0})
+		case TraceBacktrack:
+			chain = chain[:e.Depth-1]
+		}
+	}
+	return chain, nil
+}
+
+// writeTraceStats writes the numbers of events of each kind, how far and
+// how long the searches went and the placements made at each depth and
+// of each piece.
+func writeTraceStats(w io.Writer, events []TraceEvent) {
+	kinds := map[string]int{}
+	byDepth := map[int]int{}
+	byPiece := map[string]int{}
+	searches := map[int]bool{}
+	deepest := 0
+	var elapsed time.Duration
+	for _, e := range events {
+		kinds[e.Event]++
+		searches[e.Search] = true
+		if e.Event == TracePlace {
+			byDepth[e.Depth]++
+			byPiece[e.Piece]++
+		}
+		if e.Depth > deepest {
+			deepest = e.Depth
+		}
+		if e.Elapsed > elapsed {
+			elapsed = e.Elapsed
+		}
+	}
+	fmt.Fprintf(w, "%d events of %d searches over %v\n", len(events), len(searches), elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "%d placements, %d backtracks, %d solutions, at most %d pieces placed\n", kinds[TracePlace], kinds[TraceBacktrack], kinds[TraceSolution], deepest)
+	fmt.Fprintln(w, "placements by depth:")
+	for d := 1; d <= deepest; d++ {
+		if byDepth[d] > 0 {
+			fmt.Fprintf(w, "  %3d %d\n", d, byDepth[d])
+		}
+	}
+	pieces := make([]string, 0, len(byPiece))
+	for p := range byPiece {
+		pieces = append(pieces, p)
+	}
+	sort.Slice(pieces, func(i, j int) bool {
+		if byPiece[pieces[i]] != byPiece[pieces[j]] {
+			return byPiece[pieces[i]] > byPiece[pieces[j]]
+		}
+		return pieces[i] < pieces[j]
+	})
+	fmt.Fprintln(w, "placements by piece:")
+	for _, p := range pieces {
+		fmt.Fprintf(w, "  %-4s %d\n", p, byPiece[p])
+	}
+}
+
+// writeFlame writes the wall time of the searches of a trace as folded
+// stacks, the input of flamegraph.pl and the tools like it: a line per
+// chain of placements, a frame per placement from the top level down
+// like L:0;N:4, ending with the nanoseconds spent at that chain. The
+// time from an event of a search to its next is spent at the chain the
+// event leaves it at, and the time of chains deeper than maxDepth, if not
+// 0, counts toward the chain of their first maxDepth placements. A flame
+// graph of it then shows how much of the run went on each top level
+// placement and on each branch below it.
+func writeFlame(w io.Writer, events []TraceEvent, maxDepth int) {
+	type search struct {
+		frames []string
+		last   time.Duration
+	}
+	searches := map[int]*search{}
+	spent := map[string]time.Duration{}
+	var stacks []string
+	for _, e := range events {
+		s := searches[e.Search]
+		if s == nil {
+			s = &search{last: e.Elapsed}
+			searches[e.Search] = s
+		}
+		frames := s.frames
+		if maxDepth > 0 && len(frames) > maxDepth {
+			frames = frames[:maxDepth]
+		}
+		if len(frames) > 0 && e.Elapsed > s.last {
+			stack := strings.Join(frames, ";")
+			if _, ok := spent[stack]; !ok {
+				stacks = append(stacks, stack)
+			}
+			spent[stack] += e.Elapsed - s.last
+		}
+		s.last = e.Elapsed
+		switch e.Event {
+		case TracePrefix, TracePlace:
+			s.frames = append(s.frames[:e.Depth-1], fmt.Sprintf("%s:%d", e.Piece, e.MaskIndex))
+		case TraceBacktrack:
+			s.frames = s.frames[:e.Depth-1]
+		}
+	}
+	for _, stack := range stacks {
+		fmt.Fprintf(w, "%s %d\n", stack, spent[stack])
+	}
+}
+
+// traceCommand implements `hreen trace`.
+func traceCommand(args []string) {
+	fs := flag.NewFlagSet("trace", flag.ExitOnError)
+	minDepth := fs.Int("min-depth", 0, "only show the events with at least this many pieces placed")
+	maxDepth := fs.Int("max-depth", 0, "only show the events with at most this many pieces placed, 0 for any number")
+	piece := fs.String("piece", "", "only show the events of the piece with this symbol")
+	search := fs.Int("search", -1, "only show the events of this search, -1 for all")
+	stats := fs.Bool("stats", false, "print statistics of the events shown instead of the events")
+	at := fs.Int("at", -1, "draw the pieces placed by the search of the event with this index, just after it, instead")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen trace view [flags] FILE")
+		fmt.Fprintln(os.Stderr, "       hreen trace flame [-max-depth N] FILE")
+		fmt.Fprintln(os.Stderr, "Shows the events of a search recorded by hreen -trace, one to a line")
+		fmt.Fprintln(os.Stderr, "with its index, or statistics of them, or the board at one of them.")
+		fmt.Fprintln(os.Stderr, "flame writes the time spent below each placement as folded stacks")
+		fmt.Fprintln(os.Stderr, "for flamegraph.pl, down to -max-depth pieces placed.")
+		fs.PrintDefaults()
+	}
+	if listingFlags && len(args) == 0 {
+		// Completing before view or flame is typed, list the flags
+		// they share as parseFlags does.
+		parseFlags(fs, args)
+	}
+	if len(args) == 0 || args[0] != "view" && args[0] != "flame" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	parseFlags(fs, args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	events, err := readTrace(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		os.Exit(exitError)
+	}
+
+	if args[0] == "flame" {
+		w := bufio.NewWriter(os.Stdout)
+		writeFlame(w, events, *maxDepth)
+		if err := w.Flush(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitError)
+		}
+		return
+	}
+	if *at >= 0 {
+		if *at >= len(events) {
+			fmt.Fprintf(os.Stderr, "%s has %d events, there is no event %d\n", fs.Arg(0), len(events), *at)
+			os.Exit(exitUsage)
+		}
+		chain, err := traceChainAt(events, *at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+			os.Exit(exitError)
+		}
+		e := events[*at]
+		fmt.Printf("after event %d, %s %s of search %d:\n%s", *at, e.Event, e.Piece, e.Search, chain.grid(chain.symbol))
+		return
+	}
+
+	var shown []TraceEvent
+	w := bufio.NewWriter(os.Stdout)
+	for i, e := range events {
+		if e.Depth < *minDepth || *maxDepth > 0 && e.Depth > *maxDepth || *piece != "" && e.Piece != *piece || *search >= 0 && e.Search != *search {
+			continue
+		}
+		if *stats {
+			shown = append(shown, e)
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%v\tsearch %d\tdepth %d\t%s %s %d\n", i, e.Elapsed, e.Search, e.Depth, e.Event, e.Piece, e.MaskIndex)
+	}
+	if *stats {
+		writeTraceStats(w, shown)
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+}
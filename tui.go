@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// key is a key pressed in a terminal editor: the character typed, or one
+// of the cursor keys.
+type key rune
+
+const (
+	keyUp key = -1 - iota
+	keyDown
+	keyLeft
+	keyRight
+	keyEnter     key = '\r'
+	keyBackspace key = 127
+	keyEscape    key = 27
+)
+
+// terminal is the terminal the editors run in, switched to raw mode so
+// that they see every key as it is pressed.
+type terminal struct {
+	in  *bufio.Reader
+	out io.Writer
+	// state is the terminal's settings before, as saved by stty.
+	state string
+}
+
+// stty runs stty on the terminal and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// openTerminal switches the terminal on stdin to raw mode. Close switches
+// it back.
+func openTerminal() (*terminal, error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("stdin is not a terminal: %v", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	t := &terminal{in: bufio.NewReader(os.Stdin), out: os.Stdout, state: state}
+	fmt.Fprint(t.out, "\x1b[?25l")
+	return t, nil
+}
+
+// Close clears the screen and restores the terminal.
+func (t *terminal) Close() {
+	fmt.Fprint(t.out, "\x1b[2J\x1b[H\x1b[?25h")
+	stty(t.state)
+}
+
+// readKey waits for a key to be pressed.
+func (t *terminal) readKey() (key, error) {
+	r, _, err := t.in.ReadRune()
+	if err != nil || r != rune(keyEscape) {
+		return key(r), err
+	}
+	// Cursor keys come as escape [ and a letter, a lone escape is
+	// just that.
+	if t.in.Buffered() == 0 {
+		return keyEscape, nil
+	}
+	if b, _ := t.in.ReadByte(); b != '[' {
+		return keyEscape, nil
+	}
+	b, err := t.in.ReadByte()
+	switch b {
+	case 'A':
+		return keyUp, err
+	case 'B':
+		return keyDown, err
+	case 'C':
+		return keyRight, err
+	case 'D':
+		return keyLeft, err
+	}
+	return keyEscape, err
+}
+
+// draw replaces the screen with s.
+func (t *terminal) draw(s string) {
+	fmt.Fprint(t.out, "\x1b[2J\x1b[H"+strings.Replace(s, "\n", "\r\n", -1))
+}
+
+// prompt shows the question below screen and returns the line typed in
+// answer, or "" if it is cancelled with escape.
+func (t *terminal) prompt(screen, question string) (string, error) {
+	var answer []rune
+	for {
+		t.draw(screen + "\n" + question + string(answer) + "_")
+		k, err := t.readKey()
+		switch {
+		case err != nil:
+			return "", err
+		case k == keyEnter:
+			return string(answer), nil
+		case k == keyEscape:
+			return "", nil
+		case k == keyBackspace:
+			if len(answer) > 0 {
+				answer = answer[:len(answer)-1]
+			}
+		case k >= ' ':
+			answer = append(answer, rune(k))
+		}
+	}
+}
+
+// moveCursor moves the cursor at x, y within a width by height grid if k
+// is a cursor key or one of h, j, k and l. It returns false for any other
+// key.
+func moveCursor(k key, x, y *int, width, height int) bool {
+	switch k {
+	case keyUp, 'k':
+		if *y > 0 {
+			*y--
+		}
+	case keyDown, 'j':
+		if *y < height-1 {
+			*y++
+		}
+	case keyLeft, 'h':
+		if *x > 0 {
+			*x--
+		}
+	case keyRight, 'l':
+		if *x < width-1 {
+			*x++
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// drawCells draws a width by height grid of cells, each drawn by cell,
+// with the one under the cursor at x, y highlighted.
+func drawCells(width, height, x, y int, cell func(x, y int) string) string {
+	s := strings.Builder{}
+	for cy := 0; cy < height; cy++ {
+		for cx := 0; cx < width; cx++ {
+			c := cell(cx, cy) + " "
+			if cx == x && cy == y {
+				c = "\x1b[7m" + c[:1] + "\x1b[0m "
+			}
+			s.WriteString(c)
+		}
+		s.WriteByte('\n')
+	}
+	return s.String()
+}
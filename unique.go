@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// CountSolutions finds up to limit solutions of the puzzle made of pieces,
+// trying at most budget placements, or any number if budget is 0. The
+// second result is false if the budget ran out before finding limit
+// solutions or exhausting the search space.
+func CountSolutions(pieces []*Piece, limit, budget int) ([]PieceChain, bool) {
+	var found []PieceChain
+	solver := NewSolver(NewConflictGraph(pieces), nil)
+	for len(found) < limit && !solver.Done() {
+		if budget > 0 && solver.Nodes >= uint64(budget) {
+			return found, false
+		}
+		if solution := solver.Step(1 << 12); solution != nil {
+			found = append(found, solution)
+		}
+	}
+	return found, true
+}
+
+// uniqueCommand implements `hreen unique PUZZLE`. It exits with 0 if the
+// puzzle has exactly one solution, exitUnsolvable if it has none and 1 if
+// it has more.
+func uniqueCommand(args []string) {
+	fs := flag.NewFlagSet("unique", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hreen unique PUZZLE")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	pieces := loadPieces(fs.Arg(0))
+	sortPieces(pieces)
+
+	found, _ := CountSolutions(pieces, 2, 0)
+	switch len(found) {
+	case 0:
+		fmt.Println("no solution")
+		os.Exit(exitUnsolvable)
+	case 1:
+		fmt.Printf("unique solution:\n%s", found[0])
+	default:
+		fmt.Printf("not unique, for example:\n%s\n%s", found[0], found[1])
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workerCount is the number of Solvers -backend multi runs at once, set
+// by -workers, or 0 to tune it to the machine.
+var workerCount int
+
+// loadEvery is how often a tuned workerPool checks how busy the machine
+// is.
+const loadEvery = 2 * time.Second
+
+// workerPool runs tasks on a number of workers. A tuned pool starts with
+// a worker per CPU and, while other programs keep some of the CPUs busy,
+// lets fewer of them take new tasks, so that the search shares the
+// machine rather than fighting over it.
+type workerPool struct {
+	workers int
+	tuned   bool
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// queue holds the tasks waiting for a worker.
+	queue []func()
+	// allowed is the number of workers that may run tasks at once and
+	// running the number that do.
+	allowed, running int
+	// busy is the time the workers spent running tasks.
+	busy time.Duration
+}
+
+// newWorkerPool returns a pool of n workers, or of a worker per CPU,
+// tuned, if n is 0.
+func newWorkerPool(n int) *workerPool {
+	p := &workerPool{workers: n}
+	if n <= 0 {
+		p.workers, p.tuned = runtime.NumCPU(), true
+	}
+	p.allowed = p.workers
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// machineLoad returns the load average of the last minute, the number of
+// threads running or waiting to, or false where the system doesn't say.
+func machineLoad() (float64, bool) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	return load, err == nil
+}
+
+// tune sets the number of workers allowed to run to the CPUs the rest of
+// the machine leaves free, at least one.
+func (p *workerPool) tune() {
+	load, ok := machineLoad()
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	others := int(math.Round(load)) - p.running
+	allowed := p.workers - others
+	if allowed < 1 {
+		allowed = 1
+	}
+	if allowed > p.workers {
+		allowed = p.workers
+	}
+	if allowed != p.allowed {
+		p.allowed = allowed
+		p.cond.Broadcast()
+	}
+	p.mu.Unlock()
+}
+
+// run runs the tasks, and those submitted while they run, and returns
+// once they are all done.
+func (p *workerPool) run(tasks []func()) {
+	p.mu.Lock()
+	p.queue = append(p.queue, tasks...)
+	p.mu.Unlock()
+	stop := make(chan struct{})
+	if p.tuned {
+		go func() {
+			ticker := time.NewTicker(loadEvery)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					p.tune()
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	wg := sync.WaitGroup{}
+	for w := 0; w < p.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				p.mu.Lock()
+				for len(p.queue) == 0 || p.running >= p.allowed {
+					if len(p.queue) == 0 && p.running == 0 {
+						// Nothing left to run and nothing running
+						// that could submit more.
+						p.cond.Broadcast()
+						p.mu.Unlock()
+						return
+					}
+					p.cond.Wait()
+				}
+				task := p.queue[0]
+				p.queue = p.queue[1:]
+				p.running++
+				p.mu.Unlock()
+
+				start := time.Now()
+				task()
+				p.mu.Lock()
+				p.running--
+				p.busy += time.Since(start)
+				p.cond.Broadcast()
+				p.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+}
+
+// submit queues a task. It may only be called while one of the pool's
+// tasks is still running, or the pool may have finished.
+func (p *workerPool) submit(task func()) {
+	p.mu.Lock()
+	p.queue = append(p.queue, task)
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// hungry returns true if a worker allowed to run a task has none to run.
+func (p *workerPool) hungry() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue) == 0 && p.running < p.allowed
+}
+
+// efficiency returns the share of the time the workers could have spent
+// running jobs during elapsed that they did.
+func (p *workerPool) efficiency(elapsed time.Duration) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.busy) / (float64(elapsed) * float64(p.workers))
+}
+
+// splitEvery is how often a splitter looks for workers without work, and
+// splitAfter how long a search has to have run to be split.
+const (
+	splitEvery = 50 * time.Millisecond
+	splitAfter = 200 * time.Millisecond
+)
+
+// splitter keeps the workers of a pool busy until the end of a search
+// split into many. Splitting the search by the placements of the first
+// piece leaves it waiting on the few that turn out to hold most of the
+// search space, so whenever a worker runs out of searches the splitter
+// splits the one that has run longest and queues its parts.
+type splitter struct {
+	pool *workerPool
+	// search runs a Solver to the end.
+	search func(s *Solver)
+
+	mu      sync.Mutex
+	running map[*Solver]time.Time
+	// splits counts the Solvers split off.
+	splits int
+	stop   chan struct{}
+	done   sync.WaitGroup
+}
+
+// newSplitter returns a splitter running Solvers with search in pool.
+func newSplitter(pool *workerPool, search func(s *Solver)) *splitter {
+	return &splitter{pool: pool, search: search, running: map[*Solver]time.Time{}, stop: make(chan struct{})}
+}
+
+// run searches with s, taking it into account for splitting meanwhile.
+// run is what the pool's tasks call.
+func (sp *splitter) run(s *Solver) {
+	sp.mu.Lock()
+	sp.running[s] = time.Now()
+	sp.mu.Unlock()
+	sp.search(s)
+	// Taking the lock makes sure a split of s has queued its parts
+	// before the task ends, so that the pool can't finish without them.
+	sp.mu.Lock()
+	delete(sp.running, s)
+	sp.mu.Unlock()
+}
+
+// watch starts splitting searches every splitEvery until Stop is called.
+func (sp *splitter) watch() {
+	sp.done.Add(1)
+	go func() {
+		defer sp.done.Done()
+		ticker := time.NewTicker(splitEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sp.split()
+			case <-sp.stop:
+				return
+			}
+		}
+	}()
+}
+
+// split splits the search that has run longest, if a worker has nothing
+// to do and the search has run for splitAfter at least.
+func (sp *splitter) split() {
+	if !sp.pool.hungry() {
+		return
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	var oldest *Solver
+	var since time.Time
+	for s, started := range sp.running {
+		if oldest == nil || started.Before(since) {
+			oldest, since = s, started
+		}
+	}
+	if oldest == nil || time.Since(since) < splitAfter {
+		return
+	}
+	for _, sub := range oldest.Split() {
+		sub := sub
+		sp.splits++
+		sp.pool.submit(func() { sp.run(sub) })
+	}
+	// The parts left behind are smaller, so give others a turn before
+	// splitting it again.
+	sp.running[oldest] = time.Now()
+}
+
+// Stop stops splitting searches and returns the number split off.
+func (sp *splitter) Stop() int {
+	close(sp.stop)
+	sp.done.Wait()
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.splits
+}